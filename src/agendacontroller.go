@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AgendaController handles the Web Methods for the agenda page.
+type AgendaController struct {
+	Srv *Server
+}
+
+// AgendaPageData holds the data used to write to the agenda page.
+type AgendaPageData struct {
+	Days      []AgendaDay      // Days shown on the page
+	Calendars []AgendaCalendar // Calendars that can be shown on the page
+	NoDays    int              // Number of days shown
+	Refresh   int              // Number of seconds between page refreshes, 0 to not refresh
+	Kiosk     bool             // Hide the controls, for wall displays
+	Dark      bool             // Show light text on a dark background
+	TimeZone  string           // Time zone specified in the query string, if any
+	Profile   string           // Display profile specified in the query string, if any
+	Updated   string           // Time the page was written
+}
+
+// AgendaDay holds the events of a day shown on the agenda page.
+type AgendaDay struct {
+	Name   string     // Name of the day
+	Date   string     // Display date of the day
+	Today  bool       // Indicates the day is today
+	Events []CalEvent // Events on the day
+}
+
+// AgendaCalendar holds the details of a calendar that can be shown on the agenda page.
+type AgendaCalendar struct {
+	ID       string // Identifier of the calendar
+	Name     string // Name of the calendar
+	Colour   string // Colour of the calendar
+	Selected bool   // Indicates the calendar is shown
+}
+
+// AddController adds the controller routes to the router
+func (c *AgendaController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Path("/agenda.html").Handler(http.HandlerFunc(c.handleAgendaWebPage))
+}
+
+func (c *AgendaController) handleAgendaWebPage(w http.ResponseWriter, r *http.Request) {
+	t := template.Must(template.ParseFiles("./html/agenda.html"))
+
+	cal := &CalendarController{Srv: c.Srv}
+	q, err := cal.getEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	qs := r.URL.Query()
+	v := AgendaPageData{NoDays: 7, Refresh: 300, TimeZone: qs.Get("tz"), Profile: qs.Get("profile")}
+	if d := qs.Get("days"); d != "" {
+		if v.NoDays, err = strconv.Atoi(d); err != nil || v.NoDays < 1 || v.NoDays > 366 {
+			http.Error(w, fmt.Sprintf("Invalid number of days '%s'", d), 500)
+			return
+		}
+	}
+	if d := qs.Get("refresh"); d != "" {
+		if v.Refresh, err = strconv.Atoi(d); err != nil || v.Refresh < 0 {
+			http.Error(w, fmt.Sprintf("Invalid refresh interval '%s'", d), 500)
+			return
+		}
+	}
+	if v.Kiosk, err = parseQueryBool(qs.Get("kiosk")); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if v.Dark, err = parseQueryBool(qs.Get("dark")); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Only the calendars of the profile can be selected
+	p, _ := c.Srv.Config.GetProfile(v.Profile)
+	for _, cc := range c.Srv.Config.Snapshot().Calendars {
+		if len(p.Calendars) != 0 && !containsString(p.Calendars, cc.ID) && !containsString(p.Calendars, cc.Name) {
+			continue
+		}
+		v.Calendars = append(v.Calendars, AgendaCalendar{
+			ID:       cc.ID,
+			Name:     cc.Name,
+			Colour:   cc.Colour,
+			Selected: containsString(q.Calendars, cc.ID),
+		})
+	}
+
+	// Show whole days, starting with today
+	now := time.Now().In(q.Location)
+	q.Start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, q.Location)
+	q.End = q.Start.AddDate(0, 0, v.NoDays)
+	q.SplitDays = true
+	el, _ := cal.getEvents(r.Context(), q)
+	v.Days = getAgendaDays(q.Start, v.NoDays, el)
+	v.Updated = now.Format("15:04")
+
+	if err := t.Execute(w, v); err != nil {
+		http.Error(w, "Error getting web page. "+err.Error(), 500)
+	}
+}
+
+// getAgendaDays groups the events by the day they start on, for each of the days from the start day.
+// Events that started before the start day are shown on the start day.
+func getAgendaDays(start time.Time, noDays int, el []CalEvent) []AgendaDay {
+	l := []AgendaDay{}
+	for i := 0; i < noDays; i++ {
+		ds := start.AddDate(0, 0, i)
+		de := start.AddDate(0, 0, i+1)
+		d := AgendaDay{
+			Name:   ds.Weekday().String(),
+			Date:   ds.Format("2 January 2006"),
+			Today:  i == 0,
+			Events: []CalEvent{},
+		}
+		for _, e := range el {
+			if e.Start.Before(de) && (i == 0 || !e.Start.Before(ds)) {
+				d.Events = append(d.Events, e)
+			}
+		}
+		l = append(l, d)
+	}
+	return l
+}
+
+// parseQueryBool parses a boolean query string parameter, which is false if it is not specified.
+func parseQueryBool(v string) (bool, error) {
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value '%s'", v)
+	}
+	return b, nil
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *AgendaController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("AgendaController: [Inf] ", a[1:len(a)-1])
+}
+
+// LogError is used to log error messages for this controller.
+func (c *AgendaController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("AgendaController: [Err] ", a[1:len(a)-1])
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanShowAgenda(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testagenda1", Name: "Home", Colour: "Lime", Provider: "Test"}, CalConfig{ID: "testagenda2", Name: "Work", Colour: "Red", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/agenda.html?days=3&calendar=Home&dark=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	b := w.Body.String()
+	if n := strings.Count(b, `<h3 class="uk-heading-divider">`); n != 3 {
+		t.Errorf("Wrong number of days shown. Expected %d, got %d", 3, n)
+	}
+	if n := strings.Count(b, `class="agenda-event"`); n != 1 {
+		t.Errorf("Wrong number of events shown. Expected %d, got %d", 1, n)
+	}
+	if !strings.Contains(b, `value="testagenda1" checked`) || strings.Contains(b, `value="testagenda2" checked`) {
+		t.Error("Selected calendars not shown")
+	}
+	if !strings.Contains(b, "uk-light") || !strings.Contains(b, `http-equiv="refresh"`) {
+		t.Error("Dark mode or refresh not applied")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/agenda.html?days=lots", nil))
+	if w.Code == http.StatusOK {
+		t.Error("No error returned for an invalid number of days")
+	}
+}
+
+func TestAgendaGroupsEventsByDay(t *testing.T) {
+	st := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	el := []CalEvent{
+		{Summary: "Late", Start: st.Add(-time.Hour), End: st.Add(time.Hour), InProgress: true},
+		{Summary: "Standup", Start: st.Add(9 * time.Hour), End: st.Add(10 * time.Hour)},
+		{Summary: "Dentist", Start: st.Add(39 * time.Hour), End: st.Add(40 * time.Hour)},
+	}
+	l := getAgendaDays(st, 3, el)
+	if len(l) != 3 {
+		t.Fatalf("Wrong number of days. Expected %d, got %d", 3, len(l))
+	}
+	if len(l[0].Events) != 2 || len(l[1].Events) != 1 || len(l[2].Events) != 0 {
+		t.Errorf("Events not grouped by day. Got %d, %d, %d", len(l[0].Events), len(l[1].Events), len(l[2].Events))
+	}
+	if l[1].Name != "Friday" || l[1].Date != "2 March 2018" {
+		t.Errorf("Wrong day name. Got %s %s", l[1].Name, l[1].Date)
+	}
+}
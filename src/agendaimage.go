@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Inks that the agenda image is drawn with.  Each ink is mapped to a colour of the panel palette.
+const (
+	inkWhite = iota
+	inkBlack
+	inkAccent
+)
+
+// imageOptions holds the options used to draw an agenda image.
+type imageOptions struct {
+	Width  int        // Width of the image, in pixels
+	Height int        // Height of the image, in pixels
+	Depth  int        // Number of colours of the panel, 1 for black and white or 3 for black, white and the accent colour
+	Accent color.RGBA // Third colour of a 3 colour panel, usually red or yellow
+	Rotate int        // Clockwise rotation of the agenda on the panel, in degrees: 0, 90, 180 or 270
+}
+
+// imageItem is a rectangle or line of text to be drawn on an agenda image.
+type imageItem struct {
+	X, Y int     // Top left of a rectangle, or left of the text baseline
+	W, H int     // Size of a rectangle
+	Text string  // Text to draw, blank for a rectangle
+	Size float64 // Font size of the text, in pixels
+	Bold bool    // Indicates the text is bold
+	Ink  int     // Ink used to draw the item
+}
+
+var (
+	imageFonts    [2]*opentype.Font
+	imageFontsErr error
+	imageFontOnce sync.Once
+)
+
+// getImageFace returns a font face for the bundled Go fonts, which are used so that images can be drawn offline.
+func getImageFace(size float64, bold bool) (font.Face, error) {
+	imageFontOnce.Do(func() {
+		if imageFonts[0], imageFontsErr = opentype.Parse(goregular.TTF); imageFontsErr != nil {
+			return
+		}
+		imageFonts[1], imageFontsErr = opentype.Parse(gobold.TTF)
+	})
+	if imageFontsErr != nil {
+		return nil, imageFontsErr
+	}
+	f := imageFonts[0]
+	if bold {
+		f = imageFonts[1]
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// validate checks the options and returns the size of the agenda before it is rotated.
+func (o imageOptions) validate() (int, int, error) {
+	if o.Width < 100 || o.Height < 50 || o.Width > 4000 || o.Height > 4000 {
+		return 0, 0, fmt.Errorf("Invalid image size %dx%d", o.Width, o.Height)
+	}
+	if o.Depth != 1 && o.Depth != 3 {
+		return 0, 0, fmt.Errorf("Invalid depth %d.  Use 1 for black and white, or 3 for 3 colour panels", o.Depth)
+	}
+	switch o.Rotate {
+	case 0, 180:
+		return o.Width, o.Height, nil
+	case 90, 270:
+		return o.Height, o.Width, nil
+	}
+	return 0, 0, fmt.Errorf("Invalid rotation %d", o.Rotate)
+}
+
+// palette returns the colours of the panel, indexed by ink.
+func (o imageOptions) palette() color.Palette {
+	p := color.Palette{color.White, color.Black}
+	if o.Depth == 3 {
+		p = append(p, o.Accent)
+	}
+	return p
+}
+
+// getEventInk maps the colour of a calendar to an ink of the panel.  Text is never drawn in white, so
+// colours that are close in hue to the accent colour use the accent colour, and the rest use black.
+func (o imageOptions) getEventInk(colour string) int {
+	if o.Depth != 3 {
+		return inkBlack
+	}
+	h, ok := cssColours[strings.ToLower(colour)]
+	if !ok {
+		return inkBlack
+	}
+	var r, g, b uint8
+	fmt.Sscanf(h, "#%02x%02x%02x", &r, &g, &b)
+	ch, cs := getHueSaturation(r, g, b)
+	ah, _ := getHueSaturation(o.Accent.R, o.Accent.G, o.Accent.B)
+	d := math.Abs(ch - ah)
+	if d > 180 {
+		d = 360 - d
+	}
+	if cs >= 0.5 && d <= 30 {
+		return inkAccent
+	}
+	return inkBlack
+}
+
+// getHueSaturation returns the hue, in degrees, and the saturation of a colour.
+func getHueSaturation(r, g, b uint8) (float64, float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	d := max - min
+	if d == 0 {
+		return 0, 0
+	}
+	var h float64
+	switch max {
+	case rf:
+		h = math.Mod((gf-bf)/d, 6)
+	case gf:
+		h = (bf-rf)/d + 2
+	default:
+		h = (rf-gf)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	l := (max + min) / 2
+	return h, d / (1 - math.Abs(2*l-1))
+}
+
+// layoutAgendaImage lays out the days of the agenda on an image of the size, as a title with the current date,
+// followed by the events of each day.  Events that do not fit are counted in a line at the bottom.
+func layoutAgendaImage(o imageOptions, w int, h int, days []AgendaDay, now time.Time) ([]imageItem, error) {
+	s := float64(h) / 480
+	if s < 0.5 {
+		s = 0.5
+	}
+	titleSize, headSize, textSize := math.Round(34*s), math.Round(24*s), math.Round(20*s)
+	m := int(12 * s)
+	l := []imageItem{}
+
+	text, err := getImageFace(textSize, false)
+	if err != nil {
+		return nil, err
+	}
+	defer text.Close()
+	measure := func(v string) int { return font.MeasureString(text, v).Ceil() }
+	fit := func(v string, width int) string {
+		if measure(v) <= width {
+			return v
+		}
+		r := []rune(v)
+		for len(r) > 0 && measure(string(r)+"…") > width {
+			r = r[:len(r)-1]
+		}
+		return string(r) + "…"
+	}
+
+	y := m + int(titleSize)
+	l = append(l, imageItem{X: m, Y: y, Text: now.Format("Monday 2 January"), Size: titleSize, Bold: true, Ink: inkBlack})
+	upd := now.Format("15:04")
+	l = append(l, imageItem{X: w - m - measure(upd), Y: y, Text: upd, Size: textSize, Ink: inkBlack})
+	y += m / 2
+	l = append(l, imageItem{X: m, Y: y, W: w - 2*m, H: int(math.Max(2, 3*s)), Ink: inkBlack})
+	y += m
+
+	lineH := int(textSize * 1.35)
+	headH := int(headSize * 1.5)
+	bottom := h - m
+	bullet := int(textSize * 0.6)
+	timeX := m + bullet + m/2
+	textX := timeX + measure("All day") + m
+	left := 0
+	for _, d := range days {
+		left += len(d.Events)
+	}
+	if left == 0 {
+		l = append(l, imageItem{X: m, Y: y + lineH, Text: "No events", Size: textSize, Ink: inkBlack})
+		return l, nil
+	}
+
+	for i, d := range days {
+		if len(d.Events) == 0 {
+			continue
+		}
+		// Leave space for the line with the number of events that do not fit
+		if y+headH+2*lineH > bottom {
+			break
+		}
+		name := d.Name
+		switch i {
+		case 0:
+			name = "Today"
+		case 1:
+			name = "Tomorrow"
+		}
+		y += headH
+		ink := inkBlack
+		if d.Today {
+			ink = inkAccent
+		}
+		l = append(l, imageItem{X: m, Y: y - headH/4, Text: name + ", " + d.Date, Size: headSize, Bold: true, Ink: ink})
+		for _, e := range d.Events {
+			if y+lineH > bottom || (left > 1 && y+2*lineH > bottom) {
+				break
+			}
+			y += lineH
+			left--
+			ei := o.getEventInk(e.Colour)
+			l = append(l, imageItem{X: m, Y: y - bullet, W: bullet, H: bullet, Ink: ei})
+			t := e.Time
+			if e.AllDay {
+				t = "All day"
+			}
+			l = append(l, imageItem{X: timeX, Y: y, Text: t, Size: textSize, Ink: inkBlack})
+			l = append(l, imageItem{X: textX, Y: y, Text: fit(e.Summary, w-m-textX), Size: textSize, Ink: ei})
+		}
+	}
+	if left > 0 {
+		l = append(l, imageItem{X: m, Y: bottom, Text: fmt.Sprintf("+%d more", left), Size: textSize, Ink: inkBlack})
+	}
+	return l, nil
+}
+
+// drawAgendaPNG draws the items on an image with the panel palette, and returns it in PNG format.
+func drawAgendaPNG(o imageOptions, items []imageItem) ([]byte, error) {
+	w, h, err := o.validate()
+	if err != nil {
+		return nil, err
+	}
+	p := o.palette()
+	ink := func(i int) color.Color {
+		if i >= len(p) {
+			return p[inkBlack]
+		}
+		return p[i]
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(p[inkWhite]), image.Point{}, draw.Src)
+	for _, i := range items {
+		if i.Text == "" {
+			draw.Draw(img, image.Rect(i.X, i.Y, i.X+i.W, i.Y+i.H), image.NewUniform(ink(i.Ink)), image.Point{}, draw.Src)
+			continue
+		}
+		f, err := getImageFace(i.Size, i.Bold)
+		if err != nil {
+			return nil, err
+		}
+		d := font.Drawer{Dst: img, Src: image.NewUniform(ink(i.Ink)), Face: f, Dot: fixed.P(i.X, i.Y)}
+		d.DrawString(i.Text)
+		f.Close()
+	}
+
+	// Reduce the image to the colours of the panel, without dithering, so that the text stays sharp
+	pi := image.NewPaletted(img.Bounds(), p)
+	draw.Draw(pi, pi.Bounds(), img, image.Point{}, draw.Src)
+	pi = rotatePaletted(pi, o.Rotate)
+
+	b := &bytes.Buffer{}
+	if err := png.Encode(b, pi); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// rotatePaletted rotates the image clockwise by the number of degrees.
+func rotatePaletted(src *image.Paletted, deg int) *image.Paletted {
+	if deg == 0 {
+		return src
+	}
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := sw, sh
+	if deg == 90 || deg == 270 {
+		dw, dh = sh, sw
+	}
+	dst := image.NewPaletted(image.Rect(0, 0, dw, dh), src.Palette)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			c := src.ColorIndexAt(x, y)
+			switch deg {
+			case 90:
+				dst.SetColorIndex(sh-1-y, x, c)
+			case 180:
+				dst.SetColorIndex(sw-1-x, sh-1-y, c)
+			case 270:
+				dst.SetColorIndex(y, sw-1-x, c)
+			}
+		}
+	}
+	return dst
+}
+
+// drawAgendaSVG draws the items as an SVG image with the panel palette.  The layout is measured with
+// the bundled Go fonts, which are used by the SVG if they are installed.
+func drawAgendaSVG(o imageOptions, items []imageItem) ([]byte, error) {
+	if _, _, err := o.validate(); err != nil {
+		return nil, err
+	}
+	p := o.palette()
+	ink := func(i int) string {
+		if i >= len(p) {
+			i = inkBlack
+		}
+		r, g, b, _ := p[i].RGBA()
+		return fmt.Sprintf("#%02X%02X%02X", r>>8, g>>8, b>>8)
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", o.Width, o.Height, o.Width, o.Height)
+	fmt.Fprintf(b, `<rect width="%d" height="%d" fill="%s"/>`+"\n", o.Width, o.Height, ink(inkWhite))
+	switch o.Rotate {
+	case 90:
+		fmt.Fprintf(b, `<g transform="translate(%d,0) rotate(90)">`+"\n", o.Width)
+	case 180:
+		fmt.Fprintf(b, `<g transform="translate(%d,%d) rotate(180)">`+"\n", o.Width, o.Height)
+	case 270:
+		fmt.Fprintf(b, `<g transform="translate(0,%d) rotate(270)">`+"\n", o.Height)
+	default:
+		b.WriteString("<g>\n")
+	}
+	b.WriteString(`<g font-family="Go, sans-serif">` + "\n")
+	for _, i := range items {
+		if i.Text == "" {
+			fmt.Fprintf(b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", i.X, i.Y, i.W, i.H, ink(i.Ink))
+			continue
+		}
+		weight := ""
+		if i.Bold {
+			weight = ` font-weight="bold"`
+		}
+		fmt.Fprintf(b, `<text x="%d" y="%d" font-size="%g"%s fill="%s">%s</text>`+"\n", i.X, i.Y, i.Size, weight, ink(i.Ink), html.EscapeString(i.Text))
+	}
+	b.WriteString("</g>\n</g>\n</svg>\n")
+	return b.Bytes(), nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanRenderAgendaPNG(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testrender", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/render.png?width=640&height=384&rotate=90", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 640 || b.Dy() != 384 {
+		t.Errorf("Wrong image size. Got %dx%d", b.Dx(), b.Dy())
+	}
+	p, ok := img.(*image.Paletted)
+	if !ok || len(p.Palette) != 2 {
+		t.Fatalf("Image not drawn in black and white. Got %T", img)
+	}
+	black := 0
+	for _, i := range p.Pix {
+		if i == inkBlack {
+			black++
+		}
+	}
+	if black == 0 {
+		t.Error("Nothing drawn on the image")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/render.png?depth=2", nil))
+	if w.Code == http.StatusOK {
+		t.Error("No error returned for an invalid depth")
+	}
+}
+
+func TestCanRenderAgendaSVG(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testrendersvg", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/render.svg?depth=3&accent=yellow&rotate=180", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	b := w.Body.String()
+	for _, v := range []string{`width="800" height="480"`, `rotate(180)`, `>Live</text>`, `fill="#FFFF00"`} {
+		if !strings.Contains(b, v) {
+			t.Errorf("'%s' not found in %s", v, b)
+		}
+	}
+}
+
+func TestCalendarColoursAreMappedToPanel(t *testing.T) {
+	o := imageOptions{Depth: 3, Accent: renderAccents["red"]}
+	for c, exp := range map[string]int{"Red": inkAccent, "Chocolate": inkAccent, "LightPink": inkAccent, "SkyBlue": inkBlack, "Lime": inkBlack, "Yellow": inkBlack, "Unknown": inkBlack} {
+		if ink := o.getEventInk(c); ink != exp {
+			t.Errorf("Wrong ink for %s. Expected %d, got %d", c, exp, ink)
+		}
+	}
+	o.Accent = renderAccents["yellow"]
+	if ink := o.getEventInk("Yellow"); ink != inkAccent {
+		t.Errorf("Wrong ink for Yellow. Expected %d, got %d", inkAccent, ink)
+	}
+	o.Depth = 1
+	if ink := o.getEventInk("Red"); ink != inkBlack {
+		t.Errorf("Wrong ink for a black and white panel. Got %d", ink)
+	}
+}
+
+func TestEventsThatDoNotFitAreCounted(t *testing.T) {
+	st := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	el := []CalEvent{}
+	for i := 0; i < 30; i++ {
+		e := CalEvent{Summary: fmt.Sprintf("Event %d", i), Start: st.Add(time.Duration(i) * time.Hour), End: st.Add(time.Duration(i+1) * time.Hour)}
+		e.SetLocation(time.UTC)
+		el = append(el, e)
+	}
+	o := imageOptions{Width: 800, Height: 480, Depth: 1}
+	l, err := layoutAgendaImage(o, 800, 480, getAgendaDays(st, 2, el), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := l[len(l)-1]
+	if !strings.HasPrefix(last.Text, "+") || !strings.HasSuffix(last.Text, " more") {
+		t.Fatalf("Events that do not fit not counted. Got '%s'", last.Text)
+	}
+	shown := 0
+	for _, i := range l {
+		if strings.HasPrefix(i.Text, "Event ") {
+			shown++
+		}
+		if i.Y > 480 {
+			t.Errorf("Item drawn below the image. %+v", i)
+		}
+	}
+	if exp := fmt.Sprintf("+%d more", 30-shown); last.Text != exp {
+		t.Errorf("Wrong count. Expected '%s', got '%s'", exp, last.Text)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// CalDAV is a calendar provider for calendars on a CalDAV server, e.g. Nextcloud or Fastmail.
+type CalDAV struct {
+	CalConfig CalConfig // Selected Calendar Configuration
+}
+
+// CalDAVCredentials holds the credentials used to authenticate with a CalDAV server.
+type CalDAVCredentials struct {
+	Username string `json:"username"` // User name
+	Password string `json:"password"` // Password or app password
+}
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name:  "CalDAV",
+		Title: "CalDAV Server",
+		NewFields: []ProviderField{
+			{Name: "addCalDAVUrl", Label: "Server or Calendar URL", Type: "text", Placeholder: "https://cloud.example.com/remote.php/dav", Config: "url", SetNew: setNewURL},
+			{Name: "addCalDAVUser", Label: "User Name", Type: "text", Placeholder: "User Name", Config: "username", SetNew: setNewUsername},
+			{Name: "addCalDAVPassword", Label: "Password or App Password", Type: "password", Placeholder: "Password", Config: "password", SetNew: setNewPassword},
+		},
+		UpdateFields: []ProviderField{
+			{Name: "updCalDAVUrl", Label: "Calendar URL List (place each on a separate line)", Type: "textarea", Placeholder: "URL", Config: "url", SetUpdate: setURL},
+		},
+		New: func() CalendarProvider { return new(CalDAV) },
+	})
+}
+
+// SetConfig sets the configuration for this calendar provider
+func (p *CalDAV) SetConfig(c CalConfig) {
+	p.CalConfig = c
+}
+
+// RemovedConfig is used to clean up after config has been removed
+func (p *CalDAV) RemovedConfig(c CalConfig) error {
+	credFile := getCalDAVCredentialsFileName(c.ID)
+	if _, err := os.Stat(credFile); err == nil {
+		return os.Remove(credFile)
+	}
+	return nil
+}
+
+// ProviderName returns the name of the provider
+func (p *CalDAV) ProviderName() string {
+	return "CalDAV"
+}
+
+// GetEvents returns the calendar events between the start and end times
+func (p *CalDAV) GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error) {
+	evts := NewCalEvents(start, end)
+
+	cred, err := p.getCredentialsFromFile(p.CalConfig.ID)
+	if err != nil {
+		return evts, fmt.Errorf("Error reading credentials file for %s. %s", p.CalConfig.Name, err.Error())
+	}
+	client := CalDAVClient{Username: cred.Username, Password: cred.Password}
+
+	// Split the URL by lines
+	urls := strings.Split(strings.Replace(p.CalConfig.URL, "\r", "", -1), "\n")
+
+	for _, u := range urls {
+		if strings.TrimSpace(u) == "" {
+			continue
+		}
+		l, err := client.GetCalendarData(ctx, u, evts.Start, evts.End)
+		if err != nil {
+			return evts, fmt.Errorf("Error querying calendar. %s", err.Error())
+		}
+		for _, b := range l {
+			if err := appendICalEvents(&evts, p.CalConfig, b); err != nil {
+				return evts, fmt.Errorf("Error parsing calendar data. %s", err.Error())
+			}
+		}
+	}
+	evts.EventCount = len(evts.Events)
+	return evts, nil
+}
+
+// ValidateConfig validates the configuration change for the calendar
+// and returns the calendar configuation ready to save
+func (p *CalDAV) ValidateConfig(c CalConfig) (CalConfig, error) {
+	if c.ID == "" {
+		return c, errors.New("ID must be specified")
+	}
+	if c.Name == "" {
+		return c, errors.New("Name must be specified")
+	}
+	if c.Colour == "" {
+		return c, errors.New("Colour must be specified")
+	}
+	if strings.TrimSpace(c.URL) == "" {
+		return c, errors.New("URL must be specified")
+	}
+	return c, nil
+}
+
+// ValidateNewConfig validates the new configuration values, discovers the calendars
+// on the server and returns the calendar configuration ready to save
+func (p *CalDAV) ValidateNewConfig(c NewCalConfig) (CalConfig, error) {
+	cc := CalConfig{}
+
+	if c.Name == "" {
+		return cc, errors.New("Name must be specified")
+	}
+	if c.URL == "" {
+		return cc, errors.New("URL must be specified")
+	}
+	if c.Username == "" {
+		return cc, errors.New("User name must be specified")
+	}
+	if c.Colour == "" {
+		return cc, errors.New("Colour must be selected")
+	}
+
+	// Find the calendars
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client := CalDAVClient{Username: c.Username, Password: c.Password}
+	cals, err := client.FindCalendars(ctx, c.URL)
+	if err != nil {
+		return cc, fmt.Errorf("Unable to find calendars. %s", err.Error())
+	}
+	urls := []string{}
+	for _, i := range cals {
+		urls = append(urls, i.URL)
+	}
+
+	// Generate a new ID
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return cc, errors.New("Error creating GUID. " + err.Error())
+	}
+	cc.ID = uuid.String()
+	cc.Name = c.Name
+	cc.Provider = p.ProviderName()
+	cc.Colour = c.Colour
+	cc.URL = strings.Join(urls, "\n")
+
+	// Save the credentials
+	err = p.saveCredentialsToFile(cc.ID, CalDAVCredentials{Username: c.Username, Password: c.Password})
+	if err != nil {
+		return cc, err
+	}
+	return cc, nil
+}
+
+// CreateEvent creates the event in the first calendar of the list
+func (p *CalDAV) CreateEvent(ctx context.Context, e CalEvent) (CalEvent, error) {
+	client, urls, err := p.getWriteClient()
+	if err != nil {
+		return e, err
+	}
+
+	// Generate a new UID
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return e, errors.New("Error creating GUID. " + err.Error())
+	}
+	e.UID = uuid.String()
+	e.RecurrenceID = ""
+	u := strings.TrimRight(urls[0], "/") + "/" + e.UID + ".ics"
+	if _, err := client.PutEvent(ctx, u, formatICalCalendar([]CalEvent{e}), ""); err != nil {
+		return e, fmt.Errorf("Error creating event. %s", err.Error())
+	}
+	return p.getEvent(e), nil
+}
+
+// UpdateEvent replaces the details of the event with the same UID.  Recurring events cannot be
+// updated, as the recurrence rules would be lost.
+func (p *CalDAV) UpdateEvent(ctx context.Context, e CalEvent) (CalEvent, error) {
+	client, urls, err := p.getWriteClient()
+	if err != nil {
+		return e, err
+	}
+	o, err := p.findEvent(ctx, client, urls, e.UID)
+	if err != nil {
+		return e, err
+	}
+	for _, ln := range unfoldICalLines(o.Data) {
+		if n, _ := splitICalLine(ln); n == "RRULE" || n == "RDATE" || n == "RECURRENCE-ID" {
+			return e, errors.New("Recurring events cannot be updated")
+		}
+	}
+	e.RecurrenceID = ""
+	if _, err := client.PutEvent(ctx, o.URL, formatICalCalendar([]CalEvent{e}), o.ETag); err != nil {
+		return e, fmt.Errorf("Error updating event. %s", err.Error())
+	}
+	return p.getEvent(e), nil
+}
+
+// DeleteEvent deletes the event with the UID.  All the occurrences of a recurring event are deleted.
+func (p *CalDAV) DeleteEvent(ctx context.Context, uid string) error {
+	client, urls, err := p.getWriteClient()
+	if err != nil {
+		return err
+	}
+	o, err := p.findEvent(ctx, client, urls, uid)
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteEvent(ctx, o.URL, o.ETag); err != nil {
+		return fmt.Errorf("Error deleting event. %s", err.Error())
+	}
+	return nil
+}
+
+// getWriteClient returns the client and calendar URLs used to change events.
+func (p *CalDAV) getWriteClient() (CalDAVClient, []string, error) {
+	cred, err := p.getCredentialsFromFile(p.CalConfig.ID)
+	if err != nil {
+		return CalDAVClient{}, nil, fmt.Errorf("Error reading credentials file for %s. %s", p.CalConfig.Name, err.Error())
+	}
+	urls := splitLines(p.CalConfig.URL)
+	if len(urls) == 0 {
+		return CalDAVClient{}, nil, errors.New("URL must be specified")
+	}
+	return CalDAVClient{Username: cred.Username, Password: cred.Password}, urls, nil
+}
+
+// findEvent searches the calendars for the event with the UID.
+func (p *CalDAV) findEvent(ctx context.Context, client CalDAVClient, urls []string, uid string) (CalDAVObject, error) {
+	for _, u := range urls {
+		o, err := client.FindEvent(ctx, u, uid)
+		if err == nil {
+			return o, nil
+		}
+		if err != errCalDAVNotFound {
+			return o, fmt.Errorf("Error finding event. %s", err.Error())
+		}
+	}
+	return CalDAVObject{}, fmt.Errorf("Event %s not found", uid)
+}
+
+// getEvent returns the event with the details of the calendar.
+func (p *CalDAV) getEvent(e CalEvent) CalEvent {
+	e.ID = p.CalConfig.ID
+	e.Name = p.CalConfig.Name
+	e.Colour = p.CalConfig.Colour
+	e.SetLocation(p.CalConfig.Location())
+	return e
+}
+
+// getCalDAVCredentialsFileName returns the name of the file holding the credentials for a calendar.
+func getCalDAVCredentialsFileName(id string) string {
+	return fmt.Sprintf("Credentials_%s.json", id)
+}
+
+// Retrieves the credentials from a local file.
+func (p *CalDAV) getCredentialsFromFile(id string) (CalDAVCredentials, error) {
+	cred := CalDAVCredentials{}
+	f, err := os.Open(getCalDAVCredentialsFileName(id))
+	if err != nil {
+		return cred, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&cred)
+	return cred, err
+}
+
+func (p *CalDAV) saveCredentialsToFile(id string, cred CalDAVCredentials) error {
+	f, err := os.OpenFile(getCalDAVCredentialsFileName(id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to save credentials: %v", err)
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(cred)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalDAVEvent = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//CalDAV//EN
+BEGIN:VEVENT
+UID:standup@example.com
+DTSTAMP:20180201T000000Z
+DTSTART:20180301T090000Z
+DTEND:20180301T091500Z
+RRULE:FREQ=WEEKLY;COUNT=3
+SUMMARY:Standup
+LOCATION:Kitchen
+END:VEVENT
+END:VCALENDAR
+`
+
+// newTestCalDAVServer creates an in-process stand-in for a CalDAV server.
+func newTestCalDAVServer(t *testing.T) *httptest.Server {
+	ms := func(w http.ResponseWriter, body string) {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">%s</d:multistatus>`, body)
+	}
+	objects := map[string]testCalDAVObject{
+		"/dav/calendars/test/family/recurring.ics": {UID: "standup@example.com", ETag: `"1"`, Data: testCalDAVEvent},
+	}
+	etag := 1
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "test" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /.well-known/caldav":
+			http.Redirect(w, r, "/dav/", http.StatusMovedPermanently)
+		case "PROPFIND /dav/":
+			ms(w, `<d:response><d:href>/dav/</d:href><d:propstat><d:prop><d:current-user-principal><d:href>/dav/principals/test/</d:href></d:current-user-principal></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+		case "PROPFIND /dav/principals/test/":
+			ms(w, `<d:response><d:href>/dav/principals/test/</d:href><d:propstat><d:prop><c:calendar-home-set><d:href>/dav/calendars/test/</d:href></c:calendar-home-set></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+		case "PROPFIND /dav/calendars/test/":
+			if r.Header.Get("Depth") != "1" {
+				t.Errorf("Wrong depth for calendar home. Got '%s'", r.Header.Get("Depth"))
+			}
+			ms(w, `<d:response><d:href>/dav/calendars/test/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`+
+				`<d:response><d:href>/dav/calendars/test/family/</d:href><d:propstat><d:prop><d:displayname>Family</d:displayname><d:resourcetype><d:collection/><c:calendar/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`+
+				`<d:response><d:href>/dav/calendars/test/inbox/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+		case "REPORT /dav/calendars/test/family/":
+			b, _ := ioutil.ReadAll(r.Body)
+			if strings.Contains(string(b), "<c:prop-filter name=\"UID\">") {
+				// Find the event by its UID
+				body := ""
+				for path, o := range objects {
+					if strings.Contains(string(b), ">"+o.UID+"<") {
+						body = `<d:response><d:href>` + path + `</d:href><d:propstat><d:prop><d:getetag>` + o.ETag + `</d:getetag><c:calendar-data>` + o.Data + `</c:calendar-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`
+					}
+				}
+				ms(w, body)
+				return
+			}
+			if !strings.Contains(string(b), `start="20180301T000000Z"`) {
+				t.Errorf("Time range not sent in calendar query. %s", string(b))
+			}
+			ms(w, `<d:response><d:href>/dav/calendars/test/family/standup.ics</d:href><d:propstat><d:prop><d:getetag>"1"</d:getetag><c:calendar-data>`+testCalDAVEvent+`</c:calendar-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+		default:
+			o, ok := objects[r.URL.Path]
+			switch {
+			case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/dav/calendars/test/family/"):
+				if (r.Header.Get("If-None-Match") == "*" && ok) || (r.Header.Get("If-Match") != "" && (!ok || r.Header.Get("If-Match") != o.ETag)) {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				uid := ""
+				for _, ln := range unfoldICalLines(b) {
+					if n, v := splitICalLine(ln); n == "UID" {
+						uid = v
+					}
+				}
+				etag++
+				objects[r.URL.Path] = testCalDAVObject{UID: uid, ETag: fmt.Sprintf(`"%d"`, etag), Data: string(b)}
+				w.Header().Set("ETag", objects[r.URL.Path].ETag)
+				if ok {
+					w.WriteHeader(http.StatusNoContent)
+				} else {
+					w.WriteHeader(http.StatusCreated)
+				}
+			case r.Method == "DELETE" && ok:
+				if r.Header.Get("If-Match") != o.ETag {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					return
+				}
+				delete(objects, r.URL.Path)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}
+	}))
+}
+
+// testCalDAVObject holds an event stored on the test CalDAV server.
+type testCalDAVObject struct {
+	UID  string
+	ETag string
+	Data string
+}
+
+func TestCanFindCalDAVCalendars(t *testing.T) {
+	srv := newTestCalDAVServer(t)
+	defer srv.Close()
+
+	c := CalDAVClient{Username: "test", Password: "secret"}
+	l, err := c.FindCalendars(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 {
+		t.Fatalf("Wrong number of calendars found. Expected %d, got %d", 1, len(l))
+	}
+	if l[0].Name != "Family" || l[0].URL != srv.URL+"/dav/calendars/test/family/" {
+		t.Errorf("Wrong calendar found. Got %v", l[0])
+	}
+
+	c.Password = "wrong"
+	if _, err := c.FindCalendars(context.Background(), srv.URL); err == nil {
+		t.Error("No error returned for invalid credentials")
+	}
+}
+
+func TestCanGetCalDAVEvents(t *testing.T) {
+	srv := newTestCalDAVServer(t)
+	defer srv.Close()
+
+	p := new(CalDAV)
+	cc, err := p.ValidateNewConfig(NewCalConfig{
+		Name:     "Test CalDAV",
+		Colour:   "Red",
+		URL:      srv.URL,
+		Username: "test",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.RemovedConfig(cc)
+
+	p.SetConfig(cc)
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	l, err := p.GetEvents(context.Background(), start, start.AddDate(0, 0, 21))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Events) != 3 {
+		t.Fatalf("Wrong number of events returned. Expected %d, got %d", 3, len(l.Events))
+	}
+	if l.Events[0].Summary != "Standup" || l.Events[0].Location != "Kitchen" {
+		t.Errorf("Wrong event returned. Got %v", l.Events[0])
+	}
+}
+
+func TestCanChangeCalDAVEvents(t *testing.T) {
+	srv := newTestCalDAVServer(t)
+	defer srv.Close()
+
+	p := new(CalDAV)
+	cc, err := p.ValidateNewConfig(NewCalConfig{
+		Name:     "Test CalDAV",
+		Colour:   "Red",
+		URL:      srv.URL,
+		Username: "test",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.RemovedConfig(cc)
+	p.SetConfig(cc)
+
+	ctx := context.Background()
+	st := time.Date(2018, 3, 1, 15, 0, 0, 0, time.UTC)
+	e, err := p.CreateEvent(ctx, CalEvent{Summary: "Dentist", Start: st, End: st.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.UID == "" || e.ID != cc.ID || e.Duration != "1h" {
+		t.Errorf("Wrong event returned. Got %v", e)
+	}
+
+	e.Summary = "Dentist, Dr Smith"
+	e, err = p.UpdateEvent(ctx, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Summary != "Dentist, Dr Smith" {
+		t.Errorf("Wrong summary returned. Got '%s'", e.Summary)
+	}
+
+	if _, err := p.UpdateEvent(ctx, CalEvent{UID: "standup@example.com", Summary: "Standup", Start: st, End: st.Add(time.Hour)}); err == nil {
+		t.Error("No error returned when updating a recurring event")
+	}
+
+	if err := p.DeleteEvent(ctx, e.UID); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteEvent(ctx, e.UID); err == nil {
+		t.Error("No error returned when deleting a missing event")
+	}
+}
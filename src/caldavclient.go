@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CalDAVClient is a minimal CalDAV client used to discover calendars and query their events.
+type CalDAVClient struct {
+	Username string       // User name used for basic authentication
+	Password string       // Password, or app password, used for basic authentication
+	Client   *http.Client // HTTP client, defaults to http.DefaultClient
+}
+
+// CalDAVCalendar holds the details of a calendar collection found on a CalDAV server.
+type CalDAVCalendar struct {
+	URL  string `json:"url"`  // URL of the calendar collection
+	Name string `json:"name"` // Display name of the calendar
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	DisplayName          string          `xml:"DAV: displayname"`
+	ResourceType         davResourceType `xml:"DAV: resourcetype"`
+	CurrentUserPrincipal davHref         `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      davHref         `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	CalendarData         string          `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+	ETag                 string          `xml:"DAV: getetag"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+	Calendar   *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+}
+
+type davHref struct {
+	Href string `xml:"DAV: href"`
+}
+
+const davPropfindCalendar = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop>
+    <d:resourcetype/>
+    <d:displayname/>
+    <d:current-user-principal/>
+    <c:calendar-home-set/>
+  </d:prop>
+</d:propfind>`
+
+const davCalendarQuery = `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop>
+    <d:getetag/>
+    <c:calendar-data/>
+  </d:prop>
+  <c:filter>
+    <c:comp-filter name="VCALENDAR">
+      <c:comp-filter name="VEVENT">
+        <c:time-range start="%s" end="%s"/>
+      </c:comp-filter>
+    </c:comp-filter>
+  </c:filter>
+</c:calendar-query>`
+
+const davUIDQuery = `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop>
+    <d:getetag/>
+    <c:calendar-data/>
+  </d:prop>
+  <c:filter>
+    <c:comp-filter name="VCALENDAR">
+      <c:comp-filter name="VEVENT">
+        <c:prop-filter name="UID">
+          <c:text-match collation="i;octet">%s</c:text-match>
+        </c:prop-filter>
+      </c:comp-filter>
+    </c:comp-filter>
+  </c:filter>
+</c:calendar-query>`
+
+// FindCalendars discovers the calendar collections available to the user.  The URL can be the
+// URL of the server, the principal of the user, the calendar home, or a calendar collection.
+func (c *CalDAVClient) FindCalendars(ctx context.Context, u string) ([]CalDAVCalendar, error) {
+	base, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL '%s'. %s", u, err.Error())
+	}
+	if base.Path == "" || base.Path == "/" {
+		// Use the well-known location of the CalDAV service
+		if wk, err := c.getRedirect(ctx, base.ResolveReference(&url.URL{Path: "/.well-known/caldav"})); err == nil {
+			base = wk
+		}
+	}
+
+	visited := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		if visited[base.String()] {
+			break
+		}
+		visited[base.String()] = true
+
+		ms, err := c.propfind(ctx, base, "0")
+		if err != nil {
+			return nil, err
+		}
+		p := ms.getProp()
+		switch {
+		case p.ResourceType.Calendar != nil:
+			// This is a calendar collection
+			return []CalDAVCalendar{{URL: base.String(), Name: p.DisplayName}}, nil
+		case p.CalendarHomeSet.Href != "":
+			home, err := base.Parse(p.CalendarHomeSet.Href)
+			if err != nil {
+				return nil, err
+			}
+			return c.listCalendars(ctx, home)
+		case p.CurrentUserPrincipal.Href != "":
+			if base, err = base.Parse(p.CurrentUserPrincipal.Href); err != nil {
+				return nil, err
+			}
+		default:
+			// Assume this is the calendar home
+			return c.listCalendars(ctx, base)
+		}
+	}
+	return nil, errors.New("No calendars found")
+}
+
+// GetCalendarData returns the iCal data of the events in the calendar collection that overlap the time window.
+func (c *CalDAVClient) GetCalendarData(ctx context.Context, u string, start time.Time, end time.Time) ([][]byte, error) {
+	cu, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL '%s'. %s", u, err.Error())
+	}
+	body := fmt.Sprintf(davCalendarQuery, start.UTC().Format("20060102T150405Z"), end.UTC().Format("20060102T150405Z"))
+	ms, err := c.do(ctx, "REPORT", cu, "1", body)
+	if err != nil {
+		return nil, err
+	}
+	l := [][]byte{}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if ps.Prop.CalendarData != "" && isDAVStatusOK(ps.Status) {
+				l = append(l, []byte(ps.Prop.CalendarData))
+			}
+		}
+	}
+	return l, nil
+}
+
+// CalDAVObject holds a calendar object resource, i.e. the iCal data of an event, on a CalDAV server.
+type CalDAVObject struct {
+	URL  string // URL of the resource
+	ETag string // Entity tag of the resource, used to detect conflicting changes
+	Data []byte // iCal data of the resource
+}
+
+// FindEvent returns the calendar object resource in the calendar collection holding the event with the UID.
+func (c *CalDAVClient) FindEvent(ctx context.Context, u string, uid string) (CalDAVObject, error) {
+	o := CalDAVObject{}
+	cu, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return o, fmt.Errorf("Invalid URL '%s'. %s", u, err.Error())
+	}
+	b := &bytes.Buffer{}
+	xml.EscapeText(b, []byte(uid))
+	ms, err := c.do(ctx, "REPORT", cu, "1", fmt.Sprintf(davUIDQuery, b.String()))
+	if err != nil {
+		return o, err
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if ps.Prop.CalendarData != "" && isDAVStatusOK(ps.Status) {
+				ou, err := cu.Parse(r.Href)
+				if err != nil {
+					return o, err
+				}
+				return CalDAVObject{URL: ou.String(), ETag: ps.Prop.ETag, Data: []byte(ps.Prop.CalendarData)}, nil
+			}
+		}
+	}
+	return o, errCalDAVNotFound
+}
+
+// errCalDAVNotFound is returned when an event cannot be found in a calendar collection.
+var errCalDAVNotFound = errors.New("Event not found")
+
+// PutEvent creates or replaces the calendar object resource.  If the entity tag is blank, the
+// resource must not exist yet, otherwise it must not have changed since it was read.  The new
+// entity tag is returned, if the server provides it.
+func (c *CalDAVClient) PutEvent(ctx context.Context, u string, data []byte, etag string) (string, error) {
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+	if etag == "" {
+		req.Header.Set("If-None-Match", "*")
+	} else {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// DeleteEvent deletes the calendar object resource, if it has not changed since it was read.
+func (c *CalDAVClient) DeleteEvent(ctx context.Context, u string, etag string) error {
+	req, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	_, err = c.send(ctx, req)
+	return err
+}
+
+// send sends a request that does not return a multi-status response, and checks that it succeeded.
+func (c *CalDAVClient) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.getClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return nil, fmt.Errorf("%s %s failed because the event has been changed by someone else", req.Method, req.URL.String())
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s returned %s", req.Method, req.URL.String(), resp.Status)
+	}
+	return resp, nil
+}
+
+// listCalendars returns the calendar collections in the calendar home.
+func (c *CalDAVClient) listCalendars(ctx context.Context, home *url.URL) ([]CalDAVCalendar, error) {
+	ms, err := c.propfind(ctx, home, "1")
+	if err != nil {
+		return nil, err
+	}
+	l := []CalDAVCalendar{}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if ps.Prop.ResourceType.Calendar != nil && isDAVStatusOK(ps.Status) {
+				u, err := home.Parse(r.Href)
+				if err != nil {
+					continue
+				}
+				n := ps.Prop.DisplayName
+				if n == "" {
+					n = strings.Trim(u.Path[strings.LastIndex(strings.TrimRight(u.Path, "/"), "/")+1:], "/")
+				}
+				l = append(l, CalDAVCalendar{URL: u.String(), Name: n})
+			}
+		}
+	}
+	if len(l) == 0 {
+		return nil, errors.New("No calendars found")
+	}
+	return l, nil
+}
+
+func (c *CalDAVClient) propfind(ctx context.Context, u *url.URL, depth string) (*davMultistatus, error) {
+	return c.do(ctx, "PROPFIND", u, depth, davPropfindCalendar)
+}
+
+// do sends a WebDAV request and returns the parsed multi-status response.
+func (c *CalDAVClient) do(ctx context.Context, method string, u *url.URL, depth string, body string) (*davMultistatus, error) {
+	req, err := http.NewRequest(method, u.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", depth)
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.getClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("%s %s returned %s", method, u.String(), resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	ms := &davMultistatus{}
+	if err := xml.NewDecoder(bytes.NewReader(b)).Decode(ms); err != nil {
+		return nil, fmt.Errorf("Error parsing %s response. %s", method, err.Error())
+	}
+	return ms, nil
+}
+
+// getRedirect returns the location that the URL redirects to.
+func (c *CalDAVClient) getRedirect(ctx context.Context, u *url.URL) (*url.URL, error) {
+	cl := *c.getClient()
+	cl.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := cl.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	loc := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 || loc == "" {
+		return nil, errors.New("No redirect")
+	}
+	return u.Parse(loc)
+}
+
+func (c *CalDAVClient) getClient() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+// getProp returns the successful properties of the first response.
+func (ms *davMultistatus) getProp() davProp {
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if isDAVStatusOK(ps.Status) {
+				return ps.Prop
+			}
+		}
+	}
+	return davProp{}
+}
+
+// isDAVStatusOK returns true if the status line is blank or a success code.
+func isDAVStatusOK(s string) bool {
+	return s == "" || strings.Contains(s, " 2")
+}
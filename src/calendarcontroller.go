@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -23,12 +26,18 @@ func (c *CalendarController) AddController(router *mux.Router, s *Server) {
 		Handler(Logger(c, http.HandlerFunc(c.handleGetNames)))
 	router.Methods("GET").Path("/calendar/get/{noDays}").Name("GetCalendars").
 		Handler(Logger(c, http.HandlerFunc(c.handleGetCalendars)))
+	router.Methods("GET").Path("/calendar/events").Name("GetEvents").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetEvents)))
+	router.Methods("GET").Path("/calendar/feed.ics").Name("GetFeed").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetFeed)))
+	router.Methods("GET").Path("/calendar/feed/{id}.ics").Name("GetCalendarFeed").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetFeed)))
 }
 
 func (c *CalendarController) handleGetNames(w http.ResponseWriter, r *http.Request) {
 	l := []CalName{}
 
-	for _, i := range c.Srv.Config.Calendars {
+	for _, i := range c.Srv.Config.Snapshot().Calendars {
 		l = append(l, CalName{
 			Name:   i.Name,
 			Colour: i.Colour,
@@ -54,17 +63,245 @@ func (c *CalendarController) handleGetCalendars(w http.ResponseWriter, r *http.R
 			noDays = i
 		}
 	}
-	el := []CalEvent{}
-	for _, calConfig := range c.Srv.Config.Calendars {
-		p, err := c.getCalendarProvider(calConfig)
+	q, err := c.getEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	q.Start = time.Now()
+	q.End = q.Start.Add(time.Duration(noDays*24) * time.Hour)
+
+	el, src := c.getEvents(r.Context(), q)
+	c.writeEvents(w, r, eventList{Title: "Calendar", Location: q.Location, Events: el}, src, "json")
+}
+
+func (c *CalendarController) handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	q, err := c.getEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := c.getQueryWindow(r, &q, time.Now(), 4*24*time.Hour); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	el, src := c.getEvents(r.Context(), q)
+	c.writeEvents(w, r, eventList{Title: "Calendar", Location: q.Location, Events: el}, src, "json")
+}
+
+func (c *CalendarController) handleGetFeed(w http.ResponseWriter, r *http.Request) {
+	q, err := c.getEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// Default to a day less than the cache window, so that subscribed clients are served
+	// from the cache between refreshes
+	d := 1
+	if c.Srv.Config.CacheDays > 1 {
+		d = c.Srv.Config.CacheDays - 1
+	}
+	if err := c.getQueryWindow(r, &q, time.Now(), time.Duration(d*24)*time.Hour); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Each event must appear once in the feed
+	q.SplitDays = false
+
+	l := eventList{Title: "Calendar", Location: q.Location}
+	if id := mux.Vars(r)["id"]; id != "" {
+		found := false
+		for _, cc := range c.Srv.Config.Snapshot().Calendars {
+			if cc.ID == id {
+				l.Title = cc.Name
+				l.Colour = cc.Colour
+				found = true
+			}
+		}
+		if !found {
+			http.Error(w, "Invalid calendar identifier", 500)
+			return
+		}
+		q.Calendars = []string{id}
+	}
+
+	el, src := c.getEvents(r.Context(), q)
+	l.Events = el
+	c.writeEvents(w, r, l, src, "ical")
+}
+
+// getQueryWindow reads the time window of the query from the from and to query string parameters.
+// The window starts at the start time and lasts for the duration if they are not specified.
+func (c *CalendarController) getQueryWindow(r *http.Request, q *eventQuery, start time.Time, d time.Duration) error {
+	q.Start = start
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := parseQueryTime(v, q.Location)
+		if err != nil {
+			return fmt.Errorf("Invalid from date '%s'. %s", v, err.Error())
+		}
+		q.Start = t
+	}
+	q.End = q.Start.Add(d)
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := parseQueryTime(v, q.Location)
+		if err != nil {
+			return fmt.Errorf("Invalid to date '%s'. %s", v, err.Error())
+		}
+		q.End = t
+	}
+	if !q.End.After(q.Start) {
+		return errors.New("The to date must be after the from date")
+	}
+	return nil
+}
+
+// eventQuery holds the options of a request for calendar events.
+type eventQuery struct {
+	Start     time.Time      // Start of the time window
+	End       time.Time      // End of the time window
+	Location  *time.Location // Time zone used to display the events
+	SplitDays bool           // Split events that span multiple days into one entry per day
+	Calendars []string       // Identifiers of the calendars to include, or all of them if empty
+	Search    []string       // Lower case words that each event must contain
+	Exclude   []string       // Lower case keywords of events to leave out
+	Limit     int            // Maximum number of events, or 0 for no limit
+}
+
+// getEventQuery reads the options common to all the calendar event requests from the query string.
+// The filter rules of the display profile, if one is specified, are combined with the filter options.
+func (c *CalendarController) getEventQuery(r *http.Request) (eventQuery, error) {
+	q := eventQuery{}
+	qs := r.URL.Query()
+	loc, err := c.getLocation(r)
+	if err != nil {
+		return q, err
+	}
+	q.Location = loc
+	if v := qs.Get("split"); v != "" {
+		if q.SplitDays, err = strconv.ParseBool(v); err != nil {
+			return q, fmt.Errorf("Invalid split value '%s'", v)
+		}
+	}
+
+	p := Profile{}
+	if n := qs.Get("profile"); n != "" {
+		ok := false
+		if p, ok = c.Srv.Config.GetProfile(n); !ok {
+			return q, fmt.Errorf("Invalid profile '%s'", n)
+		}
+	}
+	if q.Calendars, err = c.resolveCalendars(p.Calendars); err != nil {
+		return q, err
+	}
+	if l := splitQueryList(qs["calendar"]); len(l) != 0 {
+		ql, err := c.resolveCalendars(l)
 		if err != nil {
-			c.LogError(fmt.Sprintf("Error retrieving calendar provider for %s. %s", calConfig.Name, err.Error()))
+			return q, err
+		}
+		// Only the calendars of the profile can be selected
+		if len(q.Calendars) != 0 {
+			for _, id := range ql {
+				if !containsString(q.Calendars, id) {
+					return q, fmt.Errorf("Calendar '%s' is not part of profile '%s'", id, p.Name)
+				}
+			}
+		}
+		q.Calendars = ql
+	}
+	q.Search = append(strings.Fields(strings.ToLower(p.Search)), strings.Fields(strings.ToLower(qs.Get("q")))...)
+	for _, k := range append(p.Exclude, splitQueryList(qs["exclude"])...) {
+		if k = strings.TrimSpace(k); k != "" {
+			q.Exclude = append(q.Exclude, strings.ToLower(k))
+		}
+	}
+	q.Limit = p.Limit
+	if v := qs.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("Invalid limit '%s'", v)
+		}
+		if q.Limit == 0 || n < q.Limit {
+			q.Limit = n
+		}
+	}
+	return q, nil
+}
+
+// resolveCalendars returns the identifiers of the calendars in the list, which can hold calendar identifiers or names.
+func (c *CalendarController) resolveCalendars(l []string) ([]string, error) {
+	ids := []string{}
+	for _, n := range l {
+		found := false
+		for _, cc := range c.Srv.Config.Snapshot().Calendars {
+			if cc.ID == n || cc.Name == n {
+				ids = append(ids, cc.ID)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Invalid calendar '%s'", n)
+		}
+	}
+	return ids, nil
+}
+
+// eventSources lists the identifiers of the calendars by where their events were served from.
+type eventSources struct {
+	Live     []string // Retrieved from the provider during the request
+	Cached   []string // Served from the event cache
+	Fallback []string // Served from the last retrieved events
+}
+
+// getEvents returns the events from the calendars of the query that overlap its time window and pass its
+// filter rules, sorted by start time, in the time zone of the query.  The cached events are used where the
+// cache covers the window, and the rest are retrieved concurrently.
+func (c *CalendarController) getEvents(ctx context.Context, q eventQuery) ([]CalEvent, eventSources) {
+	ts, te := q.Start, q.End
+	// Fetch the whole cache window if the requested window falls inside it,
+	// so that the fetched events can be cached as well
+	fs, fe := c.Srv.Scheduler.cacheWindow()
+	useCache := windowCovers(fs, fe, ts, te)
+	if !useCache {
+		fs, fe = ts, te
+	}
+
+	src := eventSources{}
+	fetch := []CalConfig{}
+	rl := []CalEvents{}
+	for _, calConfig := range c.Srv.Config.Snapshot().Calendars {
+		if len(q.Calendars) != 0 && !containsString(q.Calendars, calConfig.ID) {
+			continue
+		}
+		if evts, ok := c.Srv.Cache.Get(calConfig.ID); ok && windowCovers(evts.Start, evts.End, ts, te) {
+			rl = append(rl, evts)
+			src.Cached = append(src.Cached, calConfig.ID)
+		} else {
+			fetch = append(fetch, calConfig)
+		}
+	}
+	for _, fr := range c.Srv.Scheduler.FetchCalendars(ctx, fetch, fs, fe, useCache) {
+		if fr.Err != nil {
+			c.LogError(fmt.Sprintf("Error retrieving calendar events for %s. %s", fr.CalConfig.Name, fr.Err.Error()))
+		}
+		if fr.Fallback {
+			src.Fallback = append(src.Fallback, fr.CalConfig.ID)
 		} else {
-			evts, err := p.GetEvents(noDays)
-			if err != nil {
-				c.LogError(fmt.Sprintf("Error retrieving calendar events for %s. %s", calConfig.Name, err.Error()))
-			} else {
-				for _, e := range evts.Events {
+			src.Live = append(src.Live, fr.CalConfig.ID)
+		}
+		rl = append(rl, fr.Events)
+	}
+
+	el := []CalEvent{}
+	for _, evts := range rl {
+		for _, e := range evts.Events {
+			e.SetLocation(q.Location)
+			if e.Overlaps(ts, te) && q.matches(e) {
+				e.InProgress = e.Start.Before(ts)
+				if q.SplitDays {
+					el = append(el, e.SplitDays(ts, te)...)
+				} else {
 					el = append(el, e)
 				}
 			}
@@ -73,33 +310,52 @@ func (c *CalendarController) handleGetCalendars(w http.ResponseWriter, r *http.R
 	sort.Slice(el, func(i, j int) bool {
 		return el[j].Start.After(el[i].Start)
 	})
+	return q.limit(el), src
+}
 
-	if b, err := json.Marshal(el); err != nil {
+// writeEvents writes the events to the http response, in the output format requested, or in the default format.
+func (c *CalendarController) writeEvents(w http.ResponseWriter, r *http.Request, l eventList, src eventSources, def string) {
+	f, err := getEventFormat(r, def)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	l.Link = getRequestURL(r)
+	if b, err := f.Write(l); err != nil {
 		m := fmt.Sprintf("Error serializing calendar events. %s", err.Error())
 		c.LogError(m)
 		http.Error(w, m, 500)
 	} else {
-		w.Header().Set("content-type", "application/json")
+		w.Header().Set("content-type", f.ContentType)
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("X-Calendar-Live", strings.Join(src.Live, ","))
+		w.Header().Set("X-Calendar-Cached", strings.Join(src.Cached, ","))
+		w.Header().Set("X-Calendar-Fallback", strings.Join(src.Fallback, ","))
 		w.Write(b)
 	}
 }
 
-func (c *CalendarController) getCalendarProvider(cc CalConfig) (CalendarProvider, error) {
-	switch cc.Provider {
-	case "Google":
-		// Google Calendar
-		gc := new(GCalendar)
-		gc.SetConfig(cc)
-		return gc, nil
-	case "iCal":
-		ic := new(ICalFeed)
-		ic.SetConfig(cc)
-		return ic, nil
-	default:
-		m := fmt.Sprintf("Invalid Calendar provider '%s'", cc.Provider)
-		c.LogError(m)
-		return nil, errors.New(m)
+// getLocation returns the time zone specified by the tz query string parameter,
+// or the default time zone if it is not specified.
+func (c *CalendarController) getLocation(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return c.Srv.Config.Location(), nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone '%s'. %s", tz, err.Error())
+	}
+	return loc, nil
+}
+
+// parseQueryTime parses a date from a query string parameter.
+// Both RFC 3339 date-times and plain dates (2006-01-02), in the specified time zone, are accepted.
+func parseQueryTime(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, loc); err == nil {
+		return t, nil
 	}
+	return time.Parse(time.RFC3339, v)
 }
 
 // LogInfo is used to log information messages for this controller.
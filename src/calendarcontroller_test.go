@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kardianos/service"
+)
+
+func newTestServer(cals ...CalConfig) (*Server, *mux.Router) {
+	logger = service.ConsoleLogger
+	s := &Server{Timeout: 2, Config: &Config{Calendars: cals}}
+	s.Config.SetDefaults()
+	s.Cache = NewEventCache()
+	s.Scheduler = NewScheduler(s, s.Cache)
+	s.Webhooks = NewWebhookDispatcher(s)
+	router := mux.NewRouter().StrictSlash(true)
+	new(CalendarController).AddController(router, s)
+	new(EventController).AddController(router, s)
+	new(AgendaController).AddController(router, s)
+	new(GridController).AddController(router, s)
+	new(RenderController).AddController(router, s)
+	new(ChangeController).AddController(router, s)
+	new(WebhookController).AddController(router, s)
+	new(ConfigController).AddController(router, s)
+	return s, router
+}
+
+func TestCanGetEventsForTimeRange(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testrange", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events?from=2018-03-01T00:00:00Z&to=2018-03-08T00:00:00Z", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Calendar-Live") != "testrange" {
+		t.Errorf("Calendar not served live. Got '%s'", w.Header().Get("X-Calendar-Live"))
+	}
+	el := []CalEvent{}
+	if err := json.Unmarshal(w.Body.Bytes(), &el); err != nil {
+		t.Fatal(err)
+	}
+	if len(el) != 1 {
+		t.Fatalf("Wrong number of events returned. Expected %d, got %d", 1, len(el))
+	}
+	if exp := time.Date(2018, 3, 1, 1, 0, 0, 0, time.UTC); !el[0].Start.Equal(exp) {
+		t.Errorf("Wrong window fetched. Expected event at %v, got %v", exp, el[0].Start)
+	}
+}
+
+func TestInvalidTimeRangeIsRejected(t *testing.T) {
+	_, router := newTestServer()
+	for _, q := range []string{"from=yesterday", "from=2018-03-08&to=2018-03-01"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events?"+q, nil))
+		if w.Code == http.StatusOK {
+			t.Errorf("No error returned for '%s'", q)
+		}
+	}
+}
+
+func TestEventsAreShownInTimeZone(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testtz", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events?from=2018-03-01T00:00:00Z&to=2018-03-02T00:00:00Z&tz=Africa/Johannesburg", nil))
+	el := []CalEvent{}
+	if err := json.Unmarshal(w.Body.Bytes(), &el); err != nil {
+		t.Fatal(err)
+	}
+	if len(el) != 1 {
+		t.Fatalf("Wrong number of events returned. Expected %d, got %d", 1, len(el))
+	}
+	if el[0].Time != "03:00" || el[0].DayName != "Thursday" {
+		t.Errorf("Event not shown in time zone. Got %s %s", el[0].DayName, el[0].Time)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events?tz=Nowhere/Special", nil))
+	if w.Code == http.StatusOK {
+		t.Error("No error returned for an invalid time zone")
+	}
+}
+
+func TestViewsStartingAtMidnightAreCached(t *testing.T) {
+	s, router := newTestServer(CalConfig{ID: "testmidnight", Provider: "Test"})
+	s.Config.TimeZone = "UTC"
+
+	// A request for the next few days fills the cache
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events", nil))
+	if w.Header().Get("X-Calendar-Live") != "testmidnight" {
+		t.Fatalf("Calendar not served live. Got '%s'", w.Header().Get("X-Calendar-Live"))
+	}
+
+	// Views that start at midnight, today or earlier in the week, are served from it
+	y, m, d := time.Now().UTC().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	for _, st := range []time.Time{today, today.AddDate(0, 0, -6)} {
+		w = httptest.NewRecorder()
+		u := fmt.Sprintf("/calendar/events?from=%s&to=%s", st.Format(time.RFC3339), st.AddDate(0, 0, 7).Format(time.RFC3339))
+		router.ServeHTTP(w, httptest.NewRequest("GET", u, nil))
+		if w.Header().Get("X-Calendar-Cached") != "testmidnight" {
+			t.Errorf("Window from %s not served from the cache. Live '%s'", st, w.Header().Get("X-Calendar-Live"))
+		}
+	}
+}
+
+func TestCanSubscribeToFeed(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testfeed1", Name: "Home", Colour: "Lime", Provider: "Test"}, CalConfig{ID: "testfeed2", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/feed.ics?from=2018-03-01T00:00:00Z&to=2018-03-08T00:00:00Z&tz=Africa/Johannesburg", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("content-type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("Wrong content type returned. Got '%s'", ct)
+	}
+	b := w.Body.String()
+	if n := strings.Count(b, "BEGIN:VEVENT"); n != 2 {
+		t.Errorf("Wrong number of events returned. Expected %d, got %d", 2, n)
+	}
+	if !strings.Contains(b, "DTSTART;TZID=Africa/Johannesburg:20180301T030000\r\n") {
+		t.Errorf("Event not written in time zone. %s", b)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/feed/testfeed1.ics?from=2018-03-01T00:00:00Z&to=2018-03-08T00:00:00Z", nil))
+	b = w.Body.String()
+	if n := strings.Count(b, "BEGIN:VEVENT"); n != 1 {
+		t.Errorf("Wrong number of events returned. Expected %d, got %d", 1, n)
+	}
+	if !strings.Contains(b, "X-WR-CALNAME:Home\r\n") || !strings.Contains(b, "COLOR:lime\r\n") {
+		t.Errorf("Calendar name and colour not written. %s", b)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/feed/nothere.ics", nil))
+	if w.Code == http.StatusOK {
+		t.Error("No error returned for an invalid calendar")
+	}
+}
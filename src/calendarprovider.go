@@ -1,11 +1,68 @@
 package main
 
+import (
+	"context"
+	"time"
+)
+
 // CalendarProvider defines an interface for Calendar providers
 type CalendarProvider interface {
-	GetEvents(maxEvents int) (CalEvents, error)
+	GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error)
 	ProviderName() string
 	SetConfig(c CalConfig)
 	RemovedConfig(c CalConfig) error
 	ValidateConfig(c CalConfig) (CalConfig, error)
 	ValidateNewConfig(c NewCalConfig) (CalConfig, error)
 }
+
+// Authenticator is implemented by calendar providers that require the user to
+// authenticate through an external web page when adding a new calendar.
+type Authenticator interface {
+	GetAuthenticateURL() (string, error)
+}
+
+// Watcher is implemented by calendar providers whose source can be checked cheaply for
+// changes, so that the calendar is refreshed as soon as its source changes.
+type Watcher interface {
+	GetSignature() (string, error)
+}
+
+// RefreshAdvisor is implemented by calendar providers whose source can specify how often it should
+// be refreshed.  The interval is read after the events have been retrieved, and is zero if the source
+// did not specify one.
+type RefreshAdvisor interface {
+	GetRefreshInterval() time.Duration
+}
+
+// CalendarLister is implemented by calendar providers that can list the calendars available
+// to an account, so that the user can choose which of them to show.
+type CalendarLister interface {
+	ListCalendars(c NewCalConfig) (ProviderAccount, error)
+}
+
+// ProviderAccount holds the calendars available to an account of a calendar provider.
+type ProviderAccount struct {
+	Account   string             `json:"account"`   // Identifies the account, e.g. the email address
+	Calendars []ProviderCalendar `json:"calendars"` // Calendars available to the account
+}
+
+// ProviderCalendar holds the details of a calendar available to an account.
+type ProviderCalendar struct {
+	ID      string `json:"id"`      // Identifier of the calendar on the provider
+	Name    string `json:"name"`    // Display name of the calendar
+	Primary bool   `json:"primary"` // Indicates that this is the main calendar of the account
+}
+
+// ConfigUpdater is implemented by calendar providers that clean up after the configuration of a calendar
+// has been changed.  It is called with the old and new configuration, after the new one has been saved.
+type ConfigUpdater interface {
+	UpdatedConfig(old CalConfig, c CalConfig) error
+}
+
+// EventWriter is implemented by calendar providers that can create, update and delete events.
+// Events are identified by their UID.  Providers that do not implement it are read-only.
+type EventWriter interface {
+	CreateEvent(ctx context.Context, e CalEvent) (CalEvent, error)
+	UpdateEvent(ctx context.Context, e CalEvent) (CalEvent, error)
+	DeleteEvent(ctx context.Context, uid string) error
+}
@@ -13,6 +13,8 @@ import (
 // CalEvents holds a collection of calendar events as returned by a provider
 type CalEvents struct {
 	Created    time.Time  `json:"created"`    // Date calendar events were retrieved
+	Start      time.Time  `json:"start"`      // Start of the time window retrieved
+	End        time.Time  `json:"end"`        // End of the time window retrieved
 	NoDays     int        `json:"noDays"`     // Number of days retrieved
 	EventCount int        `json:"eventCount"` // Event count
 	Events     []CalEvent `json:"events"`     // List of calendar events
@@ -20,18 +22,105 @@ type CalEvents struct {
 
 // CalEvent holds the calendar event details
 type CalEvent struct {
-	ID          string    `json:"id"`          // Identifier of the calendar
-	Name        string    `json:"name"`        // Name of the calendar
-	UID         string    `json:"uid"`         // UID of the event
-	Start       time.Time `json:"start"`       // Start time
-	End         time.Time `json:"end"`         // End time
-	DayName     string    `json:"dayName"`     // Name of the day
-	Time        string    `json:"time"`        // Starting time of the event
-	Duration    string    `json:"duration"`    // Duration of the event
-	Summary     string    `json:"summary"`     // Summary of the event
-	Location    string    `json:"location"`    // Location of the event
-	Description string    `json:"description"` // Description of the event
-	Colour      string    `json:"colour"`      // Colour of the event
+	ID           string    `json:"id"`                     // Identifier of the calendar
+	Name         string    `json:"name"`                   // Name of the calendar
+	UID          string    `json:"uid"`                    // UID of the event
+	RecurrenceID string    `json:"recurrenceId,omitempty"` // Identifies the occurrence of a recurring event
+	Start        time.Time `json:"start"`                  // Start time
+	End          time.Time `json:"end"`                    // End time
+	DayName      string    `json:"dayName"`                // Name of the day
+	Time         string    `json:"time"`                   // Starting time of the event
+	Duration     string    `json:"duration"`               // Duration of the event
+	Summary      string    `json:"summary"`                // Summary of the event
+	Location     string    `json:"location"`               // Location of the event
+	Description  string    `json:"description"`            // Description of the event
+	Colour       string    `json:"colour"`                 // Colour of the event
+	InProgress   bool      `json:"inProgress"`             // Indicates the event started before the requested time window
+	AllDay       bool      `json:"allDay"`                 // Indicates the event lasts for whole days, without start and end times
+	Days         int       `json:"days"`                   // Number of days the event spans
+	Day          int       `json:"day,omitempty"`          // Day number of this entry, when the event is split into one entry per day
+}
+
+// NewCalEvents creates a new, empty, collection of events for the specified time window.
+// If the end of the window is not after the start, a window of 4 days is used.
+func NewCalEvents(start time.Time, end time.Time) CalEvents {
+	if !end.After(start) {
+		end = start.Add(4 * 24 * time.Hour)
+	}
+	return CalEvents{
+		Created: time.Now(),
+		Start:   start,
+		End:     end,
+		NoDays:  int(math.Ceil(end.Sub(start).Hours() / 24)),
+	}
+}
+
+// SetLocation converts the event times to the time zone and recalculates the display values.
+// All-day events keep their dates, and start and end at midnight in the time zone.
+func (e *CalEvent) SetLocation(loc *time.Location) {
+	if e.AllDay {
+		e.Start = time.Date(e.Start.Year(), e.Start.Month(), e.Start.Day(), 0, 0, 0, 0, loc)
+		e.End = time.Date(e.End.Year(), e.End.Month(), e.End.Day(), 0, 0, 0, 0, loc)
+	} else {
+		e.Start = e.Start.In(loc)
+		e.End = e.End.In(loc)
+	}
+	e.DayName = e.Start.Weekday().String()
+	e.Time = e.Start.Format("15:04")
+	e.Duration = GetDurationString(e.Start, e.End)
+	e.Days = GetDaySpan(e.Start, e.End, e.AllDay)
+}
+
+// SplitDays splits the event into one entry for each day that it spans, limited to the days
+// that overlap the time window.  Events that fall on a single day are returned unchanged.
+func (e CalEvent) SplitDays(start time.Time, end time.Time) []CalEvent {
+	if e.Days <= 1 {
+		return []CalEvent{e}
+	}
+	l := []CalEvent{}
+	loc := e.Start.Location()
+	for i := 0; i < e.Days; i++ {
+		ds := time.Date(e.Start.Year(), e.Start.Month(), e.Start.Day()+i, 0, 0, 0, 0, loc)
+		de := time.Date(e.Start.Year(), e.Start.Month(), e.Start.Day()+i+1, 0, 0, 0, 0, loc)
+		if !ds.Before(end) || !de.After(start) {
+			continue
+		}
+		d := e
+		d.Day = i + 1
+		if e.Start.After(ds) {
+			ds = e.Start
+		}
+		if e.End.Before(de) {
+			de = e.End
+		}
+		d.Start = ds
+		d.End = de
+		d.InProgress = ds.Before(start)
+		d.DayName = ds.Weekday().String()
+		d.Time = ds.Format("15:04")
+		d.Duration = GetDurationString(ds, de)
+		l = append(l, d)
+	}
+	return l
+}
+
+// Span returns the start and end times of the event, and whether it lasts for whole days,
+// so that it can be laid out in a grid.
+func (e CalEvent) Span() (time.Time, time.Time, bool) {
+	return e.Start, e.End, e.AllDay
+}
+
+// Overlaps returns true if the event overlaps the time window, i.e. it starts before the
+// end of the window and ends after the start of the window.
+func (e CalEvent) Overlaps(start time.Time, end time.Time) bool {
+	if !e.Start.Before(end) {
+		return false
+	}
+	if e.End.After(e.Start) {
+		return e.End.After(start)
+	}
+	// Events without a duration must start inside the window
+	return !e.Start.Before(start)
 }
 
 // ReadFromFile will read the calendar events from the specified file
@@ -55,25 +144,58 @@ func (c *CalEvents) WriteToFile(path string) error {
 	return ioutil.WriteFile(path, b, 0666)
 }
 
-// GetDurationString returns the duration as a printable string
+// GetDurationString returns the duration as a printable string.
+// Events that start and end at midnight are shown in whole days.
 func GetDurationString(start time.Time, end time.Time) string {
+	if isMidnight(start) && isMidnight(end.In(start.Location())) && end.After(start) {
+		if n := daysBetween(start, end); n == 1 {
+			return "All Day"
+		} else if n > 1 {
+			return fmt.Sprintf("%d days", n)
+		}
+	}
+
 	d := end.Sub(start)
-	h := d.Hours()
-	m := math.Mod(d.Minutes(), 60)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
 
 	r := ""
-	if h == 24 && m == 0 {
-		r = "All Day"
-	} else {
-		if h >= 1 {
-			r = r + fmt.Sprintf("%2.fh", h)
-		}
-		if m != 0 {
-			if r != "" {
-				r = r + " "
-			}
-			r = r + fmt.Sprintf("%2.fm", m)
-		}
+	if h >= 24 {
+		r = r + fmt.Sprintf("%dd ", h/24)
+		h = h % 24
+	}
+	if h >= 1 {
+		r = r + fmt.Sprintf("%dh ", h)
+	}
+	if m != 0 {
+		r = r + fmt.Sprintf("%dm", m)
 	}
 	return strings.Trim(r, " ")
 }
+
+// GetDaySpan returns the number of days that the event spans.  All-day events end at midnight
+// on the day after their last day.
+func GetDaySpan(start time.Time, end time.Time, allDay bool) int {
+	if !end.After(start) {
+		return 1
+	}
+	if allDay {
+		return daysBetween(start, end)
+	}
+	// Timed events that end at midnight do not extend into the next day
+	return daysBetween(start, end.Add(-time.Nanosecond)) + 1
+}
+
+// daysBetween returns the number of calendar days between the dates of the two times,
+// using the time zone of the start time.
+func daysBetween(start time.Time, end time.Time) int {
+	end = end.In(start.Location())
+	a := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// isMidnight returns true if the time is at midnight.
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventOverlapsWindow(t *testing.T) {
+	ts := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	te := ts.Add(4 * time.Hour)
+	for _, tc := range []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		overlaps bool
+	}{
+		{"Inside", ts.Add(time.Hour), ts.Add(2 * time.Hour), true},
+		{"In progress", ts.Add(-10 * time.Minute), ts.Add(50 * time.Minute), true},
+		{"All day", time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), true},
+		{"Spans window", ts.Add(-time.Hour), te.Add(time.Hour), true},
+		{"Ended at start", ts.Add(-time.Hour), ts, false},
+		{"Starts at end", te, te.Add(time.Hour), false},
+		{"No duration at start", ts, ts, true},
+		{"No duration before", ts.Add(-time.Minute), ts.Add(-time.Minute), false},
+	} {
+		e := CalEvent{Start: tc.start, End: tc.end}
+		if e.Overlaps(ts, te) != tc.overlaps {
+			t.Errorf("%s: expected overlaps to be %v", tc.name, tc.overlaps)
+		}
+	}
+}
+
+func TestCanGetDurationString(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := time.Date(2018, 3, 1, 9, 0, 0, 0, loc)
+	for _, tc := range []struct {
+		start time.Time
+		end   time.Time
+		exp   string
+	}{
+		{st, st.Add(90 * time.Minute), "1h 30m"},
+		{st, st.Add(45 * time.Minute), "45m"},
+		{st, st.Add(2 * time.Hour), "2h"},
+		{st, st.Add(32 * time.Hour), "1d 8h"},
+		{time.Date(2018, 3, 1, 0, 0, 0, 0, loc), time.Date(2018, 3, 2, 0, 0, 0, 0, loc), "All Day"},
+		{time.Date(2018, 3, 1, 0, 0, 0, 0, loc), time.Date(2018, 3, 3, 0, 0, 0, 0, loc), "2 days"},
+		// Daylight saving starts on the 25th of March, so the day is only 23 hours long
+		{time.Date(2018, 3, 25, 0, 0, 0, 0, loc), time.Date(2018, 3, 26, 0, 0, 0, 0, loc), "All Day"},
+	} {
+		if d := GetDurationString(tc.start, tc.end); d != tc.exp {
+			t.Errorf("Wrong duration for %v - %v. Expected '%s', got '%s'", tc.start, tc.end, tc.exp, d)
+		}
+	}
+}
+
+func TestCanSplitMultiDayEvents(t *testing.T) {
+	e := CalEvent{
+		Summary: "Conference",
+		Start:   time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC),
+		End:     time.Date(2018, 3, 3, 17, 0, 0, 0, time.UTC),
+	}
+	e.SetLocation(time.UTC)
+	if e.Days != 3 {
+		t.Fatalf("Wrong number of days. Expected %d, got %d", 3, e.Days)
+	}
+	l := e.SplitDays(time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 3, 10, 0, 0, 0, 0, time.UTC))
+	if len(l) != 3 {
+		t.Fatalf("Wrong number of entries. Expected %d, got %d", 3, len(l))
+	}
+	for i, exp := range []string{"09:00 15h", "00:00 All Day", "00:00 17h"} {
+		if v := l[i].Time + " " + l[i].Duration; v != exp {
+			t.Errorf("Wrong entry for day %d. Expected '%s', got '%s'", i+1, exp, v)
+		}
+		if l[i].Day != i+1 {
+			t.Errorf("Wrong day number. Expected %d, got %d", i+1, l[i].Day)
+		}
+	}
+}
+
+func TestAllDayEventsKeepTheirDates(t *testing.T) {
+	sast := time.FixedZone("SAST", 2*3600)
+	e := CalEvent{
+		AllDay: true,
+		Start:  time.Date(2018, 3, 1, 0, 0, 0, 0, sast),
+		End:    time.Date(2018, 3, 3, 0, 0, 0, 0, sast),
+	}
+	e.SetLocation(time.UTC)
+	if e.DayName != "Thursday" || e.Time != "00:00" || e.Duration != "2 days" || e.Days != 2 {
+		t.Errorf("All-day event not kept on its dates. Got %s %s %s %d days", e.DayName, e.Time, e.Duration, e.Days)
+	}
+}
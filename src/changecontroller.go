@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ChangeController handles the Web Methods that return the changes found when refreshing the calendars.
+type ChangeController struct {
+	Srv *Server
+}
+
+// AddController adds the controller routes to the router
+func (c *ChangeController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/calendar/changes").Name("GetChanges").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetChanges)))
+}
+
+func (c *ChangeController) handleGetChanges(w http.ResponseWriter, r *http.Request) {
+	if c.Srv.Store == nil {
+		http.Error(w, "The event store is not available", 500)
+		return
+	}
+	cal := &CalendarController{Srv: c.Srv}
+	q, err := cal.getEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	since, after, types, err := c.getChangeQuery(r, q.Location)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	cl, err := c.Srv.Store.Changes(since, after)
+	if err != nil {
+		m := fmt.Sprintf("Error reading changes. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	l := []EventChange{}
+	for _, ch := range cl {
+		if len(q.Calendars) != 0 && !containsString(q.Calendars, ch.Calendar) {
+			continue
+		}
+		if len(types) != 0 && !containsString(types, ch.Type) {
+			continue
+		}
+		if !q.matches(ch.Event) {
+			continue
+		}
+		ch.Event.SetLocation(q.Location)
+		if ch.Previous != nil {
+			ch.Previous.SetLocation(q.Location)
+		}
+		l = append(l, ch)
+	}
+	if q.Limit > 0 && len(l) > q.Limit {
+		l = l[:q.Limit]
+	}
+
+	if b, err := json.Marshal(l); err != nil {
+		m := fmt.Sprintf("Error serializing changes. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else {
+		w.Header().Set("content-type", "application/json")
+		w.Write(b)
+	}
+}
+
+// getChangeQuery reads the changes requested from the query string.  Changes found in the last day
+// are returned if since is not specified.
+func (c *ChangeController) getChangeQuery(r *http.Request, loc *time.Location) (time.Time, uint64, []string, error) {
+	qs := r.URL.Query()
+	since := time.Now().Add(-24 * time.Hour)
+	if v := qs.Get("since"); v != "" {
+		t, err := parseQueryTime(v, loc)
+		if err != nil {
+			return since, 0, nil, fmt.Errorf("Invalid since date '%s'. %s", v, err.Error())
+		}
+		since = t
+	}
+	after := uint64(0)
+	if v := qs.Get("after"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return since, 0, nil, fmt.Errorf("Invalid sequence number '%s'", v)
+		}
+		after = n
+		if qs.Get("since") == "" {
+			// Polling by sequence number returns all the changes kept
+			since = time.Time{}
+		}
+	}
+	types := []string{}
+	for _, t := range splitQueryList(qs["type"]) {
+		t = strings.ToLower(t)
+		if t != ChangeAdded && t != ChangeModified && t != ChangeCancelled {
+			return since, 0, nil, fmt.Errorf("Invalid change type '%s'.  Use added, modified or cancelled", t)
+		}
+		types = append(types, t)
+	}
+	return since, after, types, nil
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *ChangeController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("ChangeController: [Inf] ", a[1:len(a)-1])
+}
+
+// LogError is used to log error messages for this controller.
+func (c *ChangeController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("ChangeController: [Err] ", a[1:len(a)-1])
+}
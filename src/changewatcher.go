@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ChangeWatcher polls the sources of the calendars with providers that implement Watcher,
+// and refreshes a calendar as soon as its source changes.
+type ChangeWatcher struct {
+	Srv      *Server       // Server the watcher belongs to
+	Interval time.Duration // Time between checks for changes
+	mu       sync.Mutex
+	sigs     map[string]string // Last signature of each calendar
+}
+
+// NewChangeWatcher creates a new change watcher that checks for changes every 10 seconds.
+func NewChangeWatcher(s *Server) *ChangeWatcher {
+	return &ChangeWatcher{
+		Srv:      s,
+		Interval: 10 * time.Second,
+		sigs:     map[string]string{},
+	}
+}
+
+// Run checks for changes until the exit channel is closed.
+func (w *ChangeWatcher) Run(exit <-chan struct{}) {
+	w.Check()
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-exit:
+			return
+		case <-t.C:
+			w.Check()
+		}
+	}
+}
+
+// Check checks each calendar for changes and invalidates the calendars that have changed.
+// It returns the identifiers of the calendars that changed.
+func (w *ChangeWatcher) Check() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changed := []string{}
+	seen := map[string]bool{}
+	for _, cc := range w.Srv.Config.Snapshot().Calendars {
+		p, err := NewCalendarProvider(cc.Provider)
+		if err != nil {
+			continue
+		}
+		cw, ok := p.(Watcher)
+		if !ok {
+			continue
+		}
+		p.SetConfig(cc)
+		seen[cc.ID] = true
+		sig, err := cw.GetSignature()
+		if err != nil {
+			w.logError(fmt.Sprintf("Error checking calendar %s for changes. %s", cc.Name, err.Error()))
+			continue
+		}
+		if last, ok := w.sigs[cc.ID]; ok && last != sig {
+			w.logDebug("Calendar changed", cc.Name)
+			w.Srv.Scheduler.Invalidate(cc.ID)
+			changed = append(changed, cc.ID)
+		}
+		w.sigs[cc.ID] = sig
+	}
+
+	// Forget calendars that have been removed
+	for id := range w.sigs {
+		if !seen[id] {
+			delete(w.sigs, id)
+		}
+	}
+	return changed
+}
+
+// logDebug logs a debug message to the logger
+func (w *ChangeWatcher) logDebug(v ...interface{}) {
+	if w.Srv.VerboseLogging {
+		a := fmt.Sprint(v)
+		logger.Info("ChangeWatcher: [Dbg] ", a[1:len(a)-1])
+	}
+}
+
+// logError logs an error message to the logger
+func (w *ChangeWatcher) logError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("ChangeWatcher: [Err] ", a[1:len(a)-1])
+}
@@ -6,29 +6,106 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Config holds the configuration required for the Soil Monitor module.
+// The configuration is changed by the HTTP handlers while the background workers read it, so the
+// workers must read it through Snapshot, and the handlers must change it through Update.
 type Config struct {
-	Calendars []CalConfig `json:"calendars"` // List of calendars
+	mu              sync.RWMutex
+	Calendars       []CalConfig  `json:"calendars"`          // List of calendars
+	RefreshInterval int          `json:"refreshInterval"`    // Default number of minutes between calendar refreshes
+	CacheDays       int          `json:"cacheDays"`          // Number of days of events held in the event cache
+	TimeZone        string       `json:"timeZone"`           // Default time zone used to display events, e.g. Africa/Johannesburg.  Blank for the local time zone.
+	ChangeDays      int          `json:"changeDays"`         // Number of days that the changes found when refreshing the calendars are kept
+	Profiles        []Profile    `json:"profiles,omitempty"` // Display profiles, each with the rules used to filter the events shown
+	Webhooks        []Webhook    `json:"webhooks,omitempty"` // Webhooks notified of the changes found when refreshing the calendars
+	Sinks           []SinkConfig `json:"sinks,omitempty"`    // Sinks that the reminders of upcoming events are sent to
+	MQTT            *MQTTConfig  `json:"mqtt,omitempty"`     // MQTT server that the events are published to, for Home Assistant
+}
+
+// Profile holds the rules used to filter the events shown on a display, e.g. a child's tablet.
+type Profile struct {
+	Name      string   `json:"name"`                // Name of the profile, used in the profile query string parameter
+	Calendars []string `json:"calendars,omitempty"` // Identifiers or names of the calendars shown, or all of them if empty
+	Search    string   `json:"search,omitempty"`    // Words that each event must contain
+	Exclude   []string `json:"exclude,omitempty"`   // Keywords of events that are not shown
+	Limit     int      `json:"limit,omitempty"`     // Maximum number of events shown, or 0 for no limit
+}
+
+// Webhook holds the details of a URL that is sent the changes found when refreshing the calendars.
+type Webhook struct {
+	ID        string   `json:"id"`                  // Unique identifier of the webhook (GUID)
+	URL       string   `json:"url"`                 // URL the changes are posted to
+	Secret    string   `json:"secret,omitempty"`    // Secret used to sign the changes posted
+	Calendars []string `json:"calendars,omitempty"` // Identifiers or names of the calendars, or all of them if empty
+	Types     []string `json:"types,omitempty"`     // Types of change sent, or all of them if empty
+}
+
+// SinkConfig holds the details of a sink that reminders are sent to.  The fields used depend on the type of sink.
+type SinkConfig struct {
+	Name     string   `json:"name"`               // Name of the sink, used by the reminder rules
+	Type     string   `json:"type"`               // Type of sink, webhook, ntfy, smtp or mqtt
+	URL      string   `json:"url"`                // URL posted to, the ntfy topic URL, or the SMTP or MQTT server URL
+	Username string   `json:"username,omitempty"` // User name used to authenticate
+	Password string   `json:"password,omitempty"` // Password used to authenticate
+	Token    string   `json:"token,omitempty"`    // Access token used to authenticate with ntfy
+	Secret   string   `json:"secret,omitempty"`   // Secret used to sign webhook reminders
+	Topic    string   `json:"topic,omitempty"`    // MQTT topic published to
+	From     string   `json:"from,omitempty"`     // Address emails are sent from
+	To       []string `json:"to,omitempty"`       // Addresses emails are sent to
+}
+
+// MQTTConfig holds the details of the MQTT server that the next event, today's events, and whether each
+// calendar is busy, are published to.
+type MQTTConfig struct {
+	URL             string `json:"url"`                       // MQTT server URL, e.g. tcp://localhost:1883.  Blank to not publish.
+	Username        string `json:"username,omitempty"`        // User name used to authenticate
+	Password        string `json:"password,omitempty"`        // Password used to authenticate
+	ClientID        string `json:"clientId,omitempty"`        // Client identifier, also used to identify the device in Home Assistant.  Defaults to calendar.
+	Topic           string `json:"topic,omitempty"`           // Topic the events are published under.  Defaults to calendar.
+	DiscoveryPrefix string `json:"discoveryPrefix,omitempty"` // Home Assistant discovery prefix.  Defaults to homeassistant.
+}
+
+// ReminderRule specifies when reminders are sent for the events of a calendar.  Rules with a time of day
+// apply to all-day events, and the other rules apply to events with start times.
+type ReminderRule struct {
+	Before int      `json:"before,omitempty"` // Number of minutes before the start of the event that the reminder is sent
+	At     string   `json:"at,omitempty"`     // Time of day, e.g. 08:00, that the reminder is sent for all-day events
+	Days   int      `json:"days,omitempty"`   // Number of days before an all-day event that the reminder is sent
+	Sinks  []string `json:"sinks,omitempty"`  // Names of the sinks the reminder is sent to, or all of them if empty
 }
 
 // CalConfig holds the configuration details for a specific calendar
 type CalConfig struct {
-	ID       string `json:"id"`       // Unique identifier of this calendar (GUID)
-	Name     string `json:"name"`     // Display name of the calendar
-	Provider string `json:"provider"` // Provider type.
-	Colour   string `json:"colour"`   // Display colour
-	URL      string `json:"url"`      // Calendar URL
+	ID              string            `json:"id"`                    // Unique identifier of this calendar (GUID)
+	Name            string            `json:"name"`                  // Display name of the calendar
+	Provider        string            `json:"provider"`              // Provider type.
+	Colour          string            `json:"colour"`                // Display colour
+	URL             string            `json:"url"`                   // Calendar URL
+	RefreshInterval int               `json:"refreshInterval"`       // Number of minutes between refreshes, 0 to use the default
+	TimeZone        string            `json:"timeZone"`              // Time zone of floating times and all-day events.  Blank for the default time zone.
+	Account         string            `json:"account,omitempty"`     // Provider account the calendar belongs to, used to share authentication between calendars
+	CalendarIDs     []string          `json:"calendarIds,omitempty"` // Identifiers of the calendars to show from the provider account
+	Reminders       []ReminderRule    `json:"reminders,omitempty"`   // Rules for the reminders sent for upcoming events
+	Values          map[string]string `json:"values,omitempty"`      // Provider specific configuration values, by name
 }
 
 // NewCalConfig holds the details about a new calendar configuration
 type NewCalConfig struct {
-	Name     string `json:"name"`     // Display name of the calendar
-	Provider string `json:"provider"` // Calendar provider
-	Colour   string `json:"colour"`   // Display Colour
-	AuthCode string `json:"authCode"` // Authorization Code
-	URL      string `json:"url"`      // Calendar URL
+	Name        string            `json:"name"`        // Display name of the calendar
+	Provider    string            `json:"provider"`    // Calendar provider
+	Colour      string            `json:"colour"`      // Display Colour
+	AuthCode    string            `json:"authCode"`    // Authorization Code
+	URL         string            `json:"url"`         // Calendar URL
+	Username    string            `json:"username"`    // User name used to authenticate
+	Password    string            `json:"password"`    // Password used to authenticate
+	Account     string            `json:"account"`     // Existing provider account to use instead of authenticating
+	CalendarIDs []string          `json:"calendarIds"` // Identifiers of the calendars chosen from the provider account
+	Values      map[string]string `json:"values"`      // Provider specific configuration values, by name
 }
 
 // ReadFromFile will read the configuration settings from the specified file
@@ -46,11 +123,52 @@ func (c *Config) ReadFromFile(path string) error {
 
 // WriteToFile will write the configuration settings to the specified file
 func (c *Config) WriteToFile(path string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.writeToFile(path)
+}
+
+// writeToFile writes the configuration settings to the file, without locking them.
+func (c *Config) writeToFile(path string) error {
 	b, err := json.Marshal(c)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, b, 0666)
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	// Files written before by older versions keep their permissions, so restrict them as well
+	return os.Chmod(path, 0600)
+}
+
+// Snapshot returns a copy of the configuration, which can be read while the configuration is changed.
+func (c *Config) Snapshot() *Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cc := &Config{
+		Calendars:       append([]CalConfig(nil), c.Calendars...),
+		RefreshInterval: c.RefreshInterval,
+		CacheDays:       c.CacheDays,
+		TimeZone:        c.TimeZone,
+		ChangeDays:      c.ChangeDays,
+		Profiles:        append([]Profile(nil), c.Profiles...),
+		Webhooks:        append([]Webhook(nil), c.Webhooks...),
+		Sinks:           append([]SinkConfig(nil), c.Sinks...),
+	}
+	if c.MQTT != nil {
+		m := *c.MQTT
+		cc.MQTT = &m
+	}
+	return cc
+}
+
+// Update changes the configuration with the function, while it is locked for writing, and then writes
+// it to the specified file.  The function must not call the other methods of the configuration.
+func (c *Config) Update(path string, f func(c *Config)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(c)
+	return c.writeToFile(path)
 }
 
 // ReadFrom reads the string from the reader and deserializes it into the entity values
@@ -65,9 +183,16 @@ func (c *Config) ReadFrom(r io.ReadCloser) error {
 	return err
 }
 
-// WriteTo serializes the entity and writes it to the http response
+// WriteTo serializes the entity and writes it to the http response.
+// The secrets of the webhooks and sinks, and the MQTT password, are not written.
 func (c *Config) WriteTo(w http.ResponseWriter) error {
-	b, err := json.Marshal(c)
+	cc := c.Snapshot()
+	cc.Webhooks = maskWebhooks(cc.Webhooks)
+	cc.Sinks = maskSinks(cc.Sinks)
+	if cc.MQTT != nil {
+		cc.MQTT.Password = ""
+	}
+	b, err := json.Marshal(cc)
 	if err != nil {
 		return err
 	}
@@ -78,6 +203,8 @@ func (c *Config) WriteTo(w http.ResponseWriter) error {
 
 // Serialize serializes the entity and returns the serialized string
 func (c *Config) Serialize() (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	b, err := json.Marshal(c)
 	if err != nil {
 		return "", err
@@ -95,7 +222,55 @@ func (c *Config) Deserialize(v string) error {
 // SetDefaults checks the configuration and makes sure that, if a value is not configured, the default value is set.
 func (c *Config) SetDefaults() {
 	// Set any defaults required
+	if c.TimeZone != "" {
+		if _, err := time.LoadLocation(c.TimeZone); err != nil {
+			c.TimeZone = ""
+		}
+	}
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = 15
+	}
+	if c.CacheDays <= 0 {
+		c.CacheDays = 31
+	}
+	if c.ChangeDays <= 0 {
+		c.ChangeDays = 30
+	}
+	if c.MQTT != nil {
+		if c.MQTT.ClientID == "" {
+			c.MQTT.ClientID = "calendar"
+		}
+		if c.MQTT.Topic == "" {
+			c.MQTT.Topic = "calendar"
+		}
+		if c.MQTT.DiscoveryPrefix == "" {
+			c.MQTT.DiscoveryPrefix = "homeassistant"
+		}
+	}
+}
 
+// GetProfile returns the display profile with the name.
+func (c *Config) GetProfile(name string) (Profile, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, p := range c.Profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Location returns the default time zone used to display events.
+func (c *Config) Location() *time.Location {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return loadLocation(c.TimeZone)
+}
+
+// Location returns the time zone of the floating times and all-day events of the calendar.
+func (c *CalConfig) Location() *time.Location {
+	return loadLocation(c.TimeZone)
 }
 
 // WriteTo serializes the entity and writes it to the http response
@@ -108,3 +283,25 @@ func (c *CalConfig) WriteTo(w http.ResponseWriter) error {
 	w.Write(b)
 	return nil
 }
+
+// loadLocation returns the named time zone, or the local time zone if the name is blank or invalid.
+func loadLocation(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	if l, err := time.LoadLocation(name); err == nil {
+		return l
+	}
+	return time.Local
+}
+
+// splitLines splits the value into its trimmed, non-blank lines.
+func splitLines(v string) []string {
+	l := []string{}
+	for _, i := range strings.Split(v, "\n") {
+		if i = strings.TrimSpace(i); i != "" {
+			l = append(l, i)
+		}
+	}
+	return l
+}
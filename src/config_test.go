@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFileIsOnlyReadableByOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An existing file written by an older version is restricted as well
+	fn := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(fn, []byte("{}"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(fn, 0666)
+	cfg := Config{Sinks: []SinkConfig{{Name: "phone", Type: "ntfy", Token: "secret"}}}
+	if err := cfg.WriteToFile(fn); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := fi.Mode().Perm(); m != 0600 {
+		t.Errorf("Config file permissions are %o, expected 600", m)
+	}
+}
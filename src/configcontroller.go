@@ -1,10 +1,11 @@
 package main
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -16,16 +17,27 @@ type ConfigController struct {
 
 // ConfigPageData holds the data used to write to the configuration page.
 type ConfigPageData struct {
-	GoogleURL string
+	Providers []ConfigPageProvider
 	Calendars []CalConfig
 }
 
+// ConfigPageProvider holds the details of a calendar provider shown on the configuration page.
+type ConfigPageProvider struct {
+	ProviderInfo
+	AuthURL  string   // URL used to authenticate with the provider, if required
+	Accounts []string // Accounts already used by the calendars of the provider
+}
+
 // AddController adds the controller routes to the router
 func (c *ConfigController) AddController(router *mux.Router, s *Server) {
 	c.Srv = s
 	router.Path("/config.html").Handler(http.HandlerFunc(c.handleConfigWebPage))
 	router.Methods("GET").Path("/config/get").Name("GetConfig").
 		Handler(Logger(c, http.HandlerFunc(c.handleGetConfig)))
+	router.Methods("GET").Path("/config/providers").Name("GetProviders").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetProviders)))
+	router.Methods("POST").Path("/config/calendars").Name("ListCalendars").
+		Handler(Logger(c, http.HandlerFunc(c.handleListCalendars)))
 	router.Methods("GET").Path("/config/get/{id}").Name("GetCalendar").
 		Handler(Logger(c, http.HandlerFunc(c.handleGetCalendar)))
 	router.Methods("POST").Path("/config/add").Name("AddCalendar").
@@ -34,21 +46,41 @@ func (c *ConfigController) AddController(router *mux.Router, s *Server) {
 		Handler(Logger(c, http.HandlerFunc(c.handleUpdateCalendar)))
 	router.Methods("POST").Path("/config/remove/{id}").Name("RemoveCalendar").
 		Handler(Logger(c, http.HandlerFunc(c.handleRemoveCalendar)))
+	router.Methods("GET").Path("/config/profiles").Name("GetProfiles").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetProfiles)))
+	router.Methods("POST").Path("/config/profile").Name("SaveProfile").
+		Handler(Logger(c, http.HandlerFunc(c.handleSaveProfile)))
+	router.Methods("POST").Path("/config/profile/remove/{name}").Name("RemoveProfile").
+		Handler(Logger(c, http.HandlerFunc(c.handleRemoveProfile)))
 }
 
 func (c *ConfigController) handleConfigWebPage(w http.ResponseWriter, r *http.Request) {
 	t := template.Must(template.ParseFiles("./html/config.html"))
 
-	gc := new(GCalendar)
-	gurl, err := gc.GetAuthenticateURL()
-	if err != nil {
-		http.Error(w, "Error getting Google Authentication URL.", 500)
-		return
+	cfg := c.Srv.Config.Snapshot()
+	pl := []ConfigPageProvider{}
+	for _, i := range GetProviderInfos() {
+		pp := ConfigPageProvider{ProviderInfo: i}
+		if a, ok := i.New().(Authenticator); ok {
+			u, err := a.GetAuthenticateURL()
+			if err != nil {
+				// The provider cannot be offered until its application credentials are set up
+				c.LogError(fmt.Sprintf("Error getting %s Authentication URL. %s", i.Name, err.Error()))
+				continue
+			}
+			pp.AuthURL = u
+		}
+		for _, cc := range cfg.Calendars {
+			if cc.Provider == i.Name && cc.Account != "" && !containsString(pp.Accounts, cc.Account) {
+				pp.Accounts = append(pp.Accounts, cc.Account)
+			}
+		}
+		pl = append(pl, pp)
 	}
 
 	v := ConfigPageData{
-		Calendars: c.Srv.Config.Calendars,
-		GoogleURL: gurl,
+		Calendars: cfg.Calendars,
+		Providers: pl,
 	}
 
 	if err := t.Execute(w, v); err != nil {
@@ -62,6 +94,47 @@ func (c *ConfigController) handleGetConfig(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+func (c *ConfigController) handleGetProviders(w http.ResponseWriter, r *http.Request) {
+	if b, err := json.Marshal(GetProviderInfos()); err != nil {
+		m := fmt.Sprintf("Error serializing calendar providers. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else {
+		w.Header().Set("content-type", "application/json")
+		w.Write(b)
+	}
+}
+
+func (c *ConfigController) handleListCalendars(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	nc := NewCalConfig{Provider: r.Form.Get("addProvider")}
+	pi, err := GetProviderInfo(nc.Provider)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	p, ok := pi.New().(CalendarLister)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Calendar provider %s cannot list calendars", pi.Name), 500)
+		return
+	}
+	pi.NewConfigFromForm(&nc, r.Form)
+	acc, err := p.ListCalendars(nc)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if b, err := json.Marshal(acc); err != nil {
+		m := fmt.Sprintf("Error serializing calendar list. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else {
+		w.Header().Set("content-type", "application/json")
+		w.Write(b)
+	}
+}
+
 func (c *ConfigController) handleGetCalendar(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -70,7 +143,7 @@ func (c *ConfigController) handleGetCalendar(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	for _, i := range c.Srv.Config.Calendars {
+	for _, i := range c.Srv.Config.Snapshot().Calendars {
 		if i.ID == id {
 			if err := i.WriteTo(w); err != nil {
 				http.Error(w, "Error serializing calendar configuration. "+err.Error(), 500)
@@ -91,7 +164,7 @@ func (c *ConfigController) handleAddCalendar(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Check name or colour does not already exist
-	for _, i := range c.Srv.Config.Calendars {
+	for _, i := range c.Srv.Config.Snapshot().Calendars {
 		if i.Name == nc.Name {
 			http.Error(w, "This name has already been used.  Please select another name.", 500)
 			return
@@ -103,17 +176,13 @@ func (c *ConfigController) handleAddCalendar(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Get the calendar provider
-	p, err := c.getCalendarProvider(nc.Provider)
+	pi, err := GetProviderInfo(nc.Provider)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	switch nc.Provider {
-	case "Google":
-		nc.AuthCode = r.Form.Get("addGoogleCode")
-	case "iCal":
-		nc.URL = r.Form.Get("addiCalUrl")
-	}
+	p := pi.New()
+	pi.NewConfigFromForm(&nc, r.Form)
 	// Validate the config
 	cc, err := p.ValidateNewConfig(nc)
 	if err != nil {
@@ -121,13 +190,15 @@ func (c *ConfigController) handleAddCalendar(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// Append the new configuration
-	c.Srv.Config.Calendars = append(c.Srv.Config.Calendars, cc)
-	err = c.Srv.Config.WriteToFile("config.json")
+	err = c.Srv.Config.Update("config.json", func(cfg *Config) {
+		cfg.Calendars = append(cfg.Calendars, cc)
+	})
 	if err != nil {
 		m := fmt.Sprintf("Error saving config.json file. %s", err.Error())
 		c.LogError(m)
 		http.Error(w, m, 500)
 	}
+	c.Srv.Scheduler.Invalidate(cc.ID)
 }
 
 func (c *ConfigController) handleUpdateCalendar(w http.ResponseWriter, r *http.Request) {
@@ -139,49 +210,62 @@ func (c *ConfigController) handleUpdateCalendar(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	cals := []CalConfig{}
-	found := true
-	for _, i := range c.Srv.Config.Calendars {
+	var cc CalConfig
+	found := false
+	for _, i := range c.Srv.Config.Snapshot().Calendars {
 		if i.ID == id {
-			cc := CalConfig{
-				ID:       id,
-				Name:     r.Form.Get("updName"),
-				Provider: i.Provider,
-				Colour:   r.Form.Get("updColour"),
-			}
-			switch cc.Provider {
-			case "iCal":
-				cc.URL = r.Form.Get("updUrl")
-			}
-
-			p, err := c.getCalendarProvider(i.Provider)
-			if err != nil {
-				http.Error(w, err.Error(), 500)
-				return
-			}
-			cc, err = p.ValidateConfig(cc)
-			if err != nil {
-				http.Error(w, err.Error(), 500)
-				return
-			}
-
-			cals = append(cals, cc)
+			cc = i
 			found = true
-		} else {
-			cals = append(cals, i)
 		}
 	}
+	if !found {
+		http.Error(w, "Invalid calendar identifier", 500)
+		return
+	}
 
-	if found {
-		c.Srv.Config.Calendars = cals
-		if err := c.Srv.Config.WriteToFile("config.json"); err != nil {
-			m := fmt.Sprintf("Error writing config.json file. %s", err.Error())
-			c.LogError(m)
-			http.Error(w, m, 500)
+	old := cc
+	cc.Name = r.Form.Get("updName")
+	cc.Colour = r.Form.Get("updColour")
+	pi, err := GetProviderInfo(cc.Provider)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	pi.UpdateConfigFromForm(&cc, r.Form)
+	p := pi.New()
+	cc, err = p.ValidateConfig(cc)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = c.Srv.Config.Update("config.json", func(cfg *Config) {
+		cals := []CalConfig{}
+		for _, i := range cfg.Calendars {
+			if i.ID == id {
+				i = cc
+			}
+			cals = append(cals, i)
+		}
+		cfg.Calendars = cals
+		// Profiles and webhooks saved with the old name of the calendar refer to it by its identifier instead
+		for i := range cfg.Profiles {
+			cfg.Profiles[i].Calendars = renameCalendar(cfg.Profiles[i].Calendars, id, old.Name)
+		}
+		for i := range cfg.Webhooks {
+			cfg.Webhooks[i].Calendars = renameCalendar(cfg.Webhooks[i].Calendars, id, old.Name)
+		}
+	})
+	if err != nil {
+		m := fmt.Sprintf("Error writing config.json file. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else if u, ok := p.(ConfigUpdater); ok {
+		if err := u.UpdatedConfig(old, cc); err != nil {
+			c.LogError(fmt.Sprintf("Error cleaning up %s for updated config item %s. %s", p.ProviderName(), id, err.Error()))
 		}
-	} else {
-		http.Error(w, "Invalid calendar identifier", 500)
 	}
+	c.Srv.Scheduler.Invalidate(id)
 }
 
 func (c *ConfigController) handleRemoveCalendar(w http.ResponseWriter, r *http.Request) {
@@ -192,26 +276,38 @@ func (c *ConfigController) handleRemoveCalendar(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	cl := []CalConfig{}
 	ri := CalConfig{}
 	found := false
-	for _, i := range c.Srv.Config.Calendars {
-		if i.ID == id {
-			c.LogInfo(fmt.Sprintf("Calendar %s removed.", i.Name))
-			ri = i
-			found = true
-		} else {
-			cl = append(cl, i)
+	err := c.Srv.Config.Update("config.json", func(cfg *Config) {
+		cl := []CalConfig{}
+		for _, i := range cfg.Calendars {
+			if i.ID == id {
+				ri = i
+				found = true
+			} else {
+				cl = append(cl, i)
+			}
 		}
-	}
+		cfg.Calendars = cl
+		if found {
+			for i, p := range cfg.Profiles {
+				l := removeCalendar(p.Calendars, id, ri.Name)
+				if len(l) == 0 && len(p.Calendars) != 0 {
+					c.LogInfo(fmt.Sprintf("Profile %s no longer has any calendars, so all the calendars are shown.", p.Name))
+				}
+				cfg.Profiles[i].Calendars = l
+			}
+		}
+	})
 	if found {
-		c.Srv.Config.Calendars = cl
-		if err := c.Srv.Config.WriteToFile("config.json"); err != nil {
+		c.LogInfo(fmt.Sprintf("Calendar %s removed.", ri.Name))
+		c.Srv.Scheduler.Remove(id)
+		if err != nil {
 			m := fmt.Sprintf("Error writing config.json file. %s", err.Error())
 			c.LogError(m)
 			http.Error(w, m, 500)
 		} else {
-			if p, err := c.getCalendarProvider(ri.Provider); err == nil {
+			if p, err := NewCalendarProvider(ri.Provider); err == nil {
 				err = p.RemovedConfig(ri)
 				if err != nil {
 					m := fmt.Sprintf("Error cleaning up %s for removed config item %s. %s", p.ProviderName(), ri.ID, err.Error())
@@ -227,18 +323,89 @@ func (c *ConfigController) handleRemoveCalendar(w http.ResponseWriter, r *http.R
 	}
 }
 
-func (c *ConfigController) getCalendarProvider(provider string) (CalendarProvider, error) {
-	switch provider {
-	case "Google":
-		return new(GCalendar), nil
-	case "iCal":
-		return new(ICalFeed), nil
-	default:
-		m := fmt.Sprintf("Invalid Calendar provider '%s'", provider)
-		return nil, errors.New(m)
+func (c *ConfigController) handleGetProfiles(w http.ResponseWriter, r *http.Request) {
+	l := c.Srv.Config.Snapshot().Profiles
+	if l == nil {
+		l = []Profile{}
+	}
+	if b, err := json.Marshal(l); err != nil {
+		m := fmt.Sprintf("Error serializing profiles. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else {
+		w.Header().Set("content-type", "application/json")
+		w.Write(b)
 	}
 }
 
+func (c *ConfigController) handleSaveProfile(w http.ResponseWriter, r *http.Request) {
+	p := Profile{}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid profile. "+err.Error(), 500)
+		return
+	}
+	if p.Name == "" {
+		http.Error(w, "Name must be specified", 500)
+		return
+	}
+	if p.Limit < 0 {
+		http.Error(w, "Limit must not be negative", 500)
+		return
+	}
+	// The calendars are saved by identifier, so that the profile still applies when they are renamed
+	ids, err := (&CalendarController{Srv: c.Srv}).resolveCalendars(p.Calendars)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(ids) == 0 {
+		ids = nil
+	}
+	p.Calendars = ids
+
+	// Replace the profile with the same name, or add it
+	err = c.Srv.Config.Update("config.json", func(cfg *Config) {
+		pl := []Profile{}
+		for _, i := range cfg.Profiles {
+			if !strings.EqualFold(i.Name, p.Name) {
+				pl = append(pl, i)
+			}
+		}
+		cfg.Profiles = append(pl, p)
+	})
+	if err != nil {
+		m := fmt.Sprintf("Error writing config.json file. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	c.LogInfo(fmt.Sprintf("Profile %s saved.", p.Name))
+}
+
+func (c *ConfigController) handleRemoveProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, ok := c.Srv.Config.GetProfile(name); !ok {
+		http.Error(w, "Invalid profile name", 500)
+		return
+	}
+	err := c.Srv.Config.Update("config.json", func(cfg *Config) {
+		pl := []Profile{}
+		for _, i := range cfg.Profiles {
+			if !strings.EqualFold(i.Name, name) {
+				pl = append(pl, i)
+			}
+		}
+		cfg.Profiles = pl
+	})
+	if err != nil {
+		m := fmt.Sprintf("Error writing config.json file. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	c.LogInfo(fmt.Sprintf("Profile %s removed.", name))
+}
+
 // LogInfo is used to log information messages for this controller.
 func (c *ConfigController) LogInfo(v ...interface{}) {
 	a := fmt.Sprint(v)
@@ -250,3 +417,37 @@ func (c *ConfigController) LogError(v ...interface{}) {
 	a := fmt.Sprint(v)
 	logger.Error("ConfigController: [Err] ", a[1:len(a)-1])
 }
+
+// renameCalendar returns the list of calendar identifiers or names, with the old name of the calendar
+// replaced by its identifier.
+func renameCalendar(l []string, id string, name string) []string {
+	var nl []string
+	for _, n := range l {
+		if n == name {
+			n = id
+		}
+		nl = append(nl, n)
+	}
+	return nl
+}
+
+// removeCalendar returns the list of calendar identifiers or names, without the removed calendar.
+func removeCalendar(l []string, id string, name string) []string {
+	var nl []string
+	for _, n := range l {
+		if n != id && n != name {
+			nl = append(nl, n)
+		}
+	}
+	return nl
+}
+
+// containsString returns true if the list contains the value.
+func containsString(l []string, v string) bool {
+	for _, i := range l {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// cacheTolerance is the difference allowed between the time windows of the cache and of a request, so that
+// windows calculated a moment apart still match.
+const cacheTolerance = time.Minute
+
+// EventCache holds the most recently retrieved events for each calendar in memory.
+// The event store, or the lastevents_<id>.json files written by older versions, are used to warm
+// the cache on start up.
+type EventCache struct {
+	mu     sync.RWMutex
+	events map[string]CalEvents
+}
+
+// NewEventCache creates a new, empty event cache.
+func NewEventCache() *EventCache {
+	return &EventCache{events: map[string]CalEvents{}}
+}
+
+// Load reads the last retrieved events for each of the specified calendars into the cache.
+// The events are read from the event store if it is specified and holds the calendar.
+func (c *EventCache) Load(cals []CalConfig, st *EventStore) {
+	for _, cc := range cals {
+		if evts, ok := readLastEvents(st, cc.ID); ok {
+			c.Set(cc.ID, evts)
+		}
+	}
+}
+
+// Get returns the cached events for the specified calendar.
+func (c *EventCache) Get(id string) (CalEvents, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	evts, ok := c.events[id]
+	return evts, ok
+}
+
+// Set replaces the cached events for the specified calendar.
+func (c *EventCache) Set(id string, evts CalEvents) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events[id] = evts
+}
+
+// Remove removes the cached events for the specified calendar.
+func (c *EventCache) Remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.events, id)
+}
+
+// windowCovers returns true if the window from start to end covers the window from ts to te, within the
+// cache tolerance.
+func windowCovers(start time.Time, end time.Time, ts time.Time, te time.Time) bool {
+	return !ts.Before(start.Add(-cacheTolerance)) && !te.After(end.Add(cacheTolerance))
+}
+
+// readLastEvents returns the last retrieved events for the calendar, from the event store if it is specified
+// and holds the calendar, or else from the lastevents_<id>.json file written by older versions.  False is
+// returned if neither holds the calendar.
+func readLastEvents(st *EventStore, id string) (CalEvents, bool) {
+	if st != nil {
+		if evts, ok, err := st.Get(id); err == nil && ok {
+			return evts, true
+		}
+	}
+	evts := CalEvents{}
+	if err := evts.ReadFromFile(getLastEventsFileName(id)); err != nil || evts.Created.IsZero() {
+		return CalEvents{}, false
+	}
+	return evts, true
+}
+
+// getLastEventsFileName returns the name of the file holding the last retrieved events for a calendar.
+func getLastEventsFileName(id string) string {
+	return fmt.Sprintf("lastevents_%s.json", id)
+}
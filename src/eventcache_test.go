@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCanWarmEventCache(t *testing.T) {
+	evts := CalEvents{
+		Created: time.Now(),
+		NoDays:  1,
+		Events:  []CalEvent{{ID: "testcache", Summary: "Test"}},
+	}
+	fn := getLastEventsFileName("testcache")
+	if err := evts.WriteToFile(fn); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fn)
+
+	c := NewEventCache()
+	c.Load([]CalConfig{{ID: "testcache"}, {ID: "testmissing"}}, nil)
+	if l, ok := c.Get("testcache"); !ok {
+		t.Error("Events not loaded into the cache")
+	} else if len(l.Events) != 1 {
+		t.Errorf("Wrong number of events cached. Expected %d, got %d", 1, len(l.Events))
+	}
+	if _, ok := c.Get("testmissing"); ok {
+		t.Error("Events cached for a calendar without a file")
+	}
+}
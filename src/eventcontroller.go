@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// EventController handles the Web Methods for creating, updating and deleting calendar events.
+type EventController struct {
+	Srv *Server
+}
+
+// EventRequest holds the details of an event to create or update.
+type EventRequest struct {
+	Calendar    string `json:"calendar"`    // Identifier of the calendar, only used when creating an event
+	Summary     string `json:"summary"`     // Summary of the event
+	Location    string `json:"location"`    // Location of the event
+	Description string `json:"description"` // Description of the event
+	Start       string `json:"start"`       // Start time, in RFC 3339 format, or the date of an all-day event
+	End         string `json:"end"`         // End time, or the day after an all-day event.  Defaults to 1 hour, or 1 day.
+	AllDay      bool   `json:"allDay"`      // Indicates the event lasts for whole days
+}
+
+// AddController adds the controller routes to the router
+func (c *EventController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("POST").Path("/calendar/event").Name("CreateEvent").
+		Handler(Logger(c, http.HandlerFunc(c.handleCreateEvent)))
+	router.Methods("PUT").Path("/calendar/event/{id}/{uid:.+}").Name("UpdateEvent").
+		Handler(Logger(c, http.HandlerFunc(c.handleUpdateEvent)))
+	router.Methods("DELETE").Path("/calendar/event/{id}/{uid:.+}").Name("DeleteEvent").
+		Handler(Logger(c, http.HandlerFunc(c.handleDeleteEvent)))
+}
+
+func (c *EventController) handleCreateEvent(w http.ResponseWriter, r *http.Request) {
+	req, e, err := c.readEvent(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	cc, p, err := c.getWriter(req.Calendar)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	e, err = p.CreateEvent(ctx, e)
+	if err != nil {
+		m := fmt.Sprintf("Error creating event in %s. %s", cc.Name, err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	c.LogInfo(fmt.Sprintf("Event %s created in %s.", e.UID, cc.Name))
+	c.Srv.Scheduler.Invalidate(cc.ID)
+	c.writeEvent(w, http.StatusCreated, e)
+}
+
+func (c *EventController) handleUpdateEvent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	_, e, err := c.readEvent(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	cc, p, err := c.getWriter(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	e.UID = vars["uid"]
+	e, err = p.UpdateEvent(ctx, e)
+	if err != nil {
+		m := fmt.Sprintf("Error updating event in %s. %s", cc.Name, err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	c.LogInfo(fmt.Sprintf("Event %s updated in %s.", e.UID, cc.Name))
+	c.Srv.Scheduler.Invalidate(cc.ID)
+	c.writeEvent(w, http.StatusOK, e)
+}
+
+func (c *EventController) handleDeleteEvent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cc, p, err := c.getWriter(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	if err := p.DeleteEvent(ctx, vars["uid"]); err != nil {
+		m := fmt.Sprintf("Error deleting event from %s. %s", cc.Name, err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	c.LogInfo(fmt.Sprintf("Event %s deleted from %s.", vars["uid"], cc.Name))
+	c.Srv.Scheduler.Invalidate(cc.ID)
+}
+
+// getWriter returns the configuration and provider of the calendar, if the provider can change events.
+func (c *EventController) getWriter(id string) (CalConfig, EventWriter, error) {
+	if id == "" {
+		return CalConfig{}, nil, errors.New("Calendar identifier not specified")
+	}
+	for _, cc := range c.Srv.Config.Snapshot().Calendars {
+		if cc.ID != id {
+			continue
+		}
+		p, err := NewCalendarProvider(cc.Provider)
+		if err != nil {
+			return cc, nil, err
+		}
+		ew, ok := p.(EventWriter)
+		if !ok {
+			return cc, nil, fmt.Errorf("Calendar %s is read-only.  The %s provider cannot change events.", cc.Name, cc.Provider)
+		}
+		if cc.TimeZone == "" {
+			cc.TimeZone = c.Srv.Config.TimeZone
+		}
+		p.SetConfig(cc)
+		return cc, ew, nil
+	}
+	return CalConfig{}, nil, errors.New("Invalid calendar identifier")
+}
+
+// readEvent reads the event details from the JSON request body.  Times without a time zone are
+// in the time zone specified by the tz query string parameter, or the default time zone.
+func (c *EventController) readEvent(r *http.Request) (EventRequest, CalEvent, error) {
+	req := EventRequest{}
+	e := CalEvent{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, e, fmt.Errorf("Invalid event. %s", err.Error())
+	}
+	if req.Summary == "" {
+		return req, e, errors.New("Summary must be specified")
+	}
+	if req.Start == "" {
+		return req, e, errors.New("Start must be specified")
+	}
+
+	loc := c.Srv.Config.Location()
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return req, e, fmt.Errorf("Invalid time zone '%s'. %s", tz, err.Error())
+		}
+		loc = l
+	}
+	st, err := parseQueryTime(req.Start, loc)
+	if err != nil {
+		return req, e, fmt.Errorf("Invalid start '%s'. %s", req.Start, err.Error())
+	}
+	// A plain date is an all-day event
+	allDay := req.AllDay || len(req.Start) == len("2006-01-02")
+	if allDay {
+		st = time.Date(st.Year(), st.Month(), st.Day(), 0, 0, 0, 0, loc)
+	}
+	et := st.Add(time.Hour)
+	if allDay {
+		et = st.AddDate(0, 0, 1)
+	}
+	if req.End != "" {
+		if et, err = parseQueryTime(req.End, loc); err != nil {
+			return req, e, fmt.Errorf("Invalid end '%s'. %s", req.End, err.Error())
+		}
+		if allDay {
+			et = time.Date(et.Year(), et.Month(), et.Day(), 0, 0, 0, 0, loc)
+		}
+	}
+	if !et.After(st) {
+		return req, e, errors.New("The end must be after the start")
+	}
+
+	e = CalEvent{
+		Summary:     req.Summary,
+		Location:    req.Location,
+		Description: req.Description,
+		Start:       st,
+		End:         et,
+		AllDay:      allDay,
+	}
+	e.SetLocation(loc)
+	return req, e, nil
+}
+
+// writeEvent serializes the event and writes it to the http response.
+func (c *EventController) writeEvent(w http.ResponseWriter, status int, e CalEvent) {
+	if b, err := json.Marshal(e); err != nil {
+		m := fmt.Sprintf("Error serializing calendar event. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(status)
+		w.Write(b)
+	}
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *EventController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("EventController: [Inf] ", a[1:len(a)-1])
+}
+
+// LogError is used to log error messages for this controller.
+func (c *EventController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("EventController: [Err] ", a[1:len(a)-1])
+}
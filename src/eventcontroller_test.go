@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testWritableProvider is a calendar provider that keeps the events written to it in memory.
+type testWritableProvider struct {
+	testProvider
+}
+
+var (
+	testEventsMu sync.Mutex
+	testEvents   = map[string]CalEvent{}
+)
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name: "TestWritable",
+		New:  func() CalendarProvider { return new(testWritableProvider) },
+	})
+}
+
+func (p *testWritableProvider) ProviderName() string { return "TestWritable" }
+
+func (p *testWritableProvider) CreateEvent(ctx context.Context, e CalEvent) (CalEvent, error) {
+	testEventsMu.Lock()
+	defer testEventsMu.Unlock()
+	e.ID = p.CalConfig.ID
+	e.UID = "new@example.com"
+	testEvents[e.UID] = e
+	return e, nil
+}
+
+func (p *testWritableProvider) UpdateEvent(ctx context.Context, e CalEvent) (CalEvent, error) {
+	testEventsMu.Lock()
+	defer testEventsMu.Unlock()
+	if _, ok := testEvents[e.UID]; !ok {
+		return e, errors.New("Event not found")
+	}
+	e.ID = p.CalConfig.ID
+	testEvents[e.UID] = e
+	return e, nil
+}
+
+func (p *testWritableProvider) DeleteEvent(ctx context.Context, uid string) error {
+	testEventsMu.Lock()
+	defer testEventsMu.Unlock()
+	if _, ok := testEvents[uid]; !ok {
+		return errors.New("Event not found")
+	}
+	delete(testEvents, uid)
+	return nil
+}
+
+func TestCanChangeEvents(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testwrite", Name: "Kitchen", Provider: "TestWritable"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/calendar/event?tz=Africa/Johannesburg", strings.NewReader(`{"calendar":"testwrite","summary":"Dentist","start":"2018-03-01T15:00:00+02:00"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	e := CalEvent{}
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.UID != "new@example.com" || e.Time != "15:00" || e.Duration != "1h" {
+		t.Errorf("Wrong event returned. Got %v", e)
+	}
+
+	// A plain date creates an all-day event
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/calendar/event/testwrite/new@example.com", strings.NewReader(`{"summary":"Holiday","start":"2018-03-02"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	testEventsMu.Lock()
+	e = testEvents["new@example.com"]
+	testEventsMu.Unlock()
+	if e.Summary != "Holiday" || !e.AllDay || e.Duration != "All Day" {
+		t.Errorf("Event not updated. Got %v", e)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/calendar/event/testwrite/new@example.com", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	testEventsMu.Lock()
+	n := len(testEvents)
+	testEventsMu.Unlock()
+	if n != 0 {
+		t.Errorf("Event not deleted")
+	}
+}
+
+func TestInvalidEventChangesAreRejected(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testwrite", Name: "Kitchen", Provider: "TestWritable"}, CalConfig{ID: "testfeed", Name: "Holidays", Provider: "iCal"})
+
+	for _, body := range []string{
+		`{"calendar":"testfeed","summary":"Dentist","start":"2018-03-01T15:00:00Z"}`,
+		`{"calendar":"missing","summary":"Dentist","start":"2018-03-01T15:00:00Z"}`,
+		`{"calendar":"testwrite","start":"2018-03-01T15:00:00Z"}`,
+		`{"calendar":"testwrite","summary":"Dentist","start":"3pm"}`,
+		`{"calendar":"testwrite","summary":"Dentist","start":"2018-03-01T15:00:00Z","end":"2018-03-01T14:00:00Z"}`,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/calendar/event", strings.NewReader(body)))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Event not rejected. %s", body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/calendar/event/testfeed/holiday@example.com", nil))
+	if !strings.Contains(w.Body.String(), "read-only") {
+		t.Errorf("Delete from a read-only calendar not rejected. Got '%s'", w.Body.String())
+	}
+}
+
+func TestProviderCapabilitiesAreAdvertised(t *testing.T) {
+	for n, writable := range map[string]bool{"Google": true, "CalDAV": true, "iCal": false, "LocalFile": false, "Outlook": false} {
+		pi, err := GetProviderInfo(n)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if pi.Writable != writable {
+			t.Errorf("Wrong capability for %s. Expected %v, got %v", n, writable, pi.Writable)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "strings"
+
+// matches returns true if the event passes the search and exclusion rules of the query.  The words
+// and keywords are matched, ignoring case, against the summary, location and description of the event.
+func (q eventQuery) matches(e CalEvent) bool {
+	if len(q.Search) == 0 && len(q.Exclude) == 0 {
+		return true
+	}
+	t := strings.ToLower(e.Summary + "\n" + e.Location + "\n" + e.Description)
+	for _, w := range q.Search {
+		if !strings.Contains(t, w) {
+			return false
+		}
+	}
+	for _, k := range q.Exclude {
+		if strings.Contains(t, k) {
+			return false
+		}
+	}
+	return true
+}
+
+// limit returns the first events of the list, up to the limit of the query.
+func (q eventQuery) limit(el []CalEvent) []CalEvent {
+	if q.Limit > 0 && len(el) > q.Limit {
+		return el[:q.Limit]
+	}
+	return el
+}
+
+// splitQueryList returns the values of a query string parameter that can be repeated, or hold a comma separated list.
+func splitQueryList(vl []string) []string {
+	l := []string{}
+	for _, v := range vl {
+		for _, i := range strings.Split(v, ",") {
+			if i = strings.TrimSpace(i); i != "" {
+				l = append(l, i)
+			}
+		}
+	}
+	return l
+}
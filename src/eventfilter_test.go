@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEventQueryMatchesSearchAndExclusions(t *testing.T) {
+	e := CalEvent{Summary: "Team Meeting", Location: "Board Room", Description: "Quarterly review"}
+	for _, i := range []struct {
+		Query eventQuery
+		Exp   bool
+	}{
+		{eventQuery{}, true},
+		{eventQuery{Search: []string{"meeting"}}, true},
+		{eventQuery{Search: []string{"board", "quarterly"}}, true},
+		{eventQuery{Search: []string{"meeting", "lunch"}}, false},
+		{eventQuery{Exclude: []string{"review"}}, false},
+		{eventQuery{Search: []string{"team"}, Exclude: []string{"cancelled"}}, true},
+	} {
+		if m := i.Query.matches(e); m != i.Exp {
+			t.Errorf("Wrong match for search %v and exclusions %v. Expected %t, got %t", i.Query.Search, i.Query.Exclude, i.Exp, m)
+		}
+	}
+}
+
+func TestCanFilterEvents(t *testing.T) {
+	s, router := newTestServer(CalConfig{ID: "testfilter1", Name: "Home", Provider: "Test"}, CalConfig{ID: "testfilter2", Name: "Work", Provider: "Test"})
+	s.Config.Profiles = []Profile{{Name: "Kitchen", Calendars: []string{"Home"}}}
+
+	for _, i := range []struct {
+		Query string
+		Exp   int
+	}{
+		{"", 2},
+		{"q=live", 2},
+		{"q=dentist", 0},
+		{"exclude=LIVE", 0},
+		{"limit=1", 1},
+		{"calendar=Work", 1},
+		{"profile=kitchen", 1},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events?from=2018-03-01T00:00:00Z&to=2018-03-08T00:00:00Z&"+i.Query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status returned for '%s'. Expected %d, got %d. %s", i.Query, http.StatusOK, w.Code, w.Body.String())
+		}
+		el := []CalEvent{}
+		if err := json.Unmarshal(w.Body.Bytes(), &el); err != nil {
+			t.Fatal(err)
+		}
+		if len(el) != i.Exp {
+			t.Errorf("Wrong number of events returned for '%s'. Expected %d, got %d", i.Query, i.Exp, len(el))
+		}
+	}
+
+	for _, q := range []string{"profile=Garage", "profile=Kitchen&calendar=Work", "calendar=Holidays", "limit=0"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events?"+q, nil))
+		if w.Code == http.StatusOK {
+			t.Errorf("No error returned for '%s'", q)
+		}
+	}
+}
+
+func TestProfilesFollowRenamedAndRemovedCalendars(t *testing.T) {
+	if _, err := os.Stat("config.json"); err == nil {
+		t.Skip("config.json already exists")
+	}
+	defer os.Remove("config.json")
+	s, router := newTestServer(
+		CalConfig{ID: "testprofile1", Name: "Home", Provider: "Test"},
+		CalConfig{ID: "testprofile2", Name: "Work", Provider: "Test"},
+		CalConfig{ID: "testprofile3", Name: "Garage", Provider: "Test"})
+
+	post := func(path string, body string, form url.Values) {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if form != nil {
+			r = httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status returned for %s. Expected %d, got %d. %s", path, http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+	count := func(profile string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/events?from=2018-03-01T00:00:00Z&to=2018-03-08T00:00:00Z&profile="+profile, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status returned for profile %s. Expected %d, got %d. %s", profile, http.StatusOK, w.Code, w.Body.String())
+		}
+		el := []CalEvent{}
+		if err := json.Unmarshal(w.Body.Bytes(), &el); err != nil {
+			t.Fatal(err)
+		}
+		return len(el)
+	}
+
+	// The calendars of saved profiles are stored by identifier
+	post("/config/profile", `{"name":"Kitchen","calendars":["Home","Garage"]}`, nil)
+	if p, _ := s.Config.GetProfile("Kitchen"); strings.Join(p.Calendars, ",") != "testprofile1,testprofile3" {
+		t.Errorf("Profile calendars not saved by identifier. Got %v", p.Calendars)
+	}
+	// Profiles saved by older versions refer to calendars by name
+	s.Config.Profiles = append(s.Config.Profiles, Profile{Name: "Office", Calendars: []string{"Work"}})
+
+	post("/config/update", "", url.Values{"updID": {"testprofile1"}, "updName": {"House"}, "updColour": {"Red"}})
+	post("/config/update", "", url.Values{"updID": {"testprofile2"}, "updName": {"Desk"}, "updColour": {"Red"}})
+	if n := count("Kitchen"); n != 2 {
+		t.Errorf("Wrong number of events after renaming. Expected 2, got %d", n)
+	}
+	if n := count("Office"); n != 1 {
+		t.Errorf("Wrong number of events after renaming. Expected 1, got %d", n)
+	}
+
+	post("/config/remove/testprofile3", "", nil)
+	if n := count("Kitchen"); n != 1 {
+		t.Errorf("Wrong number of events after removing. Expected 1, got %d", n)
+	}
+	if p, _ := s.Config.GetProfile("Kitchen"); strings.Join(p.Calendars, ",") != "testprofile1" {
+		t.Errorf("Removed calendar not removed from profile. Got %v", p.Calendars)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventList holds a list of events, and the details used to write it in each of the output formats.
+type eventList struct {
+	Title    string         // Title of the list
+	Colour   string         // Colour of the calendar, if the list is for a single calendar
+	Link     string         // URL that the list was requested from
+	Location *time.Location // Time zone of the events
+	Events   []CalEvent     // Events in the list
+}
+
+// eventFormat is an output format for lists of events.
+type eventFormat struct {
+	Name        string                            // Name used to select the format with the format query string parameter
+	Aliases     []string                          // Other names accepted for the format
+	ContentType string                            // Content type of the output
+	Accept      []string                          // Media types of the Accept header that select the format
+	Write       func(l eventList) ([]byte, error) // Writes the list of events in the format
+}
+
+// eventFormats lists the output formats available for the calendar routes.
+var eventFormats = []eventFormat{
+	{Name: "json", ContentType: "application/json", Accept: []string{"application/json"}, Write: writeJSONEvents},
+	{Name: "csv", ContentType: "text/csv; charset=utf-8", Accept: []string{"text/csv"}, Write: writeCSVEvents},
+	{Name: "text", Aliases: []string{"txt"}, ContentType: "text/plain; charset=utf-8", Accept: []string{"text/plain"}, Write: writeTextEvents},
+	{Name: "jsonfeed", ContentType: "application/feed+json", Accept: []string{"application/feed+json"}, Write: writeJSONFeedEvents},
+	{Name: "atom", ContentType: "application/atom+xml; charset=utf-8", Accept: []string{"application/atom+xml"}, Write: writeAtomEvents},
+	{Name: "ical", Aliases: []string{"ics"}, ContentType: "text/calendar; charset=utf-8", Accept: []string{"text/calendar"}, Write: writeICalEvents},
+}
+
+// getEventFormat returns the output format requested by the format query string parameter or, if it is
+// not specified, by the Accept header.  The default format is used if neither selects a format.
+func getEventFormat(r *http.Request, def string) (eventFormat, error) {
+	if v := r.URL.Query().Get("format"); v != "" {
+		if f, ok := findEventFormat(v); ok {
+			return f, nil
+		}
+		return eventFormat{}, fmt.Errorf("Invalid format '%s'", v)
+	}
+	f, ok := findEventFormat(def)
+	if !ok {
+		return f, fmt.Errorf("Invalid format '%s'", def)
+	}
+	for _, t := range getAcceptedTypes(r.Header.Get("Accept")) {
+		if t == "*/*" {
+			// Anything is acceptable, so use the default
+			return f, nil
+		}
+		for _, af := range eventFormats {
+			if containsString(af.Accept, t) {
+				return af, nil
+			}
+		}
+	}
+	return f, nil
+}
+
+// findEventFormat returns the output format with the name or alias.
+func findEventFormat(n string) (eventFormat, bool) {
+	n = strings.ToLower(n)
+	for _, f := range eventFormats {
+		if f.Name == n || containsString(f.Aliases, n) {
+			return f, true
+		}
+	}
+	return eventFormat{}, false
+}
+
+// getAcceptedTypes returns the media types of an Accept header, ordered by preference.
+// Media types with a quality of zero are not acceptable and are left out.
+func getAcceptedTypes(h string) []string {
+	type accepted struct {
+		Type    string
+		Quality float64
+	}
+	l := []accepted{}
+	for _, v := range strings.Split(h, ",") {
+		t, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			l = append(l, accepted{Type: t, Quality: q})
+		}
+	}
+	sort.SliceStable(l, func(i, j int) bool { return l[i].Quality > l[j].Quality })
+	tl := []string{}
+	for _, a := range l {
+		tl = append(tl, a.Type)
+	}
+	return tl
+}
+
+// getRequestURL returns the absolute URL of the request.
+func getRequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// getEventKey returns a value that identifies the event, or the occurrence or day of the event, in a list.
+func getEventKey(e CalEvent) string {
+	k := getICalEventUID(e)
+	if e.Day != 0 {
+		k = fmt.Sprintf("%s-day%d", k, e.Day)
+	}
+	return k
+}
+
+// getEventDateName returns the display name of the date that the event starts on, e.g. Thursday 1 March 2018.
+func getEventDateName(e CalEvent) string {
+	return e.DayName + " " + e.Start.Format("2 January 2006")
+}
+
+// getEventTimeText returns the display value for the start time and duration of the event.
+func getEventTimeText(e CalEvent) string {
+	if e.AllDay {
+		if e.Days > 1 && e.Day == 0 {
+			return fmt.Sprintf("All day (%d days)", e.Days)
+		}
+		return "All day"
+	}
+	return fmt.Sprintf("%s (%s)", e.Time, e.Duration)
+}
+
+func writeJSONEvents(l eventList) ([]byte, error) {
+	return json.Marshal(l.Events)
+}
+
+func writeICalEvents(l eventList) ([]byte, error) {
+	return icalCalendar{Name: l.Title, Colour: l.Colour, Location: l.Location, Events: l.Events}.Format(), nil
+}
+
+func writeCSVEvents(l eventList) ([]byte, error) {
+	b := &bytes.Buffer{}
+	w := csv.NewWriter(b)
+	w.Write([]string{"calendar", "summary", "location", "description", "start", "end", "allDay", "dayName", "time", "duration", "days", "inProgress", "colour"})
+	for _, e := range l.Events {
+		w.Write([]string{
+			e.Name,
+			e.Summary,
+			e.Location,
+			e.Description,
+			e.Start.Format(time.RFC3339),
+			e.End.Format(time.RFC3339),
+			strconv.FormatBool(e.AllDay),
+			e.DayName,
+			e.Time,
+			e.Duration,
+			strconv.Itoa(e.Days),
+			strconv.FormatBool(e.InProgress),
+			e.Colour,
+		})
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// writeTextEvents writes a plain text agenda, with the events grouped by the day they start on.
+func writeTextEvents(l eventList) ([]byte, error) {
+	b := &bytes.Buffer{}
+	if len(l.Events) == 0 {
+		b.WriteString("No events\n")
+		return b.Bytes(), nil
+	}
+	day := ""
+	for _, e := range l.Events {
+		if d := getEventDateName(e); d != day {
+			if day != "" {
+				b.WriteString("\n")
+			}
+			day = d
+			b.WriteString(d + "\n")
+		}
+		ln := fmt.Sprintf("  %-18s %s", getEventTimeText(e), e.Summary)
+		if e.Location != "" {
+			ln += " @ " + e.Location
+		}
+		if e.Name != "" {
+			ln += " [" + e.Name + "]"
+		}
+		b.WriteString(strings.Replace(ln, "\n", " ", -1) + "\n")
+	}
+	return b.Bytes(), nil
+}
+
+// jsonFeed is a JSON Feed (https://jsonfeed.org) of events.
+type jsonFeed struct {
+	Version string         `json:"version"`
+	Title   string         `json:"title"`
+	FeedURL string         `json:"feed_url,omitempty"`
+	Items   []jsonFeedItem `json:"items"`
+}
+
+// jsonFeedItem is an event in a JSON Feed.  The details of the event are included in the _event extension.
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	Title         string   `json:"title"`
+	ContentText   string   `json:"content_text"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags,omitempty"`
+	Event         CalEvent `json:"_event"`
+}
+
+func writeJSONFeedEvents(l eventList) ([]byte, error) {
+	f := jsonFeed{
+		Version: "https://jsonfeed.org/version/1.1",
+		Title:   l.Title,
+		FeedURL: l.Link,
+		Items:   []jsonFeedItem{},
+	}
+	for _, e := range l.Events {
+		i := jsonFeedItem{
+			ID:            getEventKey(e),
+			Title:         e.Summary,
+			ContentText:   getEventContentText(e),
+			DatePublished: e.Start.Format(time.RFC3339),
+			Event:         e,
+		}
+		if e.Name != "" {
+			i.Tags = []string{e.Name}
+		}
+		f.Items = append(f.Items, i)
+	}
+	return json.Marshal(f)
+}
+
+// atomFeed is an Atom feed (RFC 4287) of events.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Link    *atomLink   `xml:"link,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is a link in an Atom feed.
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// atomEntry is an event in an Atom feed.
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Updated   string        `xml:"updated"`
+	Published string        `xml:"published"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Content   atomContent   `xml:"content"`
+}
+
+// atomCategory is the category of an entry in an Atom feed.
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// atomContent is the content of an entry in an Atom feed.
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+func writeAtomEvents(l eventList) ([]byte, error) {
+	id := l.Link
+	if id == "" {
+		id = "urn:calendar:" + url.PathEscape(l.Title)
+	}
+	f := atomFeed{
+		ID:      id,
+		Title:   l.Title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  l.Title,
+	}
+	if l.Link != "" {
+		f.Link = &atomLink{Rel: "self", Href: l.Link}
+	}
+	for _, e := range l.Events {
+		en := atomEntry{
+			ID:        id + "#" + url.PathEscape(getEventKey(e)),
+			Title:     e.Summary,
+			Updated:   e.Start.Format(time.RFC3339),
+			Published: e.Start.Format(time.RFC3339),
+			Content:   atomContent{Type: "text", Text: getEventContentText(e)},
+		}
+		if e.Name != "" {
+			en.Category = &atomCategory{Term: e.Name}
+		}
+		f.Entries = append(f.Entries, en)
+	}
+	b, err := xml.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// getEventContentText returns a plain text description of the event for feed readers.
+func getEventContentText(e CalEvent) string {
+	s := getEventDateName(e) + " " + getEventTimeText(e)
+	if e.Location != "" {
+		s += "\n" + e.Location
+	}
+	if e.Description != "" {
+		s += "\n\n" + e.Description
+	}
+	return s
+}
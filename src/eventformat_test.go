@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputFormatIsNegotiated(t *testing.T) {
+	for _, tc := range []struct {
+		URL    string
+		Accept string
+		Format string
+	}{
+		{URL: "/calendar/events", Format: "json"},
+		{URL: "/calendar/events?format=CSV", Format: "csv"},
+		{URL: "/calendar/events?format=txt", Format: "text"},
+		{URL: "/calendar/events", Accept: "text/html,application/xhtml+xml,*/*;q=0.8", Format: "json"},
+		{URL: "/calendar/events", Accept: "text/plain;q=0.5, application/atom+xml", Format: "atom"},
+		{URL: "/calendar/events", Accept: "application/feed+json;q=0, text/csv;q=0.1", Format: "csv"},
+		{URL: "/calendar/events?format=jsonfeed", Accept: "text/csv", Format: "jsonfeed"},
+	} {
+		r := httptest.NewRequest("GET", tc.URL, nil)
+		if tc.Accept != "" {
+			r.Header.Set("Accept", tc.Accept)
+		}
+		f, err := getEventFormat(r, "json")
+		if err != nil {
+			t.Errorf("%s: %s", tc.URL, err.Error())
+		} else if f.Name != tc.Format {
+			t.Errorf("Wrong format for %s, Accept '%s'. Expected %s, got %s", tc.URL, tc.Accept, tc.Format, f.Name)
+		}
+	}
+
+	if _, err := getEventFormat(httptest.NewRequest("GET", "/calendar/events?format=pdf", nil), "json"); err == nil {
+		t.Error("No error returned for an invalid format")
+	}
+}
+
+func TestCanWriteEventFormats(t *testing.T) {
+	st := time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)
+	l := eventList{Title: "Calendar", Link: "http://localhost:20513/calendar/events", Events: []CalEvent{
+		{ID: "cal1", Name: "Work", UID: "standup@example.com", Summary: "Standup, daily", Location: "Room 2", Start: st, End: st.Add(15 * time.Minute)},
+		{ID: "cal2", Name: "Home", UID: "holiday@example.com", Summary: "Holiday", Start: st.AddDate(0, 0, 1), End: st.AddDate(0, 0, 3), AllDay: true},
+	}}
+	for i := range l.Events {
+		l.Events[i].SetLocation(time.UTC)
+	}
+
+	b, err := writeCSVEvents(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `Work,"Standup, daily",Room 2,,2018-03-01T09:00:00Z,`) {
+		t.Errorf("Event not written to CSV. %s", b)
+	}
+
+	b, err = writeTextEvents(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "Thursday 1 March 2018\n" +
+		"  09:00 (15m)        Standup, daily @ Room 2 [Work]\n" +
+		"\n" +
+		"Friday 2 March 2018\n" +
+		"  All day (2 days)   Holiday [Home]\n"
+	if string(b) != exp {
+		t.Errorf("Wrong text agenda. Expected\n%s\ngot\n%s", exp, b)
+	}
+
+	b, err = writeJSONFeedEvents(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := jsonFeed{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 2 || f.Items[0].ID != "standup@example.com" || f.Items[0].Event.Summary != "Standup, daily" {
+		t.Errorf("Wrong JSON Feed items. %s", b)
+	}
+
+	b, err = writeAtomEvents(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := atomFeed{}
+	if err := xml.Unmarshal(b, &a); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Entries) != 2 || a.Entries[1].Title != "Holiday" || a.Entries[1].ID != "http://localhost:20513/calendar/events#holiday@example.com" {
+		t.Errorf("Wrong Atom entries. %s", b)
+	}
+}
+
+func TestCalendarRoutesUseOutputFormat(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testformat", Name: "Test", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/calendar/get/4", nil)
+	r.Header.Set("Accept", "text/csv")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("content-type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Wrong content type returned. Got '%s'", ct)
+	}
+	if n := strings.Count(w.Body.String(), "\n"); n != 2 {
+		t.Errorf("Wrong number of CSV lines returned. Expected %d, got %d", 2, n)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/feed.ics?format=json", nil))
+	if ct := w.Header().Get("content-type"); ct != "application/json" {
+		t.Errorf("Wrong content type returned. Got '%s'", ct)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// EventStore holds the events of each calendar in an embedded database on disk, and records the
+// events that were added, modified or cancelled each time a calendar is refreshed.
+type EventStore struct {
+	db *bolt.DB
+}
+
+// EventChange records an event that was added, modified or cancelled between two refreshes of a calendar.
+type EventChange struct {
+	Seq      uint64    `json:"seq"`                // Sequence number of the change, which increases with each change
+	Time     time.Time `json:"time"`               // Time the change was found
+	Calendar string    `json:"calendar"`           // Identifier of the calendar
+	Type     string    `json:"type"`               // Type of change, added, modified or cancelled
+	Event    CalEvent  `json:"event"`              // Event after the change, or the cancelled event
+	Previous *CalEvent `json:"previous,omitempty"` // Event before the change, for modified events
+}
+
+// The types of change recorded for an event.
+const (
+	ChangeAdded     = "added"
+	ChangeModified  = "modified"
+	ChangeCancelled = "cancelled"
+)
+
+// storedEvents holds the events last retrieved for a calendar.
+type storedEvents struct {
+	Created time.Time           `json:"created"` // Time the events were retrieved
+	Start   time.Time           `json:"start"`   // Start of the time window retrieved
+	End     time.Time           `json:"end"`     // End of the time window retrieved
+	Events  map[string]CalEvent `json:"events"`  // Events, by the key returned by getStoreKey
+}
+
+var (
+	eventsBucket    = []byte("events")
+	changesBucket   = []byte("changes")
+	remindersBucket = []byte("reminders")
+)
+
+// OpenEventStore opens the event store in the specified file, creating it if it does not exist.
+func OpenEventStore(path string) (*EventStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, n := range [][]byte{eventsBucket, changesBucket, remindersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &EventStore{db: db}, nil
+}
+
+// Close closes the event store.
+func (s *EventStore) Close() error {
+	return s.db.Close()
+}
+
+// Update replaces the events stored for the calendar with the retrieved events, and records the
+// changes from the events that were stored before.  Only the part of the time window that was
+// retrieved both times is compared, so events that enter or leave the window as it moves are not
+// recorded as changes.  Nothing is recorded the first time a calendar is stored.  Changes older
+// than the number of days specified are removed.
+func (s *EventStore) Update(id string, evts CalEvents, changeDays int) ([]EventChange, error) {
+	cl := []EventChange{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket(eventsBucket)
+		cb := tx.Bucket(changesBucket)
+
+		cur := storedEvents{Created: evts.Created, Start: evts.Start, End: evts.End, Events: map[string]CalEvent{}}
+		for _, e := range evts.Events {
+			cur.Events[getStoreKey(e)] = normaliseEvent(e)
+		}
+
+		if v := eb.Get([]byte(id)); v != nil {
+			prev := storedEvents{}
+			if err := json.Unmarshal(v, &prev); err != nil {
+				return err
+			}
+			cl = diffStoredEvents(prev, cur)
+		}
+
+		now := time.Now()
+		for i := range cl {
+			seq, err := cb.NextSequence()
+			if err != nil {
+				return err
+			}
+			cl[i].Seq = seq
+			cl[i].Time = now
+			cl[i].Calendar = id
+			b, err := json.Marshal(cl[i])
+			if err != nil {
+				return err
+			}
+			if err := cb.Put(getSeqKey(seq), b); err != nil {
+				return err
+			}
+		}
+		if err := removeChanges(cb, func(c EventChange) bool {
+			return c.Time.Before(now.AddDate(0, 0, -changeDays))
+		}, true); err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(cur)
+		if err != nil {
+			return err
+		}
+		return eb.Put([]byte(id), b)
+	})
+	return cl, err
+}
+
+// Get returns the events last stored for the calendar.
+func (s *EventStore) Get(id string) (CalEvents, bool, error) {
+	evts := CalEvents{}
+	ok := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(eventsBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		se := storedEvents{}
+		if err := json.Unmarshal(v, &se); err != nil {
+			return err
+		}
+		evts = NewCalEvents(se.Start, se.End)
+		evts.Created = se.Created
+		for _, e := range se.Events {
+			evts.Events = append(evts.Events, e)
+		}
+		evts.EventCount = len(evts.Events)
+		ok = true
+		return nil
+	})
+	return evts, ok, err
+}
+
+// Changes returns the changes found after the specified time, with a sequence number greater than
+// the specified sequence number, in the order they were found.
+func (s *EventStore) Changes(since time.Time, after uint64) ([]EventChange, error) {
+	cl := []EventChange{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(changesBucket).Cursor()
+		for k, v := c.Seek(getSeqKey(after + 1)); k != nil; k, v = c.Next() {
+			ch := EventChange{}
+			if err := json.Unmarshal(v, &ch); err != nil {
+				return err
+			}
+			if ch.Time.After(since) {
+				cl = append(cl, ch)
+			}
+		}
+		return nil
+	})
+	return cl, err
+}
+
+// Remove removes the events and changes stored for the calendar.
+func (s *EventStore) Remove(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(eventsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return removeChanges(tx.Bucket(changesBucket), func(c EventChange) bool {
+			return c.Calendar == id
+		}, false)
+	})
+}
+
+// IsReminderSent returns true if the reminder with the key has been sent.
+func (s *EventStore) IsReminderSent(key string) (bool, error) {
+	sent := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		sent = tx.Bucket(remindersBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return sent, err
+}
+
+// SetReminderSent records that the reminder with the key has been sent.  The record is kept until the
+// expiry time, after which the reminder can no longer be sent.
+func (s *EventStore) SetReminderSent(key string, expires time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		v, err := expires.MarshalText()
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(remindersBucket).Put([]byte(key), v)
+	})
+}
+
+// RemoveExpiredReminders removes the records of the sent reminders that expired before the specified time.
+func (s *EventStore) RemoveExpiredReminders(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(remindersBucket).Cursor()
+		for k, v := c.First(); k != nil; {
+			t := time.Time{}
+			if err := t.UnmarshalText(v); err == nil && !t.Before(now) {
+				k, v = c.Next()
+				continue
+			}
+			dk := append([]byte{}, k...)
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			k, v = c.Seek(dk)
+		}
+		return nil
+	})
+}
+
+// removeChanges removes the changes that match.  If ordered is set, the changes are assumed to
+// match up to the first change that does not, as for changes that are older than a time.
+func removeChanges(b *bolt.Bucket, match func(EventChange) bool, ordered bool) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; {
+		ch := EventChange{}
+		if err := json.Unmarshal(v, &ch); err != nil {
+			return err
+		}
+		if !match(ch) {
+			if ordered {
+				return nil
+			}
+			k, v = c.Next()
+			continue
+		}
+		// Deleting moves the cursor, so find the next change from the deleted key
+		dk := append([]byte{}, k...)
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		k, v = c.Seek(dk)
+	}
+	return nil
+}
+
+// diffStoredEvents returns the changes between the previous and current events of a calendar.
+func diffStoredEvents(prev storedEvents, cur storedEvents) []EventChange {
+	// Only compare the part of the time window that was retrieved both times
+	ts, te := prev.Start, prev.End
+	if cur.Start.After(ts) {
+		ts = cur.Start
+	}
+	if cur.End.Before(te) {
+		te = cur.End
+	}
+
+	cl := []EventChange{}
+	for k, e := range cur.Events {
+		p, ok := prev.Events[k]
+		switch {
+		case !ok && e.Overlaps(ts, te):
+			cl = append(cl, EventChange{Type: ChangeAdded, Event: e})
+		case ok && getEventHash(p) != getEventHash(e):
+			pe := p
+			cl = append(cl, EventChange{Type: ChangeModified, Event: e, Previous: &pe})
+		}
+	}
+	for k, p := range prev.Events {
+		if _, ok := cur.Events[k]; !ok && p.Overlaps(ts, te) {
+			cl = append(cl, EventChange{Type: ChangeCancelled, Event: p})
+		}
+	}
+	sort.Slice(cl, func(i, j int) bool {
+		return cl[j].Event.Start.After(cl[i].Event.Start)
+	})
+	return cl
+}
+
+// normaliseEvent returns the event without the values that depend on the time zone or the time
+// window it was retrieved for.  The times of events that are not all-day events are stored in UTC.
+func normaliseEvent(e CalEvent) CalEvent {
+	if !e.AllDay {
+		e.Start = e.Start.UTC()
+		e.End = e.End.UTC()
+	}
+	e.DayName = ""
+	e.Time = ""
+	e.Duration = ""
+	e.InProgress = false
+	e.Day = 0
+	return e
+}
+
+// getEventHash returns a hash of the details of the event that are compared to find modified events.
+func getEventHash(e CalEvent) string {
+	st, et := e.Start.UTC().Format(time.RFC3339), e.End.UTC().Format(time.RFC3339)
+	if e.AllDay {
+		st, et = e.Start.Format("2006-01-02"), e.End.Format("2006-01-02")
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%s|%s|%t|%s|%s|%s", st, et, e.AllDay, e.Summary, e.Location, e.Description))))
+}
+
+// getStoreKey returns the key that identifies the event, or the occurrence of a recurring event, in the store.
+func getStoreKey(e CalEvent) string {
+	return getICalEventUID(e)
+}
+
+// getSeqKey returns the key of a change with the sequence number, which sorts in sequence order.
+func getSeqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestEventStore(t *testing.T) (*EventStore, func()) {
+	dir, err := ioutil.TempDir("", "calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := OpenEventStore(filepath.Join(dir, "events.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return st, func() {
+		st.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEventStoreRecordsChanges(t *testing.T) {
+	st, done := newTestEventStore(t)
+	defer done()
+
+	d := func(day int, hour int) time.Time { return time.Date(2018, 3, day, hour, 0, 0, 0, time.UTC) }
+	evts := NewCalEvents(d(1, 0), d(8, 0))
+	evts.Events = []CalEvent{
+		{UID: "dentist", Summary: "Dentist", Start: d(2, 9), End: d(2, 10)},
+		{UID: "soccer", Summary: "Soccer", Start: d(3, 15), End: d(3, 16)},
+		{UID: "past", Summary: "Breakfast", Start: d(1, 8), End: d(1, 9)},
+	}
+	cl, err := st.Update("teststore", evts, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cl) != 0 {
+		t.Errorf("Changes recorded when the calendar was first stored. Got %d", len(cl))
+	}
+
+	// The window moves on a day, so the past event and the new event on the last day are not changes
+	evts = NewCalEvents(d(2, 0), d(9, 0))
+	evts.Events = []CalEvent{
+		{UID: "dentist", Summary: "Dentist", Start: d(2, 11), End: d(2, 12)},
+		{UID: "party", Summary: "Party", Start: d(4, 18), End: d(4, 22)},
+		{UID: "later", Summary: "Later", Start: d(8, 10), End: d(8, 11)},
+	}
+	if cl, err = st.Update("teststore", evts, 30); err != nil {
+		t.Fatal(err)
+	}
+	exp := []struct {
+		Type string
+		UID  string
+	}{
+		{ChangeModified, "dentist"},
+		{ChangeCancelled, "soccer"},
+		{ChangeAdded, "party"},
+	}
+	if len(cl) != len(exp) {
+		t.Fatalf("Wrong number of changes recorded. Expected %d, got %d. %v", len(exp), len(cl), cl)
+	}
+	for i, e := range exp {
+		if cl[i].Type != e.Type || cl[i].Event.UID != e.UID {
+			t.Errorf("Wrong change recorded. Expected %s %s, got %s %s", e.Type, e.UID, cl[i].Type, cl[i].Event.UID)
+		}
+	}
+	if p := cl[0].Previous; p == nil || !p.Start.Equal(d(2, 9)) {
+		t.Error("Previous event not recorded for a modified event")
+	}
+
+	if l, err := st.Changes(time.Time{}, cl[0].Seq); err != nil || len(l) != 2 {
+		t.Errorf("Wrong changes returned after sequence %d. %v %v", cl[0].Seq, l, err)
+	}
+	if evts, ok, err := st.Get("teststore"); err != nil || !ok || len(evts.Events) != 3 {
+		t.Errorf("Stored events not returned. %v %v", evts, err)
+	}
+
+	if err := st.Remove("teststore"); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := st.Changes(time.Time{}, 0); len(l) != 0 {
+		t.Errorf("Changes not removed with the calendar. Got %d", len(l))
+	}
+}
+
+func TestCanGetChanges(t *testing.T) {
+	s, router := newTestServer(CalConfig{ID: "testchanges1", Name: "Home", Provider: "Test"}, CalConfig{ID: "testchanges2", Name: "Work", Provider: "Test"})
+	st, done := newTestEventStore(t)
+	defer done()
+	s.Store = st
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	for _, id := range []string{"testchanges1", "testchanges2"} {
+		evts := NewCalEvents(now, now.AddDate(0, 0, 7))
+		st.Update(id, evts, 30)
+		evts.Events = []CalEvent{{ID: id, UID: id, Summary: "New " + id, Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)}}
+		st.Update(id, evts, 30)
+	}
+
+	for _, i := range []struct {
+		Query string
+		Exp   int
+	}{
+		{"", 2},
+		{"calendar=Work", 1},
+		{"type=cancelled", 0},
+		{"since=" + now.Add(time.Hour).Format(time.RFC3339), 0},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/changes?"+i.Query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status returned for '%s'. Expected %d, got %d. %s", i.Query, http.StatusOK, w.Code, w.Body.String())
+		}
+		cl := []EventChange{}
+		if err := json.Unmarshal(w.Body.Bytes(), &cl); err != nil {
+			t.Fatal(err)
+		}
+		if len(cl) != i.Exp {
+			t.Errorf("Wrong number of changes returned for '%s'. Expected %d, got %d", i.Query, i.Exp, len(cl))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/changes?type=moved", nil))
+	if w.Code == http.StatusOK {
+		t.Error("No error returned for an invalid change type")
+	}
+}
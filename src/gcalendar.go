@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha1"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
@@ -21,18 +21,49 @@ type GCalendar struct {
 	CalConfig CalConfig // Selected Calendar Configuration
 }
 
+// googleCredentialsFile is the name of the file holding the Google application credentials.
+var googleCredentialsFile = "credentials.json"
+
+// googleBasePath overrides the base URL of the Google Calendar API if it is set.
+var googleBasePath = ""
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name:  "Google",
+		Title: "Google Calendar",
+		NewFields: []ProviderField{
+			{Name: "addGoogleAccount", Label: "Google Account", Type: "account", Placeholder: "New Account", Config: "account", SetNew: setNewAccount},
+			{Name: "addGoogleCode", Label: "Authentication Code", Type: "text", Placeholder: "Paste Authentication Code Here", Config: "authCode", SetNew: setNewAuthCode},
+			{Name: "addGoogleCalendars", Label: "Calendars", Type: "calendars", Placeholder: "Load Calendars", Config: "calendarIds", SetNew: setNewCalendarIDs},
+		},
+		UpdateFields: []ProviderField{
+			{Name: "updGoogleCalendars", Label: "Calendar ID List (place each on a separate line)", Type: "textarea", Placeholder: "Calendar ID", Config: "calendarIds", SetUpdate: setCalendarIDs},
+		},
+		New: func() CalendarProvider { return new(GCalendar) },
+	})
+}
+
 // SetConfig sets the configuration for this calendar provider
 func (g *GCalendar) SetConfig(c CalConfig) {
 	g.CalConfig = c
 }
 
-// RemovedConfig is used to clean up after config has been removed
+// RemovedConfig is used to clean up after config has been removed.  The token of an account
+// is only removed once no calendars in the config.json file use the account.
 func (g *GCalendar) RemovedConfig(c CalConfig) error {
-	tokenFile := fmt.Sprintf("Token_%s.json", c.ID)
-	if _, err := os.Stat(tokenFile); err == nil {
-		return os.Remove(tokenFile)
+	if c.Account == "" {
+		return removeTokenFile(c.ID)
 	}
-	return nil
+	cfg := Config{}
+	if err := cfg.ReadFromFile("config.json"); err != nil {
+		return err
+	}
+	for _, i := range cfg.Calendars {
+		if i.ID != c.ID && i.Provider == c.Provider && i.Account == c.Account {
+			return nil
+		}
+	}
+	return removeTokenFile(getGoogleTokenID(c.Account))
 }
 
 // ProviderName returns the name of the provider
@@ -40,68 +71,223 @@ func (g *GCalendar) ProviderName() string {
 	return "Google"
 }
 
-// GetEvents returns the calendar events
-func (g *GCalendar) GetEvents(noDays int) (CalEvents, error) {
-	if noDays <= 0 {
-		noDays = 4
-	}
-	evts := CalEvents{
-		Created: time.Now(),
-		NoDays:  noDays,
-	}
-	lastFName := fmt.Sprintf("lastevents_%s.json", g.CalConfig.ID)
+// GetEvents returns the calendar events between the start and end times
+func (g *GCalendar) GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error) {
+	evts := NewCalEvents(start, end)
 
-	client, err := g.getClient()
+	client, err := g.getClient(ctx)
 	if err != nil {
-		evts.ReadFromFile(lastFName)
 		return evts, fmt.Errorf("Error getting client. %s", err.Error())
 	}
 
-	srv, err := calendar.New(client)
+	srv, err := g.getService(client)
 	if err != nil {
-		evts.ReadFromFile(lastFName)
 		return evts, fmt.Errorf("Error creating calendar. %s", err.Error())
 	}
 
-	timeMin := time.Now().Format(time.RFC3339)
-	timeMax := time.Now().Add(time.Duration(noDays*24) * time.Hour).Format(time.RFC3339)
-	events, err := srv.Events.List("primary").
-		ShowDeleted(false).SingleEvents(true).
-		TimeMin(timeMin).TimeMax(timeMax).
-		OrderBy("startTime").
-		Do()
-	if err != nil {
-		evts.ReadFromFile(lastFName)
-		return evts, fmt.Errorf("Error retrieving calendar events. %s", err.Error())
+	ids := g.getCalendarIDs()
+	timeMin := evts.Start.Format(time.RFC3339)
+	timeMax := evts.End.Format(time.RFC3339)
+	for _, id := range ids {
+		call := srv.Events.List(id).
+			ShowDeleted(false).SingleEvents(true).
+			TimeMin(timeMin).TimeMax(timeMax).
+			OrderBy("startTime")
+		err := call.Pages(ctx, func(events *calendar.Events) error {
+			g.appendEvents(&evts, events)
+			return nil
+		})
+		if err != nil {
+			return evts, fmt.Errorf("Error retrieving calendar events for %s. %s", id, err.Error())
+		}
 	}
+	evts.EventCount = len(evts.Events)
 
-	b, err := json.Marshal(events)
-	ioutil.WriteFile("events.json", b, 0666)
+	return evts, nil
+}
 
+// appendEvents appends the events of a Google calendar.  Events that are shared between the
+// calendars of the account are only added once.
+func (g *GCalendar) appendEvents(evts *CalEvents, events *calendar.Events) {
+	// All-day events are in the time zone of the calendar
+	loc := g.CalConfig.Location()
+	if events.TimeZone != "" {
+		if l, err := time.LoadLocation(events.TimeZone); err == nil {
+			loc = l
+		}
+	}
 	for _, item := range events.Items {
-		if st, err := g.getTime(item.Start.DateTime, item.Start.Date); err == nil {
-			if et, err := g.getTime(item.End.DateTime, item.End.Date); err == nil {
-				evts.Events = append(evts.Events, CalEvent{
-					ID:          g.CalConfig.ID,
-					Name:        g.CalConfig.Name,
-					Start:       st,
-					End:         et,
-					DayName:     st.Weekday().String(),
-					Time:        st.Format("15:04"),
-					Duration:    GetDurationString(st, et),
-					Summary:     item.Summary,
-					Description: item.Description,
-					Location:    item.Location,
-					Colour:      g.CalConfig.Colour,
-				})
+		e, err := g.getEvent(item, loc)
+		if err != nil {
+			continue
+		}
+		exists := false
+		for _, x := range evts.Events {
+			if x.UID == e.UID && x.RecurrenceID == e.RecurrenceID {
+				exists = true
+				break
 			}
 		}
+		if !exists {
+			e.InProgress = e.Start.Before(evts.Start)
+			evts.Events = append(evts.Events, e)
+		}
 	}
+}
 
-	// Save a copy of these events
-	evts.WriteToFile(lastFName)
+// getEvent converts a Google calendar event.
+func (g *GCalendar) getEvent(item *calendar.Event, loc *time.Location) (CalEvent, error) {
+	e := CalEvent{}
+	st, err := g.getTime(item.Start.DateTime, item.Start.Date, loc)
+	if err != nil {
+		return e, err
+	}
+	et, err := g.getTime(item.End.DateTime, item.End.Date, loc)
+	if err != nil {
+		return e, err
+	}
+	rid := ""
+	if item.OriginalStartTime != nil {
+		if ot, err := g.getTime(item.OriginalStartTime.DateTime, item.OriginalStartTime.Date, loc); err == nil {
+			rid = formatRecurrenceID(ot)
+		}
+	}
+	return CalEvent{
+		ID:           g.CalConfig.ID,
+		Name:         g.CalConfig.Name,
+		UID:          item.ICalUID,
+		RecurrenceID: rid,
+		Start:        st,
+		End:          et,
+		DayName:      st.Weekday().String(),
+		Time:         st.Format("15:04"),
+		Duration:     GetDurationString(st, et),
+		Summary:      item.Summary,
+		Description:  item.Description,
+		Location:     item.Location,
+		Colour:       g.CalConfig.Colour,
+		AllDay:       item.Start.Date != "",
+		Days:         GetDaySpan(st, et, item.Start.Date != ""),
+	}, nil
+}
 
-	return evts, nil
+// CreateEvent creates the event in the first calendar of the account
+func (g *GCalendar) CreateEvent(ctx context.Context, e CalEvent) (CalEvent, error) {
+	srv, err := g.getWriteService(ctx)
+	if err != nil {
+		return e, err
+	}
+	item := &calendar.Event{}
+	g.setEvent(item, e)
+	item, err = srv.Events.Insert(g.getCalendarIDs()[0], item).Context(ctx).Do()
+	if err != nil {
+		return e, fmt.Errorf("Error creating event. %s", err.Error())
+	}
+	return g.getEvent(item, g.CalConfig.Location())
+}
+
+// UpdateEvent replaces the details of the event with the same UID.  Recurring events cannot be
+// updated, as the UID does not identify which of the occurrences was changed.
+func (g *GCalendar) UpdateEvent(ctx context.Context, e CalEvent) (CalEvent, error) {
+	srv, err := g.getWriteService(ctx)
+	if err != nil {
+		return e, err
+	}
+	id, item, recurs, err := g.findEvent(ctx, srv, e.UID)
+	if err != nil {
+		return e, err
+	}
+	if recurs {
+		return e, errors.New("Recurring events cannot be updated")
+	}
+	g.setEvent(item, e)
+	item, err = srv.Events.Update(id, item.Id, item).Context(ctx).Do()
+	if err != nil {
+		return e, fmt.Errorf("Error updating event. %s", err.Error())
+	}
+	return g.getEvent(item, g.CalConfig.Location())
+}
+
+// DeleteEvent deletes the event with the UID.  All the occurrences of a recurring event are deleted.
+func (g *GCalendar) DeleteEvent(ctx context.Context, uid string) error {
+	srv, err := g.getWriteService(ctx)
+	if err != nil {
+		return err
+	}
+	id, item, _, err := g.findEvent(ctx, srv, uid)
+	if err != nil {
+		return err
+	}
+	if err := srv.Events.Delete(id, item.Id).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("Error deleting event. %s", err.Error())
+	}
+	return nil
+}
+
+// findEvent searches the calendars of the account for the event with the UID.  The recurring event
+// is returned rather than its modified occurrences, along with whether the event recurs.
+func (g *GCalendar) findEvent(ctx context.Context, srv *calendar.Service, uid string) (string, *calendar.Event, bool, error) {
+	for _, id := range g.getCalendarIDs() {
+		l, err := srv.Events.List(id).ICalUID(uid).ShowDeleted(false).Context(ctx).Do()
+		if err != nil {
+			return "", nil, false, fmt.Errorf("Error finding event. %s", err.Error())
+		}
+		if len(l.Items) == 0 {
+			continue
+		}
+		item, recurs := l.Items[0], false
+		for _, i := range l.Items {
+			if i.RecurringEventId == "" {
+				item = i
+			}
+			if len(i.Recurrence) != 0 || i.RecurringEventId != "" {
+				recurs = true
+			}
+		}
+		return id, item, recurs, nil
+	}
+	return "", nil, false, fmt.Errorf("Event %s not found", uid)
+}
+
+// setEvent copies the details of the event to the Google calendar event.  The time zone of an
+// existing event is kept, as recurring events require it to expand their recurrence rules.
+func (g *GCalendar) setEvent(item *calendar.Event, e CalEvent) {
+	tz := ""
+	if item.Start != nil {
+		tz = item.Start.TimeZone
+	}
+	item.Summary = e.Summary
+	item.Description = e.Description
+	item.Location = e.Location
+	if e.AllDay {
+		item.Start = &calendar.EventDateTime{Date: e.Start.Format("2006-01-02")}
+		item.End = &calendar.EventDateTime{Date: e.End.Format("2006-01-02")}
+	} else {
+		item.Start = &calendar.EventDateTime{DateTime: e.Start.Format(time.RFC3339), TimeZone: tz}
+		item.End = &calendar.EventDateTime{DateTime: e.End.Format(time.RFC3339), TimeZone: tz}
+	}
+}
+
+// getWriteService returns the calendar service used to change events.
+func (g *GCalendar) getWriteService(ctx context.Context) (*calendar.Service, error) {
+	client, err := g.getClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client. %s", err.Error())
+	}
+	srv, err := g.getService(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating calendar. %s", err.Error())
+	}
+	return srv, nil
+}
+
+// getCalendarIDs returns the calendars of the account that are shown.  New events are
+// created in the first calendar.
+func (g *GCalendar) getCalendarIDs() []string {
+	if len(g.CalConfig.CalendarIDs) == 0 {
+		return []string{"primary"}
+	}
+	return g.CalConfig.CalendarIDs
 }
 
 // GetAuthenticateURL returns the URL that will be used to choose the calendar and
@@ -116,6 +302,73 @@ func (g *GCalendar) GetAuthenticateURL() (string, error) {
 	return url, nil
 }
 
+// ListCalendars returns the calendars available to the Google account.  If an existing account
+// is not specified, the authentication code is exchanged for a token, which is saved for the account
+// so that it can be shared by all the calendars of the account.
+func (g *GCalendar) ListCalendars(c NewCalConfig) (ProviderAccount, error) {
+	acc := ProviderAccount{Account: c.Account}
+
+	config, err := g.getConfig()
+	if err != nil {
+		return acc, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var token *oauth2.Token
+	if c.Account != "" {
+		token, err = getTokenFromFile(getGoogleTokenID(c.Account))
+		if err != nil {
+			return acc, fmt.Errorf("Error reading token file for %s. %s", c.Account, err.Error())
+		}
+	} else {
+		if c.AuthCode == "" {
+			return acc, errors.New("Authentication code must be specified")
+		}
+		token, err = config.Exchange(ctx, strings.TrimSpace(c.AuthCode))
+		if err != nil {
+			return acc, fmt.Errorf("Unable to retrieve authentication token. %v", err)
+		}
+	}
+
+	srv, err := g.getService(config.Client(ctx, token))
+	if err != nil {
+		return acc, fmt.Errorf("Error creating calendar. %s", err.Error())
+	}
+	err = srv.CalendarList.List().Pages(ctx, func(l *calendar.CalendarList) error {
+		for _, i := range l.Items {
+			if i.Deleted {
+				continue
+			}
+			n := i.SummaryOverride
+			if n == "" {
+				n = i.Summary
+			}
+			acc.Calendars = append(acc.Calendars, ProviderCalendar{ID: i.Id, Name: n, Primary: i.Primary})
+			if i.Primary && acc.Account == "" {
+				// The primary calendar is identified by the email address of the account
+				acc.Account = i.Id
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return acc, fmt.Errorf("Error retrieving calendar list. %s", err.Error())
+	}
+	if acc.Account == "" {
+		return acc, errors.New("Primary calendar not found for the Google account")
+	}
+
+	if c.Account == "" {
+		// Save the token for the account
+		if err := saveTokenToFile(getGoogleTokenID(acc.Account), token); err != nil {
+			return acc, err
+		}
+	}
+	return acc, nil
+}
+
 // ValidateConfig validates the configuration change for the calendar
 // and returns the calendar configuation ready to save
 func (g *GCalendar) ValidateConfig(c CalConfig) (CalConfig, error) {
@@ -128,6 +381,9 @@ func (g *GCalendar) ValidateConfig(c CalConfig) (CalConfig, error) {
 	if c.Colour == "" {
 		return c, errors.New("Colour must be specified")
 	}
+	if c.Account != "" && len(c.CalendarIDs) == 0 {
+		return c, errors.New("At least one calendar must be specified")
+	}
 	return c, nil
 }
 
@@ -136,27 +392,39 @@ func (g *GCalendar) ValidateConfig(c CalConfig) (CalConfig, error) {
 func (g *GCalendar) ValidateNewConfig(c NewCalConfig) (CalConfig, error) {
 	cc := CalConfig{}
 
-	// Validate the token
-	config, err := g.getConfig()
-	if err != nil {
-		return cc, err
-	}
-
 	if c.Name == "" {
 		return cc, errors.New("Name must be specified")
 	}
-	if c.AuthCode == "" {
+	if c.Account == "" && c.AuthCode == "" {
 		return cc, errors.New("Authentication code must be specified")
 	}
 	if c.Colour == "" {
 		return cc, errors.New("Colour must be selected")
 	}
 
-	// Get the token
-	token, err := config.Exchange(oauth2.NoContext, c.AuthCode)
+	// Get the calendars of the account, authenticating if required
+	acc, err := g.ListCalendars(c)
 	if err != nil {
-		m := fmt.Sprintf("Unable to retrieve authentication token. %v", err)
-		return cc, errors.New(m)
+		return cc, err
+	}
+
+	// Check the chosen calendars, showing the primary calendar if none were chosen
+	ids := []string{}
+	for _, id := range c.CalendarIDs {
+		found := false
+		for _, i := range acc.Calendars {
+			if i.ID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return cc, fmt.Errorf("Calendar %s not found for %s", id, acc.Account)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		ids = append(ids, acc.Account)
 	}
 
 	// Generate a new ID
@@ -168,66 +436,68 @@ func (g *GCalendar) ValidateNewConfig(c NewCalConfig) (CalConfig, error) {
 	cc.Name = c.Name
 	cc.Provider = g.ProviderName()
 	cc.Colour = c.Colour
+	cc.Account = acc.Account
+	cc.CalendarIDs = ids
 
-	// Save the token
-	err = g.saveTokenToFile(cc.ID, token)
-	if err != nil {
-		return cc, err
-	}
 	return cc, nil
 }
 
-func (g *GCalendar) getTime(a string, b string) (time.Time, error) {
+func (g *GCalendar) getTime(a string, b string, loc *time.Location) (time.Time, error) {
 	if a == "" {
-		return time.Parse("2006-01-02", b)
+		return time.ParseInLocation("2006-01-02", b, loc)
+	}
+	t, err := time.Parse(time.RFC3339, a)
+	if err != nil {
+		return t, err
 	}
-	return time.Parse("2006-01-02T15:04:05-07:00", a)
+	return t.In(loc), nil
 }
 
 func (g *GCalendar) getConfig() (*oauth2.Config, error) {
 	// Read the credentials
-	b, err := ioutil.ReadFile("credentials.json")
+	b, err := ioutil.ReadFile(googleCredentialsFile)
 	if err != nil {
-		return nil, errors.New("Error reading credentials.json file. " + err.Error())
+		return nil, fmt.Errorf("Error reading %s file. %s", googleCredentialsFile, err.Error())
 	}
 
-	return google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	return google.ConfigFromJSON(b, calendar.CalendarEventsScope, calendar.CalendarReadonlyScope)
 }
 
-func (g *GCalendar) getClient() (*http.Client, error) {
+func (g *GCalendar) getService(client *http.Client) (*calendar.Service, error) {
+	srv, err := calendar.New(client)
+	if err != nil {
+		return nil, err
+	}
+	if googleBasePath != "" {
+		srv.BasePath = googleBasePath
+	}
+	return srv, nil
+}
+
+func (g *GCalendar) getClient(ctx context.Context) (*http.Client, error) {
 	config, err := g.getConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := g.getTokenFromFile(g.CalConfig.ID)
+	token, err := getTokenFromFile(g.getTokenID())
 	if err != nil {
 		return nil, fmt.Errorf("Error reading token file for %s. %s", g.CalConfig.Name, err.Error())
 	}
 
-	return config.Client(context.Background(), token), nil
+	return config.Client(ctx, token), nil
 }
 
-// Retrieves a token from a local file.
-func (g *GCalendar) getTokenFromFile(id string) (*oauth2.Token, error) {
-	tokenFile := fmt.Sprintf("Token_%s.json", id)
-	f, err := os.Open(tokenFile)
-	defer f.Close()
-	if err != nil {
-		return nil, err
+// getTokenID returns the identifier of the token file used by the calendar.  Calendars added
+// before accounts were supported have their own token.
+func (g *GCalendar) getTokenID() string {
+	if g.CalConfig.Account != "" {
+		return getGoogleTokenID(g.CalConfig.Account)
 	}
-	token := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(token)
-	return token, err
+	return g.CalConfig.ID
 }
 
-func (g *GCalendar) saveTokenToFile(id string, token *oauth2.Token) error {
-	tokenFile := fmt.Sprintf("Token_%s.json", id)
-	f, err := os.OpenFile(tokenFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	defer f.Close()
-	if err != nil {
-		return fmt.Errorf("Unable to cache oauth token: %v", err)
-	}
-	json.NewEncoder(f).Encode(token)
-	return nil
+// getGoogleTokenID returns the identifier of the token file shared by the calendars of a Google account.
+func getGoogleTokenID(account string) string {
+	return fmt.Sprintf("google-%x", sha1.Sum([]byte(strings.ToLower(account))))[:23]
 }
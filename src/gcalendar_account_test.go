@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestGoogleServer creates an in-process stand-in for the Google token and Calendar API endpoints.
+func newTestGoogleServer(t *testing.T) *httptest.Server {
+	event := func(uid, summary, start string) string {
+		return fmt.Sprintf(`{"iCalUID":"%s","summary":"%s","start":{"dateTime":"%s"},"end":{"dateTime":"%s"}}`, uid, summary, start, start[:11]+"23:00:00Z")
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			if r.Form.Get("code") != "good-code" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/calendar/v3/users/me/calendarList":
+			fmt.Fprint(w, `{"items":[
+				{"id":"family@group.calendar.google.com","summary":"Family"},
+				{"id":"me@example.com","summary":"me@example.com","summaryOverride":"Me","primary":true},
+				{"id":"en.sa#holiday@group.v.calendar.google.com","summary":"Holidays"}]}`)
+		case "/calendar/v3/calendars/me@example.com/events":
+			if r.URL.Query().Get("iCalUID") == "weekly@example.com" {
+				// A recurring event, with one modified occurrence
+				fmt.Fprint(w, `{"timeZone":"UTC","items":[
+					{"id":"weekly_20180308","recurringEventId":"weekly","iCalUID":"weekly@example.com","summary":"Moved","start":{"dateTime":"2018-03-08T10:00:00Z"},"end":{"dateTime":"2018-03-08T11:00:00Z"}},
+					{"id":"weekly","iCalUID":"weekly@example.com","summary":"Weekly","recurrence":["RRULE:FREQ=WEEKLY"],"start":{"dateTime":"2018-03-01T09:00:00Z"},"end":{"dateTime":"2018-03-01T10:00:00Z"}}]}`)
+				return
+			}
+			fmt.Fprintf(w, `{"timeZone":"UTC","items":[%s,%s]}`,
+				event("dentist@example.com", "Dentist", "2018-03-01T09:00:00Z"),
+				event("party@example.com", "Party", "2018-03-03T18:00:00Z"))
+		case "/calendar/v3/calendars/family@group.calendar.google.com/events":
+			// The party is shared between the calendars
+			fmt.Fprintf(w, `{"timeZone":"UTC","items":[%s,%s]}`,
+				event("party@example.com", "Party", "2018-03-03T18:00:00Z"),
+				event("soccer@example.com", "Soccer", "2018-03-02T15:00:00Z"))
+		default:
+			t.Errorf("Unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// setTestGoogleCredentials points the Google provider at the test server.
+func setTestGoogleCredentials(t *testing.T, srv *httptest.Server) func() {
+	dir, err := ioutil.TempDir("", "google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"installed": map[string]interface{}{
+			"client_id":     "client",
+			"client_secret": "secret",
+			"redirect_uris": []string{"urn:ietf:wg:oauth:2.0:oob"},
+			"auth_uri":      srv.URL + "/auth",
+			"token_uri":     srv.URL + "/token",
+		},
+	})
+	fn := filepath.Join(dir, "credentials.json")
+	ioutil.WriteFile(fn, b, 0600)
+	oldFile, oldPath := googleCredentialsFile, googleBasePath
+	googleCredentialsFile = fn
+	googleBasePath = srv.URL + "/calendar/v3/"
+	return func() {
+		googleCredentialsFile, googleBasePath = oldFile, oldPath
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCanListGoogleCalendars(t *testing.T) {
+	srv := newTestGoogleServer(t)
+	defer srv.Close()
+	defer setTestGoogleCredentials(t, srv)()
+
+	g := new(GCalendar)
+	if _, err := g.ListCalendars(NewCalConfig{AuthCode: "bad-code"}); err == nil {
+		t.Error("No error returned for an invalid authentication code")
+	}
+
+	acc, err := g.ListCalendars(NewCalConfig{AuthCode: "good-code"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTokenFile(getGoogleTokenID(acc.Account))
+	if acc.Account != "me@example.com" {
+		t.Errorf("Wrong account returned. Got '%s'", acc.Account)
+	}
+	if len(acc.Calendars) != 3 || acc.Calendars[1].Name != "Me" || !acc.Calendars[1].Primary {
+		t.Errorf("Wrong calendars returned. Got %v", acc.Calendars)
+	}
+
+	// The saved token is reused for the account
+	acc, err = g.ListCalendars(NewCalConfig{Account: "me@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acc.Calendars) != 3 {
+		t.Errorf("Wrong number of calendars returned. Expected %d, got %d", 3, len(acc.Calendars))
+	}
+}
+
+func TestCanGetEventsForGoogleAccountCalendars(t *testing.T) {
+	srv := newTestGoogleServer(t)
+	defer srv.Close()
+	defer setTestGoogleCredentials(t, srv)()
+
+	g := new(GCalendar)
+	cc, err := g.ValidateNewConfig(NewCalConfig{Name: "Test", Colour: "Red", AuthCode: "good-code", CalendarIDs: []string{"me@example.com", "family@group.calendar.google.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTokenFile(getGoogleTokenID(cc.Account))
+	if cc.Account != "me@example.com" || len(cc.CalendarIDs) != 2 {
+		t.Errorf("Wrong calendar configuration. Got %v", cc)
+	}
+
+	// A second calendar for the same account does not need to authenticate again
+	cc2, err := g.ValidateNewConfig(NewCalConfig{Name: "Test 2", Colour: "Blue", Account: "me@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cc2.CalendarIDs) != 1 || cc2.CalendarIDs[0] != "me@example.com" {
+		t.Errorf("Primary calendar not chosen by default. Got %v", cc2.CalendarIDs)
+	}
+	if _, err := g.ValidateNewConfig(NewCalConfig{Name: "Test 3", Colour: "Tan", Account: "me@example.com", CalendarIDs: []string{"other@example.com"}}); err == nil {
+		t.Error("No error returned for an unknown calendar")
+	}
+
+	g.SetConfig(cc)
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	l, err := g.GetEvents(context.Background(), start, start.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Events) != 3 {
+		t.Fatalf("Wrong number of events returned. Expected %d, got %d", 3, len(l.Events))
+	}
+
+	// The token is kept while another calendar uses the account
+	if _, err := os.Stat("config.json"); err == nil {
+		t.Skip("config.json already exists")
+	}
+	cfg := Config{Calendars: []CalConfig{cc2}}
+	cfg.WriteToFile("config.json")
+	defer os.Remove("config.json")
+	if err := g.RemovedConfig(cc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getTokenFromFile(getGoogleTokenID(cc.Account)); err != nil {
+		t.Errorf("Token removed while still in use. %s", err.Error())
+	}
+	cfg.Calendars = []CalConfig{}
+	cfg.WriteToFile("config.json")
+	if err := g.RemovedConfig(cc2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getTokenFromFile(getGoogleTokenID(cc.Account)); err == nil {
+		t.Error("Token not removed with the last calendar of the account")
+	}
+}
+
+func TestRecurringGoogleEventsCannotBeUpdated(t *testing.T) {
+	srv := newTestGoogleServer(t)
+	defer srv.Close()
+	defer setTestGoogleCredentials(t, srv)()
+
+	g := new(GCalendar)
+	cc, err := g.ValidateNewConfig(NewCalConfig{Name: "Test", Colour: "Red", AuthCode: "good-code", CalendarIDs: []string{"me@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTokenFile(getGoogleTokenID(cc.Account))
+	g.SetConfig(cc)
+
+	st := time.Date(2018, 3, 8, 9, 0, 0, 0, time.UTC)
+	_, err = g.UpdateEvent(context.Background(), CalEvent{UID: "weekly@example.com", Summary: "Weekly", Start: st, End: st.Add(time.Hour)})
+	if err == nil || err.Error() != "Recurring events cannot be updated" {
+		t.Errorf("Recurring event was not rejected. Got %v", err)
+	}
+}
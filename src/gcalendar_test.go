@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 )
 
 func TestCanGetGoogleEvents(t *testing.T) {
@@ -11,7 +13,7 @@ func TestCanGetGoogleEvents(t *testing.T) {
 		Name: "Test",
 	}
 	g := GCalendar{CalConfig: c}
-	l, err := g.GetEvents(14)
+	l, err := g.GetEvents(context.Background(), time.Now(), time.Now().AddDate(0, 0, 14))
 	if err != nil {
 		t.Error(err)
 	}
@@ -26,7 +28,7 @@ func TestCanGetGoogleEvents1(t *testing.T) {
 		Name: "Test",
 	}
 	g := GCalendar{CalConfig: c}
-	l, err := g.GetEvents(14)
+	l, err := g.GetEvents(context.Background(), time.Now(), time.Now().AddDate(0, 0, 14))
 	if err != nil {
 		t.Error(err)
 	}
@@ -41,7 +43,7 @@ func TestCanGetLastGoogleEvents(t *testing.T) {
 		Name: "Test",
 	}
 	g := GCalendar{CalConfig: c}
-	l, err := g.GetEvents(10)
+	l, err := g.GetEvents(context.Background(), time.Now(), time.Now().AddDate(0, 0, 10))
 	if err == nil {
 		t.Error(errors.New("No error returned"))
 	}
@@ -0,0 +1,249 @@
+// Package grid lays out calendar events in month and week grids, with a column for each day.
+//
+// Events that last for whole days, or for 24 hours or more, are laid out as spans across the
+// day columns of each week, in rows that do not overlap.  Other events are laid out as blocks
+// within each day that they fall on, with concurrent events placed side by side in columns.
+package grid
+
+import (
+	"sort"
+	"time"
+)
+
+// Event is an event that can be laid out in a grid.
+type Event interface {
+	// Span returns the start and end times of the event, and whether it lasts for whole days.
+	Span() (start time.Time, end time.Time, allDay bool)
+}
+
+// Month is a month laid out as a grid of whole weeks.
+type Month struct {
+	Year  int        `json:"year"`  // Year of the month
+	Month time.Month `json:"month"` // Month number, 1 to 12
+	Name  string     `json:"name"`  // Name of the month
+	Weeks []Week     `json:"weeks"` // Weeks that the month falls on, including days of the adjacent months
+}
+
+// Week is a range of days laid out side by side.
+type Week struct {
+	Start time.Time `json:"start"` // Start of the first day
+	End   time.Time `json:"end"`   // End of the last day
+	Days  []Day     `json:"days"`  // Days of the week
+	Spans []Span    `json:"spans"` // All-day and multi-day events
+	Rows  int       `json:"rows"`  // Number of rows used by the spans
+}
+
+// Day is a single day of a grid.
+type Day struct {
+	Date    time.Time `json:"date"`    // Start of the day
+	Name    string    `json:"name"`    // Name of the day
+	Number  int       `json:"number"`  // Day of the month
+	InRange bool      `json:"inRange"` // Indicates the day falls in the month, or range of days, being laid out
+	Today   bool      `json:"today"`   // Indicates the day is today
+	Blocks  []Block   `json:"blocks"`  // Events within the day, sorted by start time
+}
+
+// Span is an all-day or multi-day event laid out across the days of a week.
+type Span struct {
+	Event        Event `json:"event"`        // The event
+	Day          int   `json:"day"`          // Index of the first day of the week that the event falls on
+	Days         int   `json:"days"`         // Number of days of the week that the event falls on
+	Row          int   `json:"row"`          // Row of the span, starting at 0
+	StartsBefore bool  `json:"startsBefore"` // Indicates the event started before the week
+	EndsAfter    bool  `json:"endsAfter"`    // Indicates the event ends after the week
+}
+
+// Block is an event laid out within a day.
+type Block struct {
+	Event        Event `json:"event"`        // The event
+	StartMinute  int   `json:"startMinute"`  // Minutes from the start of the day to the start of the block
+	EndMinute    int   `json:"endMinute"`    // Minutes from the start of the day to the end of the block
+	Column       int   `json:"column"`       // Column of the block, starting at 0
+	Columns      int   `json:"columns"`      // Number of columns used by the events that overlap the block
+	StartsBefore bool  `json:"startsBefore"` // Indicates the event started before the day
+	EndsAfter    bool  `json:"endsAfter"`    // Indicates the event ends after the day
+}
+
+// Now returns the current time.  It is used to mark today in the grids.
+var Now = time.Now
+
+// NewMonth lays out the events for the month in the time zone, in weeks that start on the week start day.
+func NewMonth(year int, month time.Month, weekStart time.Weekday, loc *time.Location, events []Event) Month {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	m := Month{
+		Year:  first.Year(),
+		Month: first.Month(),
+		Name:  first.Month().String(),
+		Weeks: []Week{},
+	}
+	last := first.AddDate(0, 1, 0)
+	ws := StartOfWeek(first, weekStart)
+	for ws.Before(last) {
+		w := NewWeek(ws, 7, events)
+		for i := range w.Days {
+			w.Days[i].InRange = w.Days[i].Date.Month() == m.Month
+		}
+		m.Weeks = append(m.Weeks, w)
+		ws = ws.AddDate(0, 0, 7)
+	}
+	return m
+}
+
+// NewWeek lays out the events for the number of days from the start day.
+func NewWeek(start time.Time, noDays int, events []Event) Week {
+	start = startOfDay(start)
+	w := Week{
+		Start: start,
+		End:   start.AddDate(0, 0, noDays),
+		Days:  []Day{},
+		Spans: []Span{},
+	}
+	now := Now().In(start.Location())
+	for i := 0; i < noDays; i++ {
+		ds := start.AddDate(0, 0, i)
+		w.Days = append(w.Days, Day{
+			Date:    ds,
+			Name:    ds.Weekday().String(),
+			Number:  ds.Day(),
+			InRange: true,
+			Today:   ds.Year() == now.Year() && ds.YearDay() == now.YearDay(),
+			Blocks:  []Block{},
+		})
+	}
+
+	for _, e := range sortEvents(events) {
+		st, et, allDay := e.Span()
+		if !st.Before(w.End) || !et.After(w.Start) {
+			continue
+		}
+		if allDay || et.Sub(st) >= 24*time.Hour {
+			w.addSpan(e, st, et)
+		} else {
+			w.addBlocks(e, st, et)
+		}
+	}
+	for i := range w.Days {
+		setColumns(w.Days[i].Blocks)
+	}
+	return w
+}
+
+// StartOfWeek returns the start of the week that the time falls on, for weeks that start on the week start day.
+func StartOfWeek(t time.Time, weekStart time.Weekday) time.Time {
+	d := startOfDay(t)
+	return d.AddDate(0, 0, -((int(d.Weekday()) - int(weekStart) + 7) % 7))
+}
+
+// addSpan adds the event as a span, in the first row that is free for all the days it falls on.
+func (w *Week) addSpan(e Event, st time.Time, et time.Time) {
+	s := Span{Event: e, StartsBefore: st.Before(w.Start), EndsAfter: et.After(w.End)}
+	first, last := -1, -1
+	for i, d := range w.Days {
+		de := d.Date.AddDate(0, 0, 1)
+		if st.Before(de) && et.After(d.Date) {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	s.Day = first
+	s.Days = last - first + 1
+	for s.Row = 0; ; s.Row++ {
+		free := true
+		for _, o := range w.Spans {
+			if o.Row == s.Row && o.Day <= last && o.Day+o.Days-1 >= first {
+				free = false
+				break
+			}
+		}
+		if free {
+			break
+		}
+	}
+	if s.Row+1 > w.Rows {
+		w.Rows = s.Row + 1
+	}
+	w.Spans = append(w.Spans, s)
+}
+
+// addBlocks adds the event as a block to each of the days that it falls on.
+func (w *Week) addBlocks(e Event, st time.Time, et time.Time) {
+	for i, d := range w.Days {
+		de := d.Date.AddDate(0, 0, 1)
+		if !st.Before(de) || !et.After(d.Date) {
+			continue
+		}
+		b := Block{Event: e, StartsBefore: st.Before(d.Date), EndsAfter: et.After(de)}
+		bs, be := st, et
+		if b.StartsBefore {
+			bs = d.Date
+		}
+		if b.EndsAfter {
+			be = de
+		}
+		b.StartMinute = int(bs.Sub(d.Date).Minutes())
+		b.EndMinute = int(be.Sub(d.Date).Minutes())
+		w.Days[i].Blocks = append(w.Days[i].Blocks, b)
+	}
+}
+
+// setColumns places the blocks of a day side by side where they overlap.  Blocks that overlap, directly or
+// through other blocks, form a group, and each block is placed in the first column of its group that is free.
+func setColumns(l []Block) {
+	group := 0
+	colEnds := []int{}
+	groupEnd := -1
+	for i := range l {
+		b := &l[i]
+		if b.StartMinute >= groupEnd {
+			// Start a new group
+			setGroupColumns(l[group:i], len(colEnds))
+			group = i
+			colEnds = colEnds[:0]
+		}
+		b.Column = len(colEnds)
+		for c, end := range colEnds {
+			if end <= b.StartMinute {
+				b.Column = c
+				break
+			}
+		}
+		if b.Column == len(colEnds) {
+			colEnds = append(colEnds, b.EndMinute)
+		} else {
+			colEnds[b.Column] = b.EndMinute
+		}
+		if b.EndMinute > groupEnd {
+			groupEnd = b.EndMinute
+		}
+	}
+	setGroupColumns(l[group:], len(colEnds))
+}
+
+// setGroupColumns sets the number of columns used by a group of blocks.
+func setGroupColumns(l []Block, cols int) {
+	for i := range l {
+		l[i].Columns = cols
+	}
+}
+
+// sortEvents returns a copy of the events sorted by start time, with longer events first.
+func sortEvents(events []Event) []Event {
+	l := make([]Event, len(events))
+	copy(l, events)
+	sort.SliceStable(l, func(i, j int) bool {
+		si, ei, _ := l[i].Span()
+		sj, ej, _ := l[j].Span()
+		if !si.Equal(sj) {
+			return si.Before(sj)
+		}
+		return ei.After(ej)
+	})
+	return l
+}
+
+// startOfDay returns midnight at the start of the day that the time falls on.
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
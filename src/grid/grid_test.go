@@ -0,0 +1,112 @@
+package grid
+
+import (
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	Name   string
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+}
+
+func (e testEvent) Span() (time.Time, time.Time, bool) { return e.Start, e.End, e.AllDay }
+
+func at(day int, hour int, min int) time.Time {
+	return time.Date(2018, 3, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestMonthIsLaidOutInWeeks(t *testing.T) {
+	m := NewMonth(2018, time.March, time.Monday, time.UTC, []Event{
+		testEvent{Name: "Holiday", Start: at(29, 0, 0), End: at(33, 0, 0), AllDay: true},
+	})
+	// March 2018 starts on a Thursday and ends on a Saturday
+	if len(m.Weeks) != 5 {
+		t.Fatalf("Wrong number of weeks. Expected %d, got %d", 5, len(m.Weeks))
+	}
+	if d := m.Weeks[0].Days[0]; d.Number != 26 || d.InRange || d.Name != "Monday" {
+		t.Errorf("Wrong first day. Got %s %d, in range %t", d.Name, d.Number, d.InRange)
+	}
+	if d := m.Weeks[0].Days[3]; d.Number != 1 || !d.InRange {
+		t.Errorf("Wrong first day of the month. Got %d, in range %t", d.Number, d.InRange)
+	}
+
+	// The holiday runs from Thursday 29 March to Sunday 1 April, in the last week
+	w := m.Weeks[4]
+	if len(w.Spans) != 1 {
+		t.Fatalf("Wrong number of spans. Expected %d, got %d", 1, len(w.Spans))
+	}
+	if s := w.Spans[0]; s.Day != 3 || s.Days != 4 || s.StartsBefore || s.EndsAfter {
+		t.Errorf("Wrong span. Got day %d for %d days", s.Day, s.Days)
+	}
+}
+
+func TestSpansAreLaidOutInRows(t *testing.T) {
+	w := NewWeek(at(5, 10, 0), 7, []Event{
+		testEvent{Name: "Conference", Start: at(4, 0, 0), End: at(8, 0, 0), AllDay: true},
+		testEvent{Name: "Visit", Start: at(6, 0, 0), End: at(9, 0, 0), AllDay: true},
+		testEvent{Name: "Trip", Start: at(9, 12, 0), End: at(13, 12, 0)},
+	})
+	if !w.Start.Equal(at(5, 0, 0)) {
+		t.Errorf("Week does not start at midnight. Got %v", w.Start)
+	}
+	if len(w.Spans) != 3 || w.Rows != 2 {
+		t.Fatalf("Wrong spans. Expected %d in %d rows, got %d in %d rows", 3, 2, len(w.Spans), w.Rows)
+	}
+	for i, exp := range []Span{
+		{Day: 0, Days: 3, Row: 0, StartsBefore: true},
+		{Day: 1, Days: 3, Row: 1},
+		{Day: 4, Days: 3, Row: 0, EndsAfter: true},
+	} {
+		s := w.Spans[i]
+		if s.Day != exp.Day || s.Days != exp.Days || s.Row != exp.Row || s.StartsBefore != exp.StartsBefore || s.EndsAfter != exp.EndsAfter {
+			t.Errorf("Wrong span %d. Expected %+v, got %+v", i, exp, s)
+		}
+	}
+}
+
+func TestConcurrentEventsAreLaidOutInColumns(t *testing.T) {
+	w := NewWeek(at(5, 0, 0), 2, []Event{
+		testEvent{Name: "Standup", Start: at(5, 9, 0), End: at(5, 9, 15)},
+		testEvent{Name: "Workshop", Start: at(5, 9, 0), End: at(5, 12, 0)},
+		testEvent{Name: "Call", Start: at(5, 10, 0), End: at(5, 11, 0)},
+		testEvent{Name: "Lunch", Start: at(5, 13, 0), End: at(5, 14, 0)},
+		testEvent{Name: "Late Show", Start: at(5, 23, 0), End: at(6, 1, 30)},
+	})
+	bl := w.Days[0].Blocks
+	if len(bl) != 5 {
+		t.Fatalf("Wrong number of blocks. Expected %d, got %d", 5, len(bl))
+	}
+	for i, exp := range []Block{
+		{StartMinute: 540, EndMinute: 720, Column: 0, Columns: 2},
+		{StartMinute: 540, EndMinute: 555, Column: 1, Columns: 2},
+		{StartMinute: 600, EndMinute: 660, Column: 1, Columns: 2},
+		{StartMinute: 780, EndMinute: 840, Column: 0, Columns: 1},
+		{StartMinute: 1380, EndMinute: 1440, Column: 0, Columns: 1, EndsAfter: true},
+	} {
+		b := bl[i]
+		if b.StartMinute != exp.StartMinute || b.EndMinute != exp.EndMinute || b.Column != exp.Column || b.Columns != exp.Columns || b.EndsAfter != exp.EndsAfter {
+			t.Errorf("Wrong block %d (%s). Expected %+v, got %+v", i, b.Event.(testEvent).Name, exp, b)
+		}
+	}
+	if bl := w.Days[1].Blocks; len(bl) != 1 || !bl[0].StartsBefore || bl[0].EndMinute != 90 {
+		t.Errorf("Overnight event not continued on the next day. Got %+v", bl)
+	}
+}
+
+func TestTodayIsMarked(t *testing.T) {
+	Now = func() time.Time { return at(6, 15, 0) }
+	defer func() { Now = time.Now }()
+
+	w := NewWeek(StartOfWeek(at(6, 15, 0), time.Sunday), 7, nil)
+	if !w.Start.Equal(at(4, 0, 0)) {
+		t.Errorf("Wrong start of week. Got %v", w.Start)
+	}
+	for i, d := range w.Days {
+		if d.Today != (i == 2) {
+			t.Errorf("Wrong today flag for %s %d", d.Name, d.Number)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brumawen/calendar/src/grid"
+	"github.com/gorilla/mux"
+)
+
+// GridController handles the Web Methods for the month and week grid views.
+type GridController struct {
+	Srv *Server
+}
+
+// GridPageData holds the data used to write to the month and week pages.
+type GridPageData struct {
+	Title string     // Title of the page
+	Prev  string     // URL of the previous month or week
+	Next  string     // URL of the next month or week
+	Month grid.Month // Month laid out, for the month page
+	Week  grid.Week  // Week laid out, for the week page
+	Hours []int      // Hours shown on the week page
+}
+
+// gridHourHeight is the height, in pixels, of an hour on the week page.
+const gridHourHeight = 48
+
+// AddController adds the controller routes to the router
+func (c *GridController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/calendar/month").Name("GetMonth").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetMonth)))
+	router.Methods("GET").Path("/calendar/month/{year:[0-9]+}/{month:[0-9]+}").Name("GetMonthOf").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetMonth)))
+	router.Methods("GET").Path("/calendar/week").Name("GetWeek").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetWeek)))
+	router.Methods("GET").Path("/calendar/week/{date}").Name("GetWeekOf").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetWeek)))
+}
+
+func (c *GridController) handleGetMonth(w http.ResponseWriter, r *http.Request) {
+	cal := &CalendarController{Srv: c.Srv}
+	q, ws, err := c.getGridQuery(cal, r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	vars := mux.Vars(r)
+	now := time.Now().In(q.Location)
+	year, month := now.Year(), now.Month()
+	if vars["year"] != "" {
+		year, _ = strconv.Atoi(vars["year"])
+		m, _ := strconv.Atoi(vars["month"])
+		if m < 1 || m > 12 {
+			http.Error(w, fmt.Sprintf("Invalid month '%s'", vars["month"]), 500)
+			return
+		}
+		month = time.Month(m)
+	}
+
+	first := time.Date(year, month, 1, 0, 0, 0, 0, q.Location)
+	q.Start = grid.StartOfWeek(first, ws)
+	q.End = grid.StartOfWeek(first.AddDate(0, 1, 0).Add(-time.Second), ws).AddDate(0, 0, 7)
+	el, _ := cal.getEvents(r.Context(), q)
+
+	v := GridPageData{
+		Title: fmt.Sprintf("%s %d", month, year),
+		Month: grid.NewMonth(year, month, ws, q.Location, getGridEvents(el)),
+	}
+	pm, nm := first.AddDate(0, -1, 0), first.AddDate(0, 1, 0)
+	v.Prev = getGridURL(r, fmt.Sprintf("/calendar/month/%d/%d", pm.Year(), pm.Month()))
+	v.Next = getGridURL(r, fmt.Sprintf("/calendar/month/%d/%d", nm.Year(), nm.Month()))
+	c.writeGrid(w, r, "month.html", v, v.Month)
+}
+
+func (c *GridController) handleGetWeek(w http.ResponseWriter, r *http.Request) {
+	cal := &CalendarController{Srv: c.Srv}
+	q, ws, err := c.getGridQuery(cal, r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	day := time.Now().In(q.Location)
+	if d := mux.Vars(r)["date"]; d != "" {
+		if day, err = time.ParseInLocation("2006-01-02", d, q.Location); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid date '%s'", d), 500)
+			return
+		}
+	}
+	noDays := 7
+	if d := r.URL.Query().Get("days"); d != "" {
+		if noDays, err = strconv.Atoi(d); err != nil || noDays < 1 || noDays > 31 {
+			http.Error(w, fmt.Sprintf("Invalid number of days '%s'", d), 500)
+			return
+		}
+	}
+
+	q.Start = grid.StartOfWeek(day, ws)
+	if noDays != 7 {
+		// Other numbers of days start on the day itself, e.g. a 3 day timeline from today
+		q.Start = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, q.Location)
+	}
+	q.End = q.Start.AddDate(0, 0, noDays)
+	el, _ := cal.getEvents(r.Context(), q)
+
+	v := GridPageData{
+		Title: fmt.Sprintf("%s to %s", q.Start.Format("2 January 2006"), q.End.AddDate(0, 0, -1).Format("2 January 2006")),
+		Week:  grid.NewWeek(q.Start, noDays, getGridEvents(el)),
+	}
+	for h := 0; h < 24; h++ {
+		v.Hours = append(v.Hours, h)
+	}
+	v.Prev = getGridURL(r, "/calendar/week/"+q.Start.AddDate(0, 0, -noDays).Format("2006-01-02"))
+	v.Next = getGridURL(r, "/calendar/week/"+q.End.Format("2006-01-02"))
+	c.writeGrid(w, r, "week.html", v, v.Week)
+}
+
+// getGridQuery reads the options of a grid request from the query string, including the day that weeks start on.
+func (c *GridController) getGridQuery(cal *CalendarController, r *http.Request) (eventQuery, time.Weekday, error) {
+	q, err := cal.getEventQuery(r)
+	if err != nil {
+		return q, time.Monday, err
+	}
+	// Each event is laid out across the days it spans
+	q.SplitDays = false
+	ws := time.Monday
+	if v := r.URL.Query().Get("weekStart"); v != "" {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(v, d.String()) || strings.EqualFold(v, d.String()[:3]) {
+				ws = d
+				found = true
+			}
+		}
+		if !found {
+			return q, ws, fmt.Errorf("Invalid week start '%s'", v)
+		}
+	}
+	return q, ws, nil
+}
+
+// writeGrid writes the grid to the http response, as JSON, or as an HTML page if requested by the
+// format query string parameter or the Accept header.
+func (c *GridController) writeGrid(w http.ResponseWriter, r *http.Request, page string, v GridPageData, g interface{}) {
+	html := false
+	switch f := strings.ToLower(r.URL.Query().Get("format")); f {
+	case "":
+		if l := getAcceptedTypes(r.Header.Get("Accept")); len(l) != 0 && l[0] == "text/html" {
+			html = true
+		}
+	case "html":
+		html = true
+	case "json":
+	default:
+		http.Error(w, fmt.Sprintf("Invalid format '%s'", f), 500)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+
+	if html {
+		t := template.Must(template.New(page).Funcs(gridTemplateFuncs).ParseFiles("./html/" + page))
+		if err := t.Execute(w, v); err != nil {
+			http.Error(w, "Error getting web page. "+err.Error(), 500)
+		}
+		return
+	}
+	if b, err := json.Marshal(g); err != nil {
+		m := fmt.Sprintf("Error serializing calendar grid. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else {
+		w.Header().Set("content-type", "application/json")
+		w.Write(b)
+	}
+}
+
+// gridTemplateFuncs are the functions used by the month and week page templates.
+var gridTemplateFuncs = template.FuncMap{
+	"add": func(a int, b int) int { return a + b },
+	"sub": func(a int, b int) int { return a - b },
+	// px returns the position, in pixels, of a number of minutes on the week page
+	"px": func(min int) int { return min * gridHourHeight / 60 },
+	// pct returns the percentage of the width used by a column of a block
+	"pct": func(col int, cols int) float64 {
+		if cols == 0 {
+			return 0
+		}
+		return float64(col) * 100 / float64(cols)
+	},
+}
+
+// getGridEvents returns the events as grid events.
+func getGridEvents(el []CalEvent) []grid.Event {
+	l := []grid.Event{}
+	for _, e := range el {
+		l = append(l, e)
+	}
+	return l
+}
+
+// getGridURL returns the path with the query string of the request, for links to other grids.
+func getGridURL(r *http.Request, path string) string {
+	if r.URL.RawQuery == "" {
+		return path
+	}
+	return path + "?" + r.URL.RawQuery
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *GridController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("GridController: [Inf] ", a[1:len(a)-1])
+}
+
+// LogError is used to log error messages for this controller.
+func (c *GridController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("GridController: [Err] ", a[1:len(a)-1])
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanGetMonthGrid(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testmonth", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/month/2018/3?weekStart=sunday&tz=UTC", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	m := struct {
+		Year  int
+		Month int
+		Weeks []struct {
+			Days []struct {
+				Date   time.Time
+				Blocks []struct {
+					Event       CalEvent
+					StartMinute int
+				}
+			}
+		}
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Year != 2018 || m.Month != 3 || len(m.Weeks) != 5 {
+		t.Fatalf("Wrong month returned. Got %d/%d with %d weeks", m.Year, m.Month, len(m.Weeks))
+	}
+	// The grid starts on Sunday 25 February, and the test event starts an hour after the start of the window
+	d := m.Weeks[0].Days[0]
+	if !d.Date.Equal(time.Date(2018, 2, 25, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Wrong first day. Got %v", d.Date)
+	}
+	if len(d.Blocks) != 1 || d.Blocks[0].Event.Summary != "Live" || d.Blocks[0].StartMinute != 60 {
+		t.Errorf("Event not laid out on the first day. Got %+v", d.Blocks)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/month/2018/3?format=html", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<title>March 2018</title>") {
+		t.Errorf("Month page not returned. %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/month/2018/13", nil))
+	if w.Code == http.StatusOK {
+		t.Error("No error returned for an invalid month")
+	}
+}
+
+func TestCanGetWeekGridAsHTML(t *testing.T) {
+	_, router := newTestServer(CalConfig{ID: "testweek", Provider: "Test"})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/calendar/week/2018-03-07?tz=UTC", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	b := w.Body.String()
+	if !strings.Contains(b, "<title>5 March 2018 to 11 March 2018</title>") {
+		t.Errorf("Wrong week shown. %s", b)
+	}
+	if n := strings.Count(b, `class="grid-block"`); n != 1 {
+		t.Errorf("Wrong number of events shown. Expected %d, got %d", 1, n)
+	}
+	if !strings.Contains(b, `href="/calendar/week/2018-03-12?tz=UTC"`) {
+		t.Error("Link to the next week not found")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calendar/week/2018-03-07?days=3&tz=UTC", nil))
+	wk := struct {
+		Days []struct{ Number int }
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &wk); err != nil {
+		t.Fatal(err)
+	}
+	if len(wk.Days) != 3 || wk.Days[0].Number != 7 {
+		t.Errorf("Wrong days returned. Got %d days", len(wk.Days))
+	}
+}
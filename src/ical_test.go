@@ -1,25 +1,42 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/brumawen/ical"
 )
 
+// newTestICalServer starts a server that serves the iCal file from the testdata folder.
+func newTestICalServer(t *testing.T, name string) *httptest.Server {
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/calendar")
+		w.Write(b)
+	}))
+}
+
 func TestCanGetIcalFeed(t *testing.T) {
-	resp, err := http.Get("https://calendar.google.com/calendar/ical/en.sa%23holiday%40group.v.calendar.google.com/public/basic.ics")
+	srv := newTestICalServer(t, "holidays.ics")
+	defer srv.Close()
 
-	if resp != nil {
-		defer resp.Body.Close()
-	}
+	resp, err := http.Get(srv.URL)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	defer resp.Body.Close()
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		t.Error(err)
@@ -30,42 +47,186 @@ func TestCanGetIcalFeed(t *testing.T) {
 }
 
 func TestCanParseIcalFeed(t *testing.T) {
-	resp, err := http.Get("https://calendar.google.com/calendar/ical/en.sa%23holiday%40group.v.calendar.google.com/public/basic.ics")
-	if resp != nil {
-		defer resp.Body.Close()
-	}
+	f, err := os.Open("testdata/holidays.ics")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	c, err := ical.Parse(resp.Body, time.Local)
+	defer f.Close()
+	c, err := ical.Parse(f, time.Local)
 	if err != nil {
-		t.Error(err)
-	}
-	if len(c.Events) == 0 {
-		t.Error("No events parsed.")
+		t.Fatal(err)
 	}
-	for _, e := range c.Events {
-		fmt.Printf("%v, %v, %s\r\n", e.StartDate, e.EndDate, e.Summary)
+	if len(c.Events) != 5 {
+		t.Errorf("Wrong number of events parsed. Expected %d, got %d", 5, len(c.Events))
 	}
 }
 
 func TestCanGetICalEvents(t *testing.T) {
-	url := "https://calendar.google.com/calendar/ical/en.sa%23holiday%40group.v.calendar.google.com/public/basic.ics"
+	srv := newTestICalServer(t, "holidays.ics")
+	defer srv.Close()
+	srv2 := newTestICalServer(t, "vdir/work/standup.ics")
+	defer srv2.Close()
+	defer os.Remove(getICalFeedFileName(srv.URL))
+	defer os.Remove(getICalFeedFileName(srv2.URL))
+
 	c := CalConfig{
 		ID:   "testical",
 		Name: "Test iCal",
-		URL:  fmt.Sprintf("%s\n%s", url, url),
+		URL:  fmt.Sprintf("%s\n%s", srv.URL, srv2.URL),
 	}
-
 	p := ICalFeed{CalConfig: c}
-	l, err := p.GetEvents(365)
+	l, err := p.GetEvents(context.Background(), time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC))
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	if len(l.Events) == 0 {
-		t.Error(errors.New("No events returned"))
-	} else if len(l.Events) != 21 {
-		t.Errorf("Wrong number of events returned. Expected %d, got %d", 21, len(l.Events))
+		t.Fatal(errors.New("No events returned"))
+	}
+	// 4 holidays, and 4 occurrences of the weekly standup, fall in the window
+	if len(l.Events) != 8 {
+		t.Errorf("Wrong number of events returned. Expected %d, got %d", 8, len(l.Events))
+	}
+}
+
+func TestICalFeedsAreOnlyDownloadedWhenChanged(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/holidays.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = []byte(strings.Replace(string(b), "VERSION:2.0\n", "VERSION:2.0\nREFRESH-INTERVAL;VALUE=DURATION:P1W\nX-PUBLISHED-TTL:PT1H\n", 1))
+	full, notModified := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(b)
+	}))
+	defer srv.Close()
+	defer os.Remove(getICalFeedFileName(srv.URL))
+
+	p := ICalFeed{CalConfig: CalConfig{ID: "testicalcond", URL: srv.URL, TimeZone: "Africa/Johannesburg"}}
+	for i := 0; i < 2; i++ {
+		l, err := p.GetEvents(context.Background(), time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Events) == 0 {
+			t.Fatalf("No events returned for request %d", i+1)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Errorf("Feed was not requested conditionally. Got %d full and %d not modified responses", full, notModified)
+	}
+	if d := p.GetRefreshInterval(); d != 7*24*time.Hour {
+		t.Errorf("Wrong refresh interval returned. Expected %v, got %v", 7*24*time.Hour, d)
+	}
+
+	// The validators are kept after a restart
+	icalFeeds.mu.Lock()
+	delete(icalFeeds.feeds, srv.URL)
+	icalFeeds.mu.Unlock()
+	if _, err := p.GetEvents(context.Background(), time.Now(), time.Now().AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if full != 1 {
+		t.Error("Feed was downloaded again after a restart")
+	}
+}
+
+func TestICalRefreshIntervalIsRead(t *testing.T) {
+	for _, i := range []struct {
+		Feed string
+		Exp  time.Duration
+	}{
+		{"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n", 0},
+		{"BEGIN:VCALENDAR\r\nX-PUBLISHED-TTL:PT12H\r\nEND:VCALENDAR\r\n", 12 * time.Hour},
+		{"BEGIN:VCALENDAR\r\nX-PUBLISHED-TTL:PT12H\r\nREFRESH-INTERVAL;VALUE=DURATION:P1D\r\nEND:VCALENDAR\r\n", 24 * time.Hour},
+		{"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nREFRESH-INTERVAL;VALUE=DURATION:P1D\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", 0},
+	} {
+		if d := getICalRefreshInterval([]byte(i.Feed)); d != i.Exp {
+			t.Errorf("Wrong refresh interval returned for %q. Expected %v, got %v", i.Feed, i.Exp, d)
+		}
+	}
+}
+
+func TestICalFeedCacheFilesAreOnlyReadableByOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "icalfeed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An existing file written by an older version is restricted as well
+	fn := filepath.Join(dir, "icalfeed.json")
+	if err := ioutil.WriteFile(fn, []byte("{}"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(fn, 0666)
+	f := &icalFeed{URL: "http://example.com/calendar.ics", ETag: `"v1"`}
+	if err := f.WriteToFile(fn); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := fi.Mode().Perm(); m != 0600 {
+		t.Errorf("Feed cache file permissions are %o, expected 600", m)
+	}
+}
+
+func TestICalFeedFilesAreKeptWhileInUse(t *testing.T) {
+	if _, err := os.Stat("config.json"); err == nil {
+		t.Skip("config.json already exists")
+	}
+	defer os.Remove("config.json")
+	shared, removed, changed := "http://example.com/shared.ics", "http://example.com/removed.ics", "http://example.com/changed.ics"
+	for _, u := range []string{shared, removed, changed} {
+		if err := (&icalFeed{URL: u}).WriteToFile(getICalFeedFileName(u)); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(getICalFeedFileName(u))
+	}
+	exists := func(u string) bool {
+		_, err := os.Stat(getICalFeedFileName(u))
+		return err == nil
 	}
 
+	// The calendar is removed, and another calendar uses one of its feeds
+	other := CalConfig{ID: "testshared2", Provider: "iCal", URL: shared + "\n" + changed}
+	cfg := Config{Calendars: []CalConfig{other}}
+	if err := cfg.WriteToFile("config.json"); err != nil {
+		t.Fatal(err)
+	}
+	p := &ICalFeed{}
+	if err := p.RemovedConfig(CalConfig{ID: "testshared1", Provider: "iCal", URL: shared + "\r\n" + removed}); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(shared) {
+		t.Error("Feed used by another calendar removed")
+	}
+	if exists(removed) {
+		t.Error("Unused feed not removed")
+	}
+
+	// A feed is removed from the other calendar
+	c := other
+	c.URL = shared
+	cfg.Calendars = []CalConfig{c}
+	if err := cfg.WriteToFile("config.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdatedConfig(other, c); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(shared) {
+		t.Error("Feed still used by the calendar removed")
+	}
+	if exists(changed) {
+		t.Error("Feed removed from the calendar not removed")
+	}
 }
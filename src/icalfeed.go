@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"fmt"
-	"net/http"
 	"strings"
 	"time"
 
@@ -13,7 +13,22 @@ import (
 
 // ICalFeed is a calendar provider for a iCal web feed.
 type ICalFeed struct {
-	CalConfig CalConfig // Selected Calendar Configuration
+	CalConfig CalConfig     // Selected Calendar Configuration
+	refresh   time.Duration // Refresh interval specified by the feeds
+}
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name:  "iCal",
+		Title: "iCal Public Feed",
+		NewFields: []ProviderField{
+			{Name: "addiCalUrl", Label: "iCal Feed URL List (place each on a separate line)", Type: "textarea", Placeholder: "iCal Feed URL", Config: "url", SetNew: setNewURL},
+		},
+		UpdateFields: []ProviderField{
+			{Name: "updUrl", Label: "iCal Feed URL List (place each on a separate line)", Type: "textarea", Placeholder: "URL", Config: "url", SetUpdate: setURL},
+		},
+		New: func() CalendarProvider { return new(ICalFeed) },
+	})
 }
 
 // SetConfig sets the configuration for this calendar provider
@@ -21,9 +36,23 @@ func (p *ICalFeed) SetConfig(c CalConfig) {
 	p.CalConfig = c
 }
 
-// RemovedConfig is used to clean up after config has been removed
+// RemovedConfig is used to clean up after config has been removed.  The saved content of a feed
+// is only removed once no calendars in the config.json file use the feed.
 func (p *ICalFeed) RemovedConfig(c CalConfig) error {
-	return nil
+	return removeUnusedICalFeedFiles(c.ID, splitLines(c.URL))
+}
+
+// UpdatedConfig is used to clean up after config has been changed.  The saved content of the feeds
+// removed from the calendar is removed, unless other calendars in the config.json file use the feeds.
+func (p *ICalFeed) UpdatedConfig(old CalConfig, c CalConfig) error {
+	urls := splitLines(c.URL)
+	l := []string{}
+	for _, u := range splitLines(old.URL) {
+		if !containsString(urls, u) {
+			l = append(l, u)
+		}
+	}
+	return removeUnusedICalFeedFiles(c.ID, l)
 }
 
 // ProviderName returns the name of the provider
@@ -31,70 +60,94 @@ func (p *ICalFeed) ProviderName() string {
 	return "iCal"
 }
 
-// GetEvents returns the calendar events
-func (p *ICalFeed) GetEvents(noDays int) (CalEvents, error) {
-	evts := CalEvents{
-		Created: time.Now(),
-		NoDays:  noDays,
-	}
-	lastFName := fmt.Sprintf("lastevents_%s.json", p.CalConfig.ID)
+// GetEvents returns the calendar events between the start and end times.  Each feed is only downloaded
+// again if it has changed since it was last retrieved.
+func (p *ICalFeed) GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error) {
+	evts := NewCalEvents(start, end)
+	p.refresh = 0
 
 	// Split the URL by lines
 	urls := strings.Split(strings.Replace(p.CalConfig.URL, "\r", "", -1), "\n")
 
 	for _, u := range urls {
-		resp, err := http.Get(strings.TrimSpace(u))
-		if resp != nil {
-			defer resp.Body.Close()
-			resp.Close = true
-		}
+		f, err := icalFeeds.Get(ctx, strings.TrimSpace(u), p.CalConfig.Location())
 		if err != nil {
-			evts.ReadFromFile(lastFName)
-			return evts, fmt.Errorf("Error getting feed. %s", err.Error())
+			return evts, err
 		}
-		c, err := ical.Parse(resp.Body, time.Local)
-		if err != nil {
-			evts.ReadFromFile(lastFName)
-			return evts, fmt.Errorf("Error parsing feed. %s", err.Error())
+		appendICalCalendar(&evts, p.CalConfig, f.Calendar, f.Zones)
+		if f.Refresh > 0 && (p.refresh == 0 || f.Refresh < p.refresh) {
+			p.refresh = f.Refresh
 		}
-		ts := time.Now()
-		te := time.Now().Add(time.Duration(noDays*24) * time.Hour)
-		if len(c.Events) != 0 {
-			for _, e := range c.Events {
-				if e.StartDate.Equal(ts) || (e.StartDate.After(ts) && e.StartDate.Before(te)) {
-					// Check if we have already loaded the event
-					exists := false
-					for _, x := range evts.Events {
-						if x.UID == e.UID {
-							exists = true
-							break
-						}
-					}
-					if !exists {
-						// New event
-						evts.Events = append(evts.Events, CalEvent{
-							ID:          p.CalConfig.ID,
-							Name:        p.CalConfig.Name,
-							UID:         e.UID,
-							Start:       e.StartDate,
-							End:         e.EndDate,
-							DayName:     e.StartDate.Weekday().String(),
-							Time:        e.StartDate.Format("15:04"),
-							Duration:    GetDurationString(e.StartDate, e.EndDate),
-							Summary:     e.Summary,
-							Description: e.Description,
-							Colour:      p.CalConfig.Colour,
-						})
-					}
+	}
+	evts.EventCount = len(evts.Events)
+	return evts, nil
+}
+
+// GetRefreshInterval returns the shortest refresh interval specified by the feeds, or zero if
+// none of them specified one.
+func (p *ICalFeed) GetRefreshInterval() time.Duration {
+	return p.refresh
+}
+
+// appendICalEvents parses the iCal data and appends the occurrences of its events that overlap
+// the time window of the events.  Occurrences that have already been added are ignored.
+func appendICalEvents(evts *CalEvents, cc CalConfig, b []byte) error {
+	c, z, err := parseICalData(b, cc.Location())
+	if err != nil {
+		return err
+	}
+	appendICalCalendar(evts, cc, c, z)
+	return nil
+}
+
+// parseICalData parses the iCal data, and the time zones that it defines.
+func parseICalData(b []byte, def *time.Location) (*ical.Calendar, *icalTimeZones, error) {
+	z := newICalTimeZones(b, def)
+	c, err := ical.Parse(bytes.NewReader(b), z.Default)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, z, nil
+}
+
+// appendICalCalendar appends the occurrences of the events of the parsed calendar that overlap
+// the time window of the events.  Occurrences that have already been added are ignored.
+func appendICalCalendar(evts *CalEvents, cc CalConfig, c *ical.Calendar, z *icalTimeZones) {
+	ts := evts.Start
+	te := evts.End
+	for _, o := range expandICalEvents(c.Events, ts, te, z) {
+		if (CalEvent{Start: o.Start, End: o.End}).Overlaps(ts, te) {
+			// Check if we have already loaded the occurrence
+			exists := false
+			for _, x := range evts.Events {
+				if x.UID == o.Event.UID && x.RecurrenceID == o.RecurrenceID {
+					exists = true
+					break
 				}
 			}
+			if !exists {
+				// New event
+				evts.Events = append(evts.Events, CalEvent{
+					ID:           cc.ID,
+					Name:         cc.Name,
+					UID:          o.Event.UID,
+					RecurrenceID: o.RecurrenceID,
+					Start:        o.Start,
+					End:          o.End,
+					DayName:      o.Start.Weekday().String(),
+					Time:         o.Start.Format("15:04"),
+					Duration:     GetDurationString(o.Start, o.End),
+					Summary:      o.Event.Summary,
+					Location:     getICalText(o.Event, "LOCATION"),
+					Description:  o.Event.Description,
+					Colour:       cc.Colour,
+					InProgress:   o.Start.Before(ts),
+					AllDay:       o.AllDay,
+					Days:         GetDaySpan(o.Start, o.End, o.AllDay),
+				})
+			}
 		}
 	}
-	evts.EventCount = len(evts.Events)
-
-	// Save a copy of these events
-	evts.WriteToFile(lastFName)
-	return evts, nil
 }
 
 // ValidateConfig validates the configuration change for the calendar
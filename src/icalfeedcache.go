@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brumawen/ical"
+)
+
+// icalFeed holds the last retrieved content of an iCal web feed, with the validators used to
+// check whether it has changed, and the parsed calendar.
+type icalFeed struct {
+	URL          string `json:"url"`          // URL of the feed
+	ETag         string `json:"etag"`         // ETag header returned with the content
+	LastModified string `json:"lastModified"` // Last-Modified header returned with the content
+	Data         string `json:"data"`         // Content of the feed
+
+	Refresh  time.Duration  `json:"-"` // Refresh interval specified by the feed, if any
+	Calendar *ical.Calendar `json:"-"` // Parsed content of the feed
+	Zones    *icalTimeZones `json:"-"` // Time zones defined by the feed
+	location string         // Name of the default location the content was parsed with
+}
+
+// icalFeedCache holds the last retrieved content of each iCal web feed, so that feeds are only
+// downloaded and parsed again when they change.  The content is saved to the icalfeed_<hash>.json
+// files, so that conditional requests can also be made after a restart.
+type icalFeedCache struct {
+	mu    sync.Mutex
+	feeds map[string]*icalFeed
+}
+
+// icalFeeds is the cache used by the iCal feed provider.
+var icalFeeds = &icalFeedCache{feeds: map[string]*icalFeed{}}
+
+// Get returns the parsed feed at the URL.  If the feed has been retrieved before, the request is
+// made conditional on its ETag and Last-Modified validators, and the previously parsed calendar
+// is reused if the server reports that the feed has not changed.
+func (c *icalFeedCache) Get(ctx context.Context, u string, def *time.Location) (*icalFeed, error) {
+	c.mu.Lock()
+	f, ok := c.feeds[u]
+	c.mu.Unlock()
+	if !ok {
+		f = readICalFeedFile(u)
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating feed request. %s", err.Error())
+	}
+	if f != nil {
+		if f.ETag != "" {
+			req.Header.Set("If-None-Match", f.ETag)
+		}
+		if f.LastModified != "" {
+			req.Header.Set("If-Modified-Since", f.LastModified)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if resp != nil {
+		defer resp.Body.Close()
+		resp.Close = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error getting feed. %s", err.Error())
+	}
+
+	// Entries are replaced rather than changed, as they may be in use by other calendars
+	nf := &icalFeed{}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && f != nil:
+		*nf = *f
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading feed. %s", err.Error())
+		}
+		if f != nil && f.Data == string(b) {
+			// The server does not support conditional requests, but the feed has not changed
+			*nf = *f
+		} else {
+			nf.URL = u
+			nf.Data = string(b)
+		}
+		nf.ETag = resp.Header.Get("ETag")
+		nf.LastModified = resp.Header.Get("Last-Modified")
+	default:
+		return nil, fmt.Errorf("Error getting feed. %s", resp.Status)
+	}
+	changed := f == nil || nf.Data != f.Data || nf.ETag != f.ETag || nf.LastModified != f.LastModified
+
+	if nf.Calendar == nil || nf.location != def.String() {
+		b := []byte(nf.Data)
+		cal, z, err := parseICalData(b, def)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing feed. %s", err.Error())
+		}
+		nf.Calendar = cal
+		nf.Zones = z
+		nf.location = def.String()
+		nf.Refresh = getICalRefreshInterval(b)
+	}
+
+	c.mu.Lock()
+	c.feeds[u] = nf
+	c.mu.Unlock()
+	if changed {
+		nf.WriteToFile(getICalFeedFileName(u))
+	}
+	return nf, nil
+}
+
+// WriteToFile will write the feed content and validators to the specified file
+func (f *icalFeed) WriteToFile(path string) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	// Files written before by older versions keep their permissions, so restrict them as well
+	return os.Chmod(path, 0600)
+}
+
+// readICalFeedFile reads the saved content of the feed at the URL.  Nil is returned if the
+// feed has not been saved.
+func readICalFeedFile(u string) *icalFeed {
+	b, err := ioutil.ReadFile(getICalFeedFileName(u))
+	if err != nil {
+		return nil
+	}
+	f := &icalFeed{}
+	if err := json.Unmarshal(b, f); err != nil || f.URL != u {
+		return nil
+	}
+	return f
+}
+
+// removeICalFeedFile removes the saved content of the feed at the URL.
+func removeICalFeedFile(u string) {
+	icalFeeds.mu.Lock()
+	delete(icalFeeds.feeds, u)
+	icalFeeds.mu.Unlock()
+	os.Remove(getICalFeedFileName(u))
+}
+
+// removeUnusedICalFeedFiles removes the saved content of the feeds at the URLs that are not used by the
+// iCal calendars in the config.json file, other than the calendar with the identifier.
+func removeUnusedICalFeedFiles(id string, urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	cfg := Config{}
+	if err := cfg.ReadFromFile("config.json"); err != nil {
+		return err
+	}
+	for _, u := range urls {
+		used := false
+		for _, i := range cfg.Calendars {
+			if i.ID != id && i.Provider == "iCal" && containsString(splitLines(i.URL), u) {
+				used = true
+			}
+		}
+		if !used {
+			removeICalFeedFile(u)
+		}
+	}
+	return nil
+}
+
+// getICalFeedFileName returns the name of the file holding the saved content of the feed at the URL.
+func getICalFeedFileName(u string) string {
+	return fmt.Sprintf("icalfeed_%x.json", sha1.Sum([]byte(u)))
+}
+
+// getICalRefreshInterval returns the refresh interval specified by the REFRESH-INTERVAL property
+// of the feed, or by the older X-PUBLISHED-TTL property.  Zero is returned if neither is specified.
+func getICalRefreshInterval(b []byte) time.Duration {
+	ttl := time.Duration(0)
+	for _, ln := range unfoldICalLines(b) {
+		name, value := splitICalLine(ln)
+		switch {
+		case name == "BEGIN" && !strings.EqualFold(value, "VCALENDAR"):
+			// Only the properties of the calendar itself apply
+			return ttl
+		case name == "REFRESH-INTERVAL":
+			if d, err := parseICalDuration(value); err == nil && d > 0 {
+				return d
+			}
+		case name == "X-PUBLISHED-TTL":
+			if d, err := parseICalDuration(value); err == nil && d > 0 {
+				ttl = d
+			}
+		}
+	}
+	return ttl
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icalTimeZones resolves the TZID parameters used in an iCal feed to locations.
+type icalTimeZones struct {
+	Default *time.Location            // Location used for floating times
+	zones   map[string]*time.Location // Locations defined by the VTIMEZONE components of the feed
+}
+
+// windowsZones maps the common Windows time zone names, as used by Outlook and Exchange, to IANA names.
+var windowsZones = map[string]string{
+	"South Africa Standard Time":     "Africa/Johannesburg",
+	"GMT Standard Time":              "Europe/London",
+	"Greenwich Standard Time":        "Atlantic/Reykjavik",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Romance Standard Time":          "Europe/Paris",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"FLE Standard Time":              "Europe/Kiev",
+	"Russian Standard Time":          "Europe/Moscow",
+	"Arabian Standard Time":          "Asia/Dubai",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+	"New Zealand Standard Time":      "Pacific/Auckland",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"E. South America Standard Time": "America/Sao_Paulo",
+	"UTC":                            "UTC",
+}
+
+// newICalTimeZones reads the time zones defined in the iCal feed.  Floating times are in the
+// time zone specified by the X-WR-TIMEZONE property of the feed, or the default location.
+func newICalTimeZones(b []byte, def *time.Location) *icalTimeZones {
+	z := &icalTimeZones{
+		Default: def,
+		zones:   map[string]*time.Location{},
+	}
+
+	tzid := ""
+	lic := ""
+	offset := ""
+	comp := ""
+	inTZ := false
+	for _, ln := range unfoldICalLines(b) {
+		name, value := splitICalLine(ln)
+		switch {
+		case name == "X-WR-TIMEZONE" && !inTZ:
+			if l, err := time.LoadLocation(value); err == nil {
+				z.Default = l
+			}
+		case name == "BEGIN" && value == "VTIMEZONE":
+			inTZ = true
+			tzid, lic, offset = "", "", ""
+		case name == "END" && value == "VTIMEZONE":
+			inTZ = false
+			if tzid == "" {
+				continue
+			}
+			if l, err := time.LoadLocation(lic); lic != "" && err == nil {
+				z.zones[tzid] = l
+			} else if l := z.lookup(tzid); l != nil {
+				z.zones[tzid] = l
+			} else if secs, ok := parseICalOffset(offset); ok {
+				// Without a known location, use the standard offset of the zone
+				z.zones[tzid] = time.FixedZone(tzid, secs)
+			}
+		case !inTZ:
+			continue
+		case name == "BEGIN":
+			comp = value
+		case name == "END":
+			comp = ""
+		case name == "TZID" && comp == "":
+			tzid = value
+		case name == "X-LIC-LOCATION" && comp == "":
+			lic = value
+		case name == "TZOFFSETTO" && (comp == "STANDARD" || offset == ""):
+			offset = value
+		}
+	}
+	return z
+}
+
+// Location returns the location for the TZID.  If the TZID is blank or cannot be resolved,
+// the default location is returned.
+func (z *icalTimeZones) Location(tzid string) *time.Location {
+	if tzid == "" {
+		return z.Default
+	}
+	if l, ok := z.zones[tzid]; ok {
+		return l
+	}
+	if l := z.lookup(tzid); l != nil {
+		return l
+	}
+	return z.Default
+}
+
+// lookup resolves IANA and Windows time zone names.
+func (z *icalTimeZones) lookup(tzid string) *time.Location {
+	// Some producers prefix the IANA name with a path, e.g. /mozilla.org/20050126_1/Africa/Johannesburg
+	n := strings.Trim(tzid, "\"")
+	for n != "" {
+		if l, err := time.LoadLocation(n); err == nil {
+			return l
+		}
+		i := strings.Index(n, "/")
+		if i < 0 {
+			break
+		}
+		n = n[i+1:]
+	}
+	if w, ok := windowsZones[tzid]; ok {
+		if l, err := time.LoadLocation(w); err == nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// unfoldICalLines splits the iCal content into lines, joining folded lines.
+func unfoldICalLines(b []byte) []string {
+	l := []string{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 64*1024), len(b)+1)
+	for sc.Scan() {
+		ln := strings.TrimRight(sc.Text(), "\r")
+		if len(l) != 0 && (strings.HasPrefix(ln, " ") || strings.HasPrefix(ln, "\t")) {
+			l[len(l)-1] += ln[1:]
+			continue
+		}
+		l = append(l, ln)
+	}
+	return l
+}
+
+// splitICalLine returns the upper case name, without parameters, and the value of an iCal content line.
+func splitICalLine(ln string) (string, string) {
+	i := strings.Index(ln, ":")
+	if i < 0 {
+		return "", ""
+	}
+	name := ln[:i]
+	if j := strings.Index(name, ";"); j >= 0 {
+		name = name[:j]
+	}
+	return strings.ToUpper(strings.TrimSpace(name)), strings.TrimSpace(ln[i+1:])
+}
+
+// parseICalOffset parses a UTC offset (e.g. +0200, -053000) into seconds east of UTC.
+func parseICalOffset(v string) (int, bool) {
+	if len(v) != 5 && len(v) != 7 {
+		return 0, false
+	}
+	sign := 1
+	switch v[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, false
+	}
+	secs := 0
+	for i, m := range []int{3600, 60, 1} {
+		if 1+i*2 >= len(v) {
+			break
+		}
+		n, err := strconv.Atoi(v[1+i*2 : 3+i*2])
+		if err != nil {
+			return 0, false
+		}
+		secs += n * m
+	}
+	return sign * secs, true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brumawen/ical"
+)
+
+const testTimeZoneFeed = `BEGIN:VCALENDAR
+VERSION:2.0
+X-WR-TIMEZONE:Africa/Johannesburg
+BEGIN:VTIMEZONE
+TZID:South Africa Standard Time
+BEGIN:STANDARD
+DTSTART:16010101T000000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0200
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VTIMEZONE
+TZID:Custom Zone
+BEGIN:DAYLIGHT
+DTSTART:16010101T000000
+TZOFFSETFROM:-0500
+TZOFFSETTO:-0400
+END:DAYLIGHT
+BEGIN:STANDARD
+DTSTART:16010101T000000
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+END:STANDARD
+END:VTIMEZONE
+END:VCALENDAR
+`
+
+func TestCanResolveICalTimeZones(t *testing.T) {
+	z := newICalTimeZones([]byte(testTimeZoneFeed), time.UTC)
+	if z.Default.String() != "Africa/Johannesburg" {
+		t.Errorf("X-WR-TIMEZONE not used as the default. Got %s", z.Default)
+	}
+	for tzid, exp := range map[string]int{
+		"South Africa Standard Time": 2 * 3600,
+		"Custom Zone":                -5 * 3600,
+		"Europe/London":              0,
+		"/mozilla.org/20050126_1/Africa/Johannesburg": 2 * 3600,
+		"Unknown": 2 * 3600,
+	} {
+		_, off := time.Date(2018, 1, 15, 12, 0, 0, 0, z.Location(tzid)).Zone()
+		if off != exp {
+			t.Errorf("Wrong offset for %s. Expected %d, got %d", tzid, exp, off)
+		}
+	}
+}
+
+func TestEventTimesUseTZID(t *testing.T) {
+	z := newICalTimeZones([]byte(testTimeZoneFeed), time.UTC)
+	e := &ical.Event{
+		UID: "tz",
+		Properties: []*ical.Property{
+			{Name: "DTSTART", Params: map[string]*ical.Param{"TZID": {Values: []string{"South Africa Standard Time"}}}, Value: "20180301T090000"},
+			{Name: "DTEND", Params: map[string]*ical.Param{"TZID": {Values: []string{"South Africa Standard Time"}}}, Value: "20180301T100000"},
+		},
+	}
+	f := &ical.Event{
+		UID: "floating",
+		Properties: []*ical.Property{
+			{Name: "DTSTART", Value: "20180301T090000"},
+			{Name: "DURATION", Value: "PT30M"},
+		},
+	}
+	l := expandICalEvents([]*ical.Event{e, f}, time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), z)
+	if len(l) != 2 {
+		t.Fatalf("Wrong number of occurrences returned. Expected %d, got %d", 2, len(l))
+	}
+	for _, o := range l {
+		if exp := time.Date(2018, 3, 1, 7, 0, 0, 0, time.UTC); !o.Start.Equal(exp) {
+			t.Errorf("Wrong start for %s. Expected %v, got %v", o.Event.UID, exp, o.Start.UTC())
+		}
+	}
+	if d := l[1].End.Sub(l[1].Start); d != 30*time.Minute {
+		t.Errorf("Wrong duration for the floating event. Expected %v, got %v", 30*time.Minute, d)
+	}
+}
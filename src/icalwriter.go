@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icalProductID identifies the service in the iCal data that it writes.
+const icalProductID = "-//Brumawen//Calendar//EN"
+
+// icalCalendar holds the details of an iCal calendar written by the service.
+type icalCalendar struct {
+	Name     string         // Name of the calendar, blank to leave it out
+	Colour   string         // Display colour of the calendar, blank to leave it out
+	Location *time.Location // Time zone of the event times, nil to write them in UTC
+	Events   []CalEvent     // Events in the calendar
+}
+
+// formatICalCalendar returns the iCal data for a calendar holding the events, with their times in UTC.
+func formatICalCalendar(events []CalEvent) []byte {
+	return icalCalendar{Events: events}.Format()
+}
+
+// Format returns the iCal data of the calendar.  Occurrences of recurring events are written as
+// separate events, each with its own UID, so that clients do not depend on the rules
+// used to expand them.
+func (f icalCalendar) Format() []byte {
+	b := &bytes.Buffer{}
+	writeICalLine(b, "BEGIN:VCALENDAR")
+	writeICalLine(b, "VERSION:2.0")
+	writeICalLine(b, "PRODID:"+icalProductID)
+	writeICalLine(b, "CALSCALE:GREGORIAN")
+	if f.Name != "" {
+		writeICalLine(b, "METHOD:PUBLISH")
+		writeICalLine(b, "NAME:"+escapeICalText(f.Name))
+		writeICalLine(b, "X-WR-CALNAME:"+escapeICalText(f.Name))
+	}
+	if f.Colour != "" {
+		writeICalLine(b, "COLOR:"+strings.ToLower(f.Colour))
+		if h, ok := cssColours[strings.ToLower(f.Colour)]; ok {
+			writeICalLine(b, "X-APPLE-CALENDAR-COLOR:"+h)
+		}
+	}
+
+	tzid := getICalTZID(f.Location)
+	if tzid != "" {
+		writeICalLine(b, "X-WR-TIMEZONE:"+tzid)
+		start, end := getICalEventRange(f.Events)
+		writeICalTimeZone(b, f.Location, start, end)
+	}
+
+	uids := map[string]bool{}
+	for _, e := range f.Events {
+		uid := getICalEventUID(e)
+		if uids[uid] {
+			// The same event can be shown by more than one calendar
+			uid = uid + "-" + e.ID
+		}
+		uids[uid] = true
+		writeICalEvent(b, e, uid, tzid, f.Colour == "")
+	}
+	writeICalLine(b, "END:VCALENDAR")
+	return b.Bytes()
+}
+
+// writeICalEvent writes the VEVENT component for the event.  All-day events are written as dates,
+// and other events as local times in the TZID time zone, or as UTC if the TZID is blank.  If set,
+// the colour of the calendar is included, for feeds that merge more than one calendar.
+func writeICalEvent(b *bytes.Buffer, e CalEvent, uid string, tzid string, colour bool) {
+	writeICalLine(b, "BEGIN:VEVENT")
+	writeICalLine(b, "UID:"+escapeICalText(uid))
+	writeICalLine(b, "DTSTAMP:"+time.Now().UTC().Format("20060102T150405Z"))
+	switch {
+	case e.AllDay:
+		writeICalLine(b, "DTSTART;VALUE=DATE:"+e.Start.Format("20060102"))
+		writeICalLine(b, "DTEND;VALUE=DATE:"+e.End.Format("20060102"))
+	case tzid != "":
+		writeICalLine(b, "DTSTART;TZID="+tzid+":"+e.Start.Format("20060102T150405"))
+		writeICalLine(b, "DTEND;TZID="+tzid+":"+e.End.Format("20060102T150405"))
+	default:
+		writeICalLine(b, "DTSTART:"+e.Start.UTC().Format("20060102T150405Z"))
+		writeICalLine(b, "DTEND:"+e.End.UTC().Format("20060102T150405Z"))
+	}
+	writeICalLine(b, "SUMMARY:"+escapeICalText(e.Summary))
+	if e.Location != "" {
+		writeICalLine(b, "LOCATION:"+escapeICalText(e.Location))
+	}
+	if e.Description != "" {
+		writeICalLine(b, "DESCRIPTION:"+escapeICalText(e.Description))
+	}
+	if colour && e.Colour != "" {
+		writeICalLine(b, "COLOR:"+strings.ToLower(e.Colour))
+	}
+	if colour && e.Name != "" {
+		writeICalLine(b, "CATEGORIES:"+escapeICalText(e.Name))
+	}
+	writeICalLine(b, "END:VEVENT")
+}
+
+// writeICalTimeZone writes the VTIMEZONE component for the location, with a sub-component
+// for each change in its UTC offset between the start and end times.
+func writeICalTimeZone(b *bytes.Buffer, loc *time.Location, start time.Time, end time.Time) {
+	writeICalLine(b, "BEGIN:VTIMEZONE")
+	writeICalLine(b, "TZID:"+loc.String())
+	t := start.In(loc)
+	_, off := t.Zone()
+	writeICalTimeZoneRule(b, t, off, off)
+	for {
+		n, ok := getNextZoneChange(t, end)
+		if !ok {
+			break
+		}
+		_, noff := n.Zone()
+		writeICalTimeZoneRule(b, n, off, noff)
+		t, off = n, noff
+	}
+	writeICalLine(b, "END:VTIMEZONE")
+}
+
+// writeICalTimeZoneRule writes a STANDARD or DAYLIGHT sub-component for the offset that applies from the time.
+func writeICalTimeZoneRule(b *bytes.Buffer, t time.Time, from int, to int) {
+	comp := "STANDARD"
+	if t.IsDST() {
+		comp = "DAYLIGHT"
+	}
+	name, _ := t.Zone()
+	writeICalLine(b, "BEGIN:"+comp)
+	// The onset is specified in the local time before the change
+	writeICalLine(b, "DTSTART:"+t.UTC().Add(time.Duration(from)*time.Second).Format("20060102T150405"))
+	writeICalLine(b, "TZOFFSETFROM:"+formatICalOffset(from))
+	writeICalLine(b, "TZOFFSETTO:"+formatICalOffset(to))
+	writeICalLine(b, "TZNAME:"+escapeICalText(name))
+	writeICalLine(b, "END:"+comp)
+}
+
+// getNextZoneChange returns the first time after t, and before the end time, at which the UTC offset changes.
+func getNextZoneChange(t time.Time, end time.Time) (time.Time, bool) {
+	_, off := t.Zone()
+	for d := t.Add(24 * time.Hour); !d.After(end.Add(24 * time.Hour)); d = d.Add(24 * time.Hour) {
+		if _, o := d.Zone(); o != off {
+			// Find the second at which the offset changed
+			lo, hi := d.Add(-24*time.Hour), d
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+				if _, o := mid.Zone(); o == off {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			return hi, hi.Before(end)
+		}
+	}
+	return time.Time{}, false
+}
+
+// getICalEventRange returns the time range covered by the events, extended by a year on either side.
+func getICalEventRange(events []CalEvent) (time.Time, time.Time) {
+	start := time.Now()
+	end := start
+	for _, e := range events {
+		if e.Start.Before(start) {
+			start = e.Start
+		}
+		if e.End.After(end) {
+			end = e.End
+		}
+	}
+	return start.AddDate(-1, 0, 0), end.AddDate(1, 0, 0)
+}
+
+// getICalTZID returns the TZID used for the location, or blank if times are written in UTC.
+// The local time zone of the machine has no name that other clients can resolve.
+func getICalTZID(loc *time.Location) string {
+	if loc == nil || loc == time.UTC || loc == time.Local {
+		return ""
+	}
+	if n := loc.String(); n != "UTC" && n != "Local" && n != "" {
+		return n
+	}
+	return ""
+}
+
+// getICalEventUID returns a UID that is unique to the event, or to the occurrence of a recurring event.
+// Events without a UID are given one based on their details.
+func getICalEventUID(e CalEvent) string {
+	uid := e.UID
+	if uid == "" {
+		uid = fmt.Sprintf("%x@calendar", sha1.Sum([]byte(e.ID+"|"+e.Start.UTC().String()+"|"+e.Summary)))
+	}
+	if e.RecurrenceID != "" {
+		uid = uid + "-" + e.RecurrenceID
+	}
+	return uid
+}
+
+// formatICalOffset formats a UTC offset in seconds (e.g. +0200, -053000).
+func formatICalOffset(secs int) string {
+	sign := "+"
+	if secs < 0 {
+		sign = "-"
+		secs = -secs
+	}
+	v := fmt.Sprintf("%s%02d%02d", sign, secs/3600, secs%3600/60)
+	if s := secs % 60; s != 0 {
+		v += fmt.Sprintf("%02d", s)
+	}
+	return v
+}
+
+// writeICalLine writes a content line, folding it so that no line is longer than 75 octets.
+func writeICalLine(b *bytes.Buffer, ln string) {
+	max := 75
+	for len(ln) > max {
+		// Do not split multi-byte characters
+		i := max
+		for i > 0 && !utf8.RuneStart(ln[i]) {
+			i--
+		}
+		b.WriteString(ln[:i])
+		b.WriteString("\r\n ")
+		ln = ln[i:]
+		// Continuation lines start with a space
+		max = 74
+	}
+	b.WriteString(ln)
+	b.WriteString("\r\n")
+}
+
+var icalTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escapeICalText escapes the special characters of an iCal text value.
+func escapeICalText(v string) string {
+	return icalTextEscaper.Replace(v)
+}
+
+// cssColours maps the calendar colours offered on the configuration page to their hex values,
+// for clients that do not support colour names.
+var cssColours = map[string]string{
+	"red":       "#FF0000",
+	"orange":    "#FFA500",
+	"yellow":    "#FFFF00",
+	"tan":       "#D2B48C",
+	"chocolate": "#D2691E",
+	"lime":      "#00FF00",
+	"skyblue":   "#87CEEB",
+	"violet":    "#EE82EE",
+	"lightpink": "#FFB6C1",
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanFormatICalEvents(t *testing.T) {
+	st := time.Date(2018, 3, 1, 15, 0, 0, 0, time.FixedZone("SAST", 2*60*60))
+	b := string(formatICalCalendar([]CalEvent{
+		{UID: "dentist@example.com", Summary: "Dentist; Dr Smith, room 2", Start: st, End: st.Add(time.Hour), Description: strings.Repeat("Remember the forms. ", 10)},
+		{UID: "holiday@example.com", Summary: "Holiday", Start: time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), End: time.Date(2018, 3, 3, 0, 0, 0, 0, time.UTC), AllDay: true},
+	}))
+	for _, v := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"DTSTART:20180301T130000Z\r\n",
+		`SUMMARY:Dentist\; Dr Smith\, room 2` + "\r\n",
+		"DTSTART;VALUE=DATE:20180302\r\n",
+		"DTEND;VALUE=DATE:20180303\r\n",
+	} {
+		if !strings.Contains(b, v) {
+			t.Errorf("'%s' not found in %s", strings.TrimSpace(v), b)
+		}
+	}
+	for _, ln := range strings.Split(b, "\r\n") {
+		if len(ln) > 75 {
+			t.Errorf("Line not folded. %s", ln)
+		}
+	}
+
+	// The folded lines can be read back
+	for _, ln := range unfoldICalLines([]byte(b)) {
+		if n, v := splitICalLine(ln); n == "DESCRIPTION" && v != strings.TrimSpace(strings.Repeat("Remember the forms. ", 10)) {
+			t.Errorf("Wrong description read back. Got '%s'", v)
+		}
+	}
+}
+
+func TestICalCalendarIncludesTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+	st := time.Date(2018, 3, 1, 9, 0, 0, 0, loc)
+	b := string(icalCalendar{Name: "Family", Colour: "SkyBlue", Location: loc, Events: []CalEvent{
+		{ID: "cal1", UID: "standup@example.com", RecurrenceID: "20180301T090000Z", Summary: "Standup", Start: st, End: st.Add(15 * time.Minute)},
+		{ID: "cal2", UID: "standup@example.com", RecurrenceID: "20180301T090000Z", Summary: "Standup", Start: st, End: st.Add(15 * time.Minute)},
+	}}.Format())
+	for _, v := range []string{
+		"X-WR-CALNAME:Family\r\n",
+		"COLOR:skyblue\r\n",
+		"X-APPLE-CALENDAR-COLOR:#87CEEB\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/London\r\n",
+		// British Summer Time started on 25 March 2018
+		"BEGIN:DAYLIGHT\r\nDTSTART:20180325T010000\r\nTZOFFSETFROM:+0000\r\nTZOFFSETTO:+0100\r\nTZNAME:BST\r\n",
+		"DTSTART;TZID=Europe/London:20180301T090000\r\n",
+		"UID:standup@example.com-20180301T090000Z\r\n",
+		"UID:standup@example.com-20180301T090000Z-cal2\r\n",
+	} {
+		if !strings.Contains(b, v) {
+			t.Errorf("'%s' not found in %s", strings.TrimSpace(v), b)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// LocalFile is a calendar provider for iCal files on the local file system.  Each path can be a
+// single .ics file or a folder, such as a vdirsyncer or khal store, that is searched for .ics files.
+type LocalFile struct {
+	CalConfig CalConfig // Selected Calendar Configuration
+}
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name:  "LocalFile",
+		Title: "Local iCal File or Folder",
+		NewFields: []ProviderField{
+			{Name: "addLocalFilePath", Label: "File or Folder List (place each on a separate line)", Type: "textarea", Placeholder: "/home/pi/calendars", Config: "url", SetNew: setNewURL},
+		},
+		UpdateFields: []ProviderField{
+			{Name: "updLocalFilePath", Label: "File or Folder List (place each on a separate line)", Type: "textarea", Placeholder: "Path", Config: "url", SetUpdate: setURL},
+		},
+		New: func() CalendarProvider { return new(LocalFile) },
+	})
+}
+
+// SetConfig sets the configuration for this calendar provider
+func (p *LocalFile) SetConfig(c CalConfig) {
+	p.CalConfig = c
+}
+
+// RemovedConfig is used to clean up after config has been removed
+func (p *LocalFile) RemovedConfig(c CalConfig) error {
+	return nil
+}
+
+// ProviderName returns the name of the provider
+func (p *LocalFile) ProviderName() string {
+	return "LocalFile"
+}
+
+// GetEvents returns the calendar events between the start and end times
+func (p *LocalFile) GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error) {
+	evts := NewCalEvents(start, end)
+
+	files, err := p.getFiles()
+	if err != nil {
+		return evts, err
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return evts, err
+		}
+		b, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return evts, fmt.Errorf("Error reading file %s. %s", f.Path, err.Error())
+		}
+		if err := appendICalEvents(&evts, p.CalConfig, b); err != nil {
+			if f.InFolder {
+				// Skip items in a folder that cannot be parsed, rather than losing the whole calendar
+				continue
+			}
+			return evts, fmt.Errorf("Error parsing file %s. %s", f.Path, err.Error())
+		}
+	}
+	evts.EventCount = len(evts.Events)
+	return evts, nil
+}
+
+// GetSignature returns a value that changes whenever a file is added, removed or modified.
+func (p *LocalFile) GetSignature() (string, error) {
+	files, err := p.getFiles()
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s|%d|%d\n", f.Path, f.Size, f.ModTime.UnixNano())
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// ValidateConfig validates the configuration change for the calendar
+// and returns the calendar configuation ready to save
+func (p *LocalFile) ValidateConfig(c CalConfig) (CalConfig, error) {
+	if c.ID == "" {
+		return c, errors.New("ID must be specified")
+	}
+	if c.Name == "" {
+		return c, errors.New("Name must be specified")
+	}
+	if c.Colour == "" {
+		return c, errors.New("Colour must be specified")
+	}
+	if err := validateLocalPaths(c.URL); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// ValidateNewConfig validates the new configuration values
+// and returns the calendar configuration ready to save
+func (p *LocalFile) ValidateNewConfig(c NewCalConfig) (CalConfig, error) {
+	cc := CalConfig{}
+
+	if c.Name == "" {
+		return cc, errors.New("Name must be specified")
+	}
+	if c.Colour == "" {
+		return cc, errors.New("Colour must be selected")
+	}
+	if err := validateLocalPaths(c.URL); err != nil {
+		return cc, err
+	}
+
+	// Generate a new ID
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return cc, errors.New("Error creating GUID. " + err.Error())
+	}
+	cc.ID = uuid.String()
+	cc.Name = c.Name
+	cc.Provider = p.ProviderName()
+	cc.Colour = c.Colour
+	cc.URL = c.URL
+
+	return cc, nil
+}
+
+// localFile holds the details of an iCal file read by the LocalFile provider.
+type localFile struct {
+	Path     string    // Path of the file
+	Size     int64     // Size of the file
+	ModTime  time.Time // Time the file was last modified
+	InFolder bool      // Indicates the file was found by searching a folder
+}
+
+// getFiles returns the iCal files for the configured paths, sorted by path.
+func (p *LocalFile) getFiles() ([]localFile, error) {
+	l := []localFile{}
+	for _, path := range getLocalPaths(p.CalConfig.URL) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %s. %s", path, err.Error())
+		}
+		if !fi.IsDir() {
+			l = append(l, localFile{Path: path, Size: fi.Size(), ModTime: fi.ModTime()})
+			continue
+		}
+		err = filepath.Walk(path, func(fn string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			n := fi.Name()
+			if fi.IsDir() {
+				if fn != path && strings.HasPrefix(n, ".") {
+					// Skip hidden folders, e.g. .git
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasPrefix(n, ".") && strings.EqualFold(filepath.Ext(n), ".ics") {
+				l = append(l, localFile{Path: fn, Size: fi.Size(), ModTime: fi.ModTime(), InFolder: true})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error reading folder %s. %s", path, err.Error())
+		}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Path < l[j].Path })
+	return l, nil
+}
+
+// getLocalPaths splits the list of paths, one per line.  Paths can be specified as file URLs.
+func getLocalPaths(v string) []string {
+	l := []string{}
+	for _, path := range strings.Split(strings.Replace(v, "\r", "", -1), "\n") {
+		path = strings.TrimPrefix(strings.TrimSpace(path), "file://")
+		if path != "" {
+			l = append(l, filepath.Clean(path))
+		}
+	}
+	return l
+}
+
+// validateLocalPaths checks that each of the paths in the list exists.
+func validateLocalPaths(v string) error {
+	l := getLocalPaths(v)
+	if len(l) == 0 {
+		return errors.New("File or folder must be specified")
+	}
+	for _, path := range l {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("Unable to read %s. %s", path, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCanGetLocalFileEvents(t *testing.T) {
+	cc := CalConfig{
+		ID:       "testlocalfile",
+		Name:     "Test Local File",
+		Provider: "LocalFile",
+		URL:      "testdata/holidays.ics\nfile://testdata/vdir",
+		TimeZone: "Africa/Johannesburg",
+	}
+	p := LocalFile{CalConfig: cc}
+
+	loc := cc.Location()
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, loc)
+	l, err := p.GetEvents(context.Background(), start, start.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 holidays, 4 stand-ups and 1 lunch
+	if len(l.Events) != 7 {
+		t.Fatalf("Wrong number of events returned. Expected %d, got %d", 7, len(l.Events))
+	}
+	n := map[string]int{}
+	for _, e := range l.Events {
+		n[e.Summary]++
+		if e.Summary == "Standup" && e.Time != "09:00" {
+			t.Errorf("Wrong time for stand-up. Expected %s, got %s", "09:00", e.Time)
+		}
+	}
+	if n["Standup"] != 4 || n["Team Lunch"] != 1 || n["Human Rights Day"] != 1 || n["Good Friday"] != 1 {
+		t.Errorf("Wrong events returned. Got %v", n)
+	}
+}
+
+func TestMissingLocalFileFallsBack(t *testing.T) {
+	p := LocalFile{CalConfig: CalConfig{ID: "testlocalmissing", Name: "Test", URL: "testdata/missing.ics"}}
+	if _, err := p.GetEvents(context.Background(), time.Now(), time.Now().AddDate(0, 0, 7)); err == nil {
+		t.Error("No error returned for a missing file")
+	}
+	if _, err := p.ValidateNewConfig(NewCalConfig{Name: "Test", Colour: "Red", URL: "testdata/missing.ics"}); err == nil {
+		t.Error("No error returned for a missing file")
+	}
+}
+
+func TestLocalFileChangesAreDetected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, _ := ioutil.ReadFile("testdata/vdir/work/lunch.ics")
+	ioutil.WriteFile(filepath.Join(dir, "lunch.ics"), b, 0644)
+
+	s, _ := newTestServer(CalConfig{ID: "testwatch", Provider: "LocalFile", URL: dir}, CalConfig{ID: "testnowatch", Provider: "Test"})
+	w := NewChangeWatcher(s)
+	if l := w.Check(); len(l) != 0 {
+		t.Errorf("Changes detected on first check. Got %v", l)
+	}
+	if l := w.Check(); len(l) != 0 {
+		t.Errorf("Changes detected without changing files. Got %v", l)
+	}
+
+	b, _ = ioutil.ReadFile("testdata/vdir/work/standup.ics")
+	ioutil.WriteFile(filepath.Join(dir, "standup.ics"), b, 0644)
+	if l := w.Check(); len(l) != 1 || l[0] != "testwatch" {
+		t.Errorf("Added file not detected. Got %v", l)
+	}
+
+	os.Remove(filepath.Join(dir, "lunch.ics"))
+	if l := w.Check(); len(l) != 1 || l[0] != "testwatch" {
+		t.Errorf("Removed file not detected. Got %v", l)
+	}
+}
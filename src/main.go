@@ -13,7 +13,7 @@ var logger service.Logger
 
 func main() {
 	port := flag.Int("p", 20513, "Port Number to listen on.")
-	timeout := flag.Int("t", 2, "Timeout in seconds to wait for a response from a IP probe.")
+	timeout := flag.Int("t", 2, "Timeout in seconds to wait for a response from a IP probe or calendar provider.")
 	svcFlag := flag.String("service", "", "Service action.  Valid actions are: 'start', 'stop', 'restart', 'instal' and 'uninstall'")
 	noReg := flag.Bool("n", false, "Do not register the device with the finder server.")
 	flag.Parse()
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTPublisher publishes the next event, today's events, and whether each calendar is busy, to an MQTT
+// server, with the Home Assistant discovery messages that create the matching entities.  All the messages
+// are retained, and are only published again when they change, or after the server is reconnected to.
+type MQTTPublisher struct {
+	Srv      *Server       // Server the publisher belongs to
+	Interval time.Duration // Time between checks for changes to the published values
+	mu       sync.Mutex
+	client   mqtt.Client       // Client connected to the MQTT server
+	config   MQTTConfig        // Configuration the client was connected with
+	last     map[string]string // Messages last published, by topic
+	resend   bool              // Indicates that all the messages must be published again
+	wake     chan struct{}     // Signals that the client has connected
+}
+
+// mqttEvent holds the details of an event published to MQTT.
+type mqttEvent struct {
+	Calendar string    `json:"calendar"`           // Name of the calendar
+	Summary  string    `json:"summary"`            // Summary of the event
+	Location string    `json:"location,omitempty"` // Location of the event
+	Start    time.Time `json:"start"`              // Start time
+	End      time.Time `json:"end"`                // End time
+	AllDay   bool      `json:"allDay"`             // Indicates the event lasts for whole days
+	Message  string    `json:"message"`            // Day, time and location of the event, e.g. Tomorrow 10:00 (1h)
+}
+
+// mqttToday holds the events published for today.
+type mqttToday struct {
+	Date   string      `json:"date"`   // Today's date, e.g. 2018-03-01
+	Count  int         `json:"count"`  // Number of events today
+	Events []mqttEvent `json:"events"` // Today's events, sorted by start time
+}
+
+// haDiscovery holds the Home Assistant MQTT discovery configuration of an entity.
+type haDiscovery struct {
+	Name                string   `json:"name"`
+	UniqueID            string   `json:"unique_id"`
+	StateTopic          string   `json:"state_topic"`
+	ValueTemplate       string   `json:"value_template,omitempty"`
+	JSONAttributesTopic string   `json:"json_attributes_topic,omitempty"`
+	AvailabilityTopic   string   `json:"availability_topic"`
+	Icon                string   `json:"icon,omitempty"`
+	Unit                string   `json:"unit_of_measurement,omitempty"`
+	Device              haDevice `json:"device"`
+}
+
+// haDevice holds the details of the Home Assistant device that the entities belong to.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// haObjectIDRegex matches the characters that are not allowed in Home Assistant object identifiers.
+var haObjectIDRegex = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+// NewMQTTPublisher creates a new MQTT publisher for the server, which checks for changes every 30 seconds.
+func NewMQTTPublisher(s *Server) *MQTTPublisher {
+	return &MQTTPublisher{
+		Srv:      s,
+		Interval: 30 * time.Second,
+		last:     map[string]string{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Run publishes the events until the exit channel is closed, and then disconnects from the server.
+func (p *MQTTPublisher) Run(exit <-chan struct{}) {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+	defer p.Close()
+	for {
+		if err := p.Publish(time.Now()); err != nil {
+			p.logError("Error publishing to MQTT server.", err.Error())
+		}
+		select {
+		case <-exit:
+			return
+		case <-t.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// Publish publishes the messages that have changed since they were last published, as at the specified
+// time.  The server is connected to if the client is not connected yet, or the configuration has changed.
+// Nothing is published if no MQTT server is configured.
+func (p *MQTTPublisher) Publish(now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cfg := p.Srv.Config.Snapshot()
+	c := cfg.MQTT
+	if c == nil || c.URL == "" {
+		p.disconnect()
+		return nil
+	}
+	if p.client == nil || *c != p.config {
+		p.disconnect()
+		if err := p.connect(*c); err != nil {
+			return err
+		}
+	}
+	if !p.client.IsConnectionOpen() {
+		// The client publishes everything again when it reconnects
+		return nil
+	}
+
+	msgs := p.getMessages(cfg, now)
+	resend := p.resend
+	p.resend = false
+	for t, m := range msgs {
+		if last, ok := p.last[t]; ok && last == m && !resend {
+			continue
+		}
+		if err := p.publish(t, m); err != nil {
+			p.resend = resend
+			return err
+		}
+	}
+
+	// Remove the entities of calendars that have been removed
+	for t := range p.last {
+		if _, ok := msgs[t]; !ok {
+			if err := p.publish(t, ""); err != nil {
+				return err
+			}
+			delete(p.last, t)
+		}
+	}
+	return nil
+}
+
+// Close publishes that the calendar is offline, and disconnects from the server.
+func (p *MQTTPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disconnect()
+}
+
+// connect creates the client and connects to the server.  If the server cannot be connected to, the
+// client keeps trying in the background, and reconnects whenever the connection is lost.
+func (p *MQTTPublisher) connect(c MQTTConfig) error {
+	timeout := time.Duration(p.Srv.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	o := mqtt.NewClientOptions().
+		AddBroker(c.URL).
+		SetClientID(c.ClientID).
+		SetUsername(c.Username).
+		SetPassword(c.Password).
+		SetWill(p.getTopic(c, "status"), "offline", 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(func(mqtt.Client) {
+			p.logInfo("Connected to MQTT server", c.URL)
+			p.mu.Lock()
+			p.resend = true
+			p.mu.Unlock()
+			select {
+			case p.wake <- struct{}{}:
+			default:
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			p.logError("Lost connection to MQTT server.", err.Error())
+		})
+	p.client = mqtt.NewClient(o)
+	p.config = c
+	p.last = map[string]string{}
+	if !p.client.Connect().WaitTimeout(timeout) {
+		return errors.New("Timed out connecting to " + c.URL + ".  Retrying in the background")
+	}
+	return nil
+}
+
+// disconnect publishes that the calendar is offline, and disconnects from the server.
+func (p *MQTTPublisher) disconnect() {
+	if p.client == nil {
+		return
+	}
+	if p.client.IsConnectionOpen() {
+		waitMQTTToken(p.client.Publish(p.getTopic(p.config, "status"), 1, true, "offline"), time.Second)
+	}
+	p.client.Disconnect(250)
+	p.client = nil
+}
+
+// publish publishes the retained message to the topic.  An empty message removes the retained message.
+func (p *MQTTPublisher) publish(topic string, msg string) error {
+	if err := waitMQTTToken(p.client.Publish(topic, 1, true, msg), 10*time.Second); err != nil {
+		return fmt.Errorf("Error publishing to %s. %s", topic, err.Error())
+	}
+	if msg != "" {
+		p.last[topic] = msg
+	}
+	return nil
+}
+
+// getMessages returns the messages to publish for the configuration as at the specified time, by topic.
+func (p *MQTTPublisher) getMessages(cfg *Config, now time.Time) map[string]string {
+	c := p.config
+	loc := cfg.Location()
+	now = now.In(loc)
+	y, m, d := now.Date()
+	ts := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	te := ts.AddDate(0, 0, 1)
+
+	msgs := map[string]string{p.getTopic(c, "status"): "online"}
+	var next *mqttEvent
+	today := mqttToday{Date: ts.Format("2006-01-02"), Events: []mqttEvent{}}
+	for _, cc := range cfg.Calendars {
+		evts, _ := p.Srv.Cache.Get(cc.ID)
+		busy := false
+		for _, e := range evts.Events {
+			e.SetLocation(loc)
+			me := mqttEvent{
+				Calendar: cc.Name,
+				Summary:  e.Summary,
+				Location: e.Location,
+				Start:    e.Start,
+				End:      e.End,
+				AllDay:   e.AllDay,
+				Message:  getReminderMessage(e, now),
+			}
+			if !e.AllDay && !now.Before(e.Start) && now.Before(e.End) {
+				busy = true
+			}
+			if e.Overlaps(ts, te) {
+				today.Events = append(today.Events, me)
+			}
+			if e.Start.After(now) && (next == nil || e.Start.Before(next.Start)) {
+				next = &me
+			}
+		}
+
+		id := haObjectIDRegex.ReplaceAllString(cc.ID, "_")
+		state := "OFF"
+		if busy {
+			state = "ON"
+		}
+		msgs[p.getTopic(c, id, "busy")] = state
+		msgs[p.getDiscoveryTopic(c, "binary_sensor", id)] = p.getDiscovery(c, haDiscovery{
+			Name:       cc.Name + " Busy",
+			UniqueID:   id + "_busy",
+			StateTopic: p.getTopic(c, id, "busy"),
+			Icon:       "mdi:calendar-clock",
+		})
+	}
+	sort.SliceStable(today.Events, func(i, j int) bool {
+		return today.Events[i].Start.Before(today.Events[j].Start)
+	})
+	today.Count = len(today.Events)
+
+	b, _ := json.Marshal(today)
+	msgs[p.getTopic(c, "today")] = string(b)
+	b = []byte("{}")
+	if next != nil {
+		b, _ = json.Marshal(next)
+	}
+	msgs[p.getTopic(c, "next")] = string(b)
+
+	msgs[p.getDiscoveryTopic(c, "sensor", "next_event")] = p.getDiscovery(c, haDiscovery{
+		Name:                "Next Event",
+		UniqueID:            "next_event",
+		StateTopic:          p.getTopic(c, "next"),
+		ValueTemplate:       "{{ value_json.summary | default('None') }}",
+		JSONAttributesTopic: p.getTopic(c, "next"),
+		Icon:                "mdi:calendar-arrow-right",
+	})
+	msgs[p.getDiscoveryTopic(c, "sensor", "today")] = p.getDiscovery(c, haDiscovery{
+		Name:                "Today's Events",
+		UniqueID:            "today",
+		StateTopic:          p.getTopic(c, "today"),
+		ValueTemplate:       "{{ value_json.count }}",
+		JSONAttributesTopic: p.getTopic(c, "today"),
+		Icon:                "mdi:calendar-today",
+		Unit:                "events",
+	})
+	return msgs
+}
+
+// getDiscovery returns the discovery message for the entity, which belongs to the device of the client.
+func (p *MQTTPublisher) getDiscovery(c MQTTConfig, d haDiscovery) string {
+	node := p.getNodeID(c)
+	d.UniqueID = node + "_" + d.UniqueID
+	d.AvailabilityTopic = p.getTopic(c, "status")
+	d.Device = haDevice{
+		Identifiers:  []string{node},
+		Name:         "Calendar",
+		Manufacturer: "Brumawen",
+		Model:        "Calendar Microservice",
+	}
+	b, _ := json.Marshal(d)
+	return string(b)
+}
+
+// getTopic returns the topic under the base topic of the configuration.
+func (p *MQTTPublisher) getTopic(c MQTTConfig, l ...string) string {
+	return strings.Join(append([]string{c.Topic}, l...), "/")
+}
+
+// getDiscoveryTopic returns the topic of the discovery message for the entity.
+func (p *MQTTPublisher) getDiscoveryTopic(c MQTTConfig, component string, id string) string {
+	return strings.Join([]string{c.DiscoveryPrefix, component, p.getNodeID(c), id, "config"}, "/")
+}
+
+// getNodeID returns the identifier of the device in Home Assistant, made from the client identifier.
+func (p *MQTTPublisher) getNodeID(c MQTTConfig) string {
+	return haObjectIDRegex.ReplaceAllString(c.ClientID, "_")
+}
+
+// logInfo logs an information message to the logger
+func (p *MQTTPublisher) logInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("MQTTPublisher: [Inf] ", a[1:len(a)-1])
+}
+
+// logError logs an error message to the logger
+func (p *MQTTPublisher) logError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("MQTTPublisher: [Err] ", a[1:len(a)-1])
+}
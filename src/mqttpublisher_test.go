@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// testMQTTMessages records the last message published to each topic of an embedded broker.
+type testMQTTMessages struct {
+	mu   sync.Mutex
+	msgs map[string]string
+}
+
+func newTestMQTTMessages(t *testing.T, b *mochi.Server) *testMQTTMessages {
+	m := &testMQTTMessages{msgs: map[string]string{}}
+	err := b.Subscribe("#", 1, func(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.msgs[pk.TopicName] = string(pk.Payload)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// wait waits for the message to be published to the topic, and returns false if it is not.
+func (m *testMQTTMessages) wait(topic string, msg string, timeout time.Duration) bool {
+	for end := time.Now().Add(timeout); time.Now().Before(end); time.Sleep(20 * time.Millisecond) {
+		if v, ok := m.get(topic); ok && v == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *testMQTTMessages) get(topic string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.msgs[topic]
+	return v, ok
+}
+
+func TestMQTTPublisherPublishesEvents(t *testing.T) {
+	b, u := newTestMQTTBroker(t, "127.0.0.1:0")
+	defer b.Close()
+	m := newTestMQTTMessages(t, b)
+
+	s, _ := newTestServer(CalConfig{ID: "work", Name: "Work"}, CalConfig{ID: "home", Name: "Home"})
+	s.Config.TimeZone = "UTC"
+	s.Config.MQTT = &MQTTConfig{URL: u}
+	s.Config.SetDefaults()
+	now := time.Date(2018, 3, 1, 10, 30, 0, 0, time.UTC)
+	s.Cache.Set("work", CalEvents{Events: []CalEvent{
+		{Summary: "Standup", Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+		{Summary: "Review", Start: now.Add(3 * time.Hour), End: now.Add(4 * time.Hour)},
+	}})
+	s.Cache.Set("home", CalEvents{Events: []CalEvent{
+		{Summary: "Holiday", Start: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), AllDay: true},
+		{Summary: "Dentist", Start: now.Add(24 * time.Hour), End: now.Add(25 * time.Hour)},
+	}})
+
+	p := NewMQTTPublisher(s)
+	defer p.Close()
+	if err := p.Publish(now); err != nil {
+		t.Fatal(err)
+	}
+	if !m.wait("calendar/status", "online", 5*time.Second) {
+		t.Fatal("Status not published")
+	}
+	if !m.wait("calendar/work/busy", "ON", 5*time.Second) || !m.wait("calendar/home/busy", "OFF", 5*time.Second) {
+		t.Error("Busy states not published")
+	}
+
+	v, _ := m.get("calendar/next")
+	next := mqttEvent{}
+	if err := json.Unmarshal([]byte(v), &next); err != nil || next.Summary != "Review" || next.Calendar != "Work" {
+		t.Errorf("Wrong next event published. %s", v)
+	}
+	v, _ = m.get("calendar/today")
+	today := mqttToday{}
+	if err := json.Unmarshal([]byte(v), &today); err != nil || today.Count != 3 || today.Date != "2018-03-01" {
+		t.Errorf("Wrong events published for today. %s", v)
+	} else if today.Events[0].Summary != "Holiday" || today.Events[2].Summary != "Review" {
+		t.Errorf("Today's events not sorted. %s", v)
+	}
+
+	v, _ = m.get("homeassistant/binary_sensor/calendar/work/config")
+	d := haDiscovery{}
+	if err := json.Unmarshal([]byte(v), &d); err != nil || d.StateTopic != "calendar/work/busy" || d.UniqueID != "calendar_work_busy" || d.AvailabilityTopic != "calendar/status" {
+		t.Errorf("Wrong discovery published for the busy sensor. %s", v)
+	}
+	for _, topic := range []string{"homeassistant/sensor/calendar/next_event/config", "homeassistant/sensor/calendar/today/config"} {
+		if v, ok := m.get(topic); !ok || !strings.Contains(v, `"identifiers":["calendar"]`) {
+			t.Errorf("Wrong discovery published to %s. %s", topic, v)
+		}
+	}
+
+	// Removing a calendar removes its entity
+	s.Config.Calendars = s.Config.Calendars[:1]
+	if err := p.Publish(now); err != nil {
+		t.Fatal(err)
+	}
+	if !m.wait("homeassistant/binary_sensor/calendar/home/config", "", 5*time.Second) {
+		t.Error("Discovery not removed for the removed calendar")
+	}
+}
+
+func TestMQTTPublisherReconnects(t *testing.T) {
+	b, u := newTestMQTTBroker(t, "127.0.0.1:0")
+	s, _ := newTestServer(CalConfig{ID: "work", Name: "Work"})
+	s.Config.MQTT = &MQTTConfig{URL: u}
+	s.Config.SetDefaults()
+
+	p := NewMQTTPublisher(s)
+	exit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.Run(exit)
+		close(done)
+	}()
+	if !newTestMQTTMessages(t, b).wait("calendar/status", "online", 5*time.Second) {
+		t.Fatal("Status not published")
+	}
+
+	// Restart the broker, which loses the retained messages
+	b.Close()
+	b, _ = newTestMQTTBroker(t, strings.TrimPrefix(u, "tcp://"))
+	m := newTestMQTTMessages(t, b)
+	if !m.wait("calendar/work/busy", "OFF", 15*time.Second) {
+		t.Error("Busy state not published again after reconnecting")
+	}
+	if _, ok := m.get("homeassistant/binary_sensor/calendar/work/config"); !ok {
+		t.Error("Discovery not published again after reconnecting")
+	}
+
+	close(exit)
+	<-done
+	if !m.wait("calendar/status", "offline", 5*time.Second) {
+		t.Error("Offline status not published when stopping")
+	}
+	b.Close()
+}
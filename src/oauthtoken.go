@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// getTokenFileName returns the name of the file holding the OAuth token for a calendar.
+func getTokenFileName(id string) string {
+	return fmt.Sprintf("Token_%s.json", id)
+}
+
+// getTokenFromFile retrieves the OAuth token for a calendar from a local file.
+func getTokenFromFile(id string) (*oauth2.Token, error) {
+	f, err := os.Open(getTokenFileName(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	token := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(token)
+	return token, err
+}
+
+// saveTokenToFile saves the OAuth token for a calendar to a local file.
+func saveTokenToFile(id string, token *oauth2.Token) error {
+	f, err := os.OpenFile(getTokenFileName(id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to cache oauth token: %v", err)
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(token)
+}
+
+// removeTokenFile removes the OAuth token file for a calendar, if it exists.
+func removeTokenFile(id string) error {
+	tokenFile := getTokenFileName(id)
+	if _, err := os.Stat(tokenFile); err == nil {
+		return os.Remove(tokenFile)
+	}
+	return nil
+}
+
+// savingTokenSource saves the token to the token file of the calendar whenever it is refreshed,
+// so that rotated refresh tokens are not lost.
+type savingTokenSource struct {
+	ID     string             // Identifier of the calendar the token belongs to
+	Source oauth2.TokenSource // Source that refreshes the token
+	last   string
+}
+
+// Token returns the current token, saving it if it has changed.
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	t, err := s.Source.Token()
+	if err != nil {
+		return nil, err
+	}
+	if t.AccessToken != s.last {
+		s.last = t.AccessToken
+		if err := saveTokenToFile(s.ID, t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// newSavingTokenSource returns a token source for the calendar that refreshes the token using
+// the OAuth configuration and saves each refreshed token.
+func newSavingTokenSource(ctx context.Context, config *oauth2.Config, id string, token *oauth2.Token) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(token, &savingTokenSource{
+		ID:     id,
+		Source: config.TokenSource(ctx, token),
+		last:   token.AccessToken,
+	})
+}
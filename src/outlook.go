@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/oauth2"
+)
+
+// Outlook is a calendar provider for an Outlook 365 calendar, using the Microsoft Graph API.
+type Outlook struct {
+	CalConfig CalConfig // Selected Calendar Configuration
+}
+
+// OutlookCredentials holds the details of the application registered with Microsoft, as read
+// from the outlook.json file.
+type OutlookCredentials struct {
+	ClientID     string `json:"clientId"`     // Application (client) ID
+	ClientSecret string `json:"clientSecret"` // Client secret, blank for public client applications
+	Tenant       string `json:"tenant"`       // Directory (tenant) ID, defaults to common
+	RedirectURL  string `json:"redirectUrl"`  // Redirect URI registered for the application
+	AuthorityURL string `json:"authorityUrl"` // Login endpoint, defaults to https://login.microsoftonline.com
+	GraphURL     string `json:"graphUrl"`     // Graph API endpoint, defaults to https://graph.microsoft.com/v1.0
+}
+
+// outlookCredentialsFile is the name of the file holding the Outlook application credentials.
+var outlookCredentialsFile = "outlook.json"
+
+// outlookScopes are the permissions requested when authenticating with Microsoft.
+var outlookScopes = []string{"offline_access", "User.Read", "Calendars.Read"}
+
+type graphEventList struct {
+	Value    []graphEvent `json:"value"`
+	NextLink string       `json:"@odata.nextLink"`
+}
+
+type graphEvent struct {
+	ID            string        `json:"id"`
+	ICalUID       string        `json:"iCalUId"`
+	Subject       string        `json:"subject"`
+	BodyPreview   string        `json:"bodyPreview"`
+	Location      graphLocation `json:"location"`
+	Start         graphDateTime `json:"start"`
+	End           graphDateTime `json:"end"`
+	IsAllDay      bool          `json:"isAllDay"`
+	IsCancelled   bool          `json:"isCancelled"`
+	Type          string        `json:"type"`
+	OriginalStart string        `json:"originalStart"`
+}
+
+type graphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name:  "Outlook",
+		Title: "Outlook 365 Calendar",
+		NewFields: []ProviderField{
+			{Name: "addOutlookCode", Label: "Authentication Code", Type: "text", Placeholder: "Paste Authentication Code Here", Config: "authCode", SetNew: setNewAuthCode},
+		},
+		New: func() CalendarProvider { return new(Outlook) },
+	})
+}
+
+// SetConfig sets the configuration for this calendar provider
+func (o *Outlook) SetConfig(c CalConfig) {
+	o.CalConfig = c
+}
+
+// RemovedConfig is used to clean up after config has been removed
+func (o *Outlook) RemovedConfig(c CalConfig) error {
+	return removeTokenFile(c.ID)
+}
+
+// ProviderName returns the name of the provider
+func (o *Outlook) ProviderName() string {
+	return "Outlook"
+}
+
+// GetEvents returns the calendar events between the start and end times
+func (o *Outlook) GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error) {
+	evts := NewCalEvents(start, end)
+
+	cred, err := o.getCredentials()
+	if err != nil {
+		return evts, err
+	}
+	client, err := o.getClient(ctx, cred)
+	if err != nil {
+		return evts, fmt.Errorf("Error getting client. %s", err.Error())
+	}
+
+	// Request the times in UTC, so that Windows time zone names do not have to be resolved
+	q := url.Values{}
+	q.Set("startDateTime", evts.Start.UTC().Format(time.RFC3339))
+	q.Set("endDateTime", evts.End.UTC().Format(time.RFC3339))
+	q.Set("$top", "100")
+	q.Set("$orderby", "start/dateTime")
+	u := strings.TrimRight(cred.GraphURL, "/") + "/me/calendarView?" + q.Encode()
+
+	items := []graphEvent{}
+	for u != "" {
+		l := graphEventList{}
+		if err := o.getJSON(ctx, client, u, &l); err != nil {
+			return evts, fmt.Errorf("Error retrieving calendar events. %s", err.Error())
+		}
+		items = append(items, l.Value...)
+		u = l.NextLink
+	}
+
+	// All-day events are in the time zone of the calendar
+	loc := o.CalConfig.Location()
+	for _, item := range items {
+		if item.IsCancelled {
+			continue
+		}
+		st, err := o.getTime(item.Start, item.IsAllDay, loc)
+		if err != nil {
+			continue
+		}
+		et, err := o.getTime(item.End, item.IsAllDay, loc)
+		if err != nil {
+			continue
+		}
+		uid := item.ICalUID
+		if uid == "" {
+			uid = item.ID
+		}
+		rid := ""
+		if item.OriginalStart != "" {
+			if ot, err := time.Parse(time.RFC3339, item.OriginalStart); err == nil {
+				rid = formatRecurrenceID(ot)
+			}
+		} else if item.Type == "occurrence" {
+			rid = formatRecurrenceID(st)
+		}
+		evts.Events = append(evts.Events, CalEvent{
+			ID:           o.CalConfig.ID,
+			Name:         o.CalConfig.Name,
+			UID:          uid,
+			RecurrenceID: rid,
+			Start:        st,
+			End:          et,
+			DayName:      st.Weekday().String(),
+			Time:         st.Format("15:04"),
+			Duration:     GetDurationString(st, et),
+			Summary:      item.Subject,
+			Description:  item.BodyPreview,
+			Location:     item.Location.DisplayName,
+			Colour:       o.CalConfig.Colour,
+			InProgress:   st.Before(evts.Start),
+			AllDay:       item.IsAllDay,
+			Days:         GetDaySpan(st, et, item.IsAllDay),
+		})
+	}
+	evts.EventCount = len(evts.Events)
+
+	return evts, nil
+}
+
+// GetAuthenticateURL returns the URL that will be used to sign in to the Microsoft
+// account and authorise access to the calendar.
+func (o *Outlook) GetAuthenticateURL() (string, error) {
+	cred, err := o.getCredentials()
+	if err != nil {
+		return "", err
+	}
+	config := o.getConfig(cred)
+	return config.AuthCodeURL("state-token", oauth2.SetAuthURLParam("prompt", "select_account")), nil
+}
+
+// ValidateConfig validates the configuration change for the calendar
+// and returns the calendar configuation ready to save
+func (o *Outlook) ValidateConfig(c CalConfig) (CalConfig, error) {
+	if c.ID == "" {
+		return c, errors.New("ID must be specified")
+	}
+	if c.Name == "" {
+		return c, errors.New("Name must be specified")
+	}
+	if c.Colour == "" {
+		return c, errors.New("Colour must be specified")
+	}
+	return c, nil
+}
+
+// ValidateNewConfig validates the new configuration values
+// and returns the calendar configuration ready to save
+func (o *Outlook) ValidateNewConfig(c NewCalConfig) (CalConfig, error) {
+	cc := CalConfig{}
+
+	cred, err := o.getCredentials()
+	if err != nil {
+		return cc, err
+	}
+
+	if c.Name == "" {
+		return cc, errors.New("Name must be specified")
+	}
+	if c.AuthCode == "" {
+		return cc, errors.New("Authentication code must be specified")
+	}
+	if c.Colour == "" {
+		return cc, errors.New("Colour must be selected")
+	}
+
+	// Get the token
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	token, err := o.getConfig(cred).Exchange(ctx, o.getAuthCode(c.AuthCode))
+	if err != nil {
+		return cc, fmt.Errorf("Unable to retrieve authentication token. %v", err)
+	}
+
+	// Generate a new ID
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return cc, errors.New("Error creating GUID. " + err.Error())
+	}
+	cc.ID = uuid.String()
+	cc.Name = c.Name
+	cc.Provider = o.ProviderName()
+	cc.Colour = c.Colour
+
+	// Save the token
+	err = saveTokenToFile(cc.ID, token)
+	if err != nil {
+		return cc, err
+	}
+	return cc, nil
+}
+
+// getAuthCode returns the authentication code.  The whole redirect URL can be pasted, in
+// which case the code is read from its query string.
+func (o *Outlook) getAuthCode(v string) string {
+	v = strings.TrimSpace(v)
+	if u, err := url.Parse(v); err == nil && u.Scheme != "" {
+		if c := u.Query().Get("code"); c != "" {
+			return c
+		}
+	}
+	return v
+}
+
+// getTime returns the time of a Graph date-time.  All-day events are anchored to midnight
+// in the time zone of the calendar.
+func (o *Outlook) getTime(d graphDateTime, allDay bool, loc *time.Location) (time.Time, error) {
+	v := d.DateTime
+	if i := strings.Index(v, "."); i >= 0 {
+		// Graph returns fractional seconds with 7 digits
+		v = v[:i]
+	}
+	if allDay {
+		if len(v) < 10 {
+			return time.Time{}, fmt.Errorf("Invalid date '%s'", d.DateTime)
+		}
+		return time.ParseInLocation("2006-01-02", v[:10], loc)
+	}
+	z := &icalTimeZones{Default: time.UTC}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", v, z.Location(d.TimeZone))
+	if err != nil {
+		return t, err
+	}
+	return t.In(loc), nil
+}
+
+// getJSON sends a GET request to the Graph API and decodes the JSON response.
+func (o *Outlook) getJSON(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Prefer", `outlook.timezone="UTC"`)
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		ge := graphError{}
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(b, &ge) == nil && ge.Error.Message != "" {
+			return fmt.Errorf("%s. %s", resp.Status, ge.Error.Message)
+		}
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getCredentials reads the application credentials from the outlook.json file.
+func (o *Outlook) getCredentials() (OutlookCredentials, error) {
+	cred := OutlookCredentials{}
+	b, err := ioutil.ReadFile(outlookCredentialsFile)
+	if err != nil {
+		return cred, fmt.Errorf("Error reading %s file. %s", outlookCredentialsFile, err.Error())
+	}
+	if err := json.Unmarshal(b, &cred); err != nil {
+		return cred, fmt.Errorf("Error parsing %s file. %s", outlookCredentialsFile, err.Error())
+	}
+	if cred.ClientID == "" {
+		return cred, fmt.Errorf("Client ID not specified in %s file", outlookCredentialsFile)
+	}
+	if cred.Tenant == "" {
+		cred.Tenant = "common"
+	}
+	if cred.RedirectURL == "" {
+		cred.RedirectURL = "https://login.microsoftonline.com/common/oauth2/nativeclient"
+	}
+	if cred.AuthorityURL == "" {
+		cred.AuthorityURL = "https://login.microsoftonline.com"
+	}
+	if cred.GraphURL == "" {
+		cred.GraphURL = "https://graph.microsoft.com/v1.0"
+	}
+	return cred, nil
+}
+
+func (o *Outlook) getConfig(cred OutlookCredentials) *oauth2.Config {
+	a := strings.TrimRight(cred.AuthorityURL, "/") + "/" + cred.Tenant + "/oauth2/v2.0"
+	return &oauth2.Config{
+		ClientID:     cred.ClientID,
+		ClientSecret: cred.ClientSecret,
+		RedirectURL:  cred.RedirectURL,
+		Scopes:       outlookScopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  a + "/authorize",
+			TokenURL: a + "/token",
+		},
+	}
+}
+
+func (o *Outlook) getClient(ctx context.Context, cred OutlookCredentials) (*http.Client, error) {
+	token, err := getTokenFromFile(o.CalConfig.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading token file for %s. %s", o.CalConfig.Name, err.Error())
+	}
+	// Microsoft rotates refresh tokens, so each refreshed token must be saved
+	ts := newSavingTokenSource(ctx, o.getConfig(cred), o.CalConfig.ID, token)
+	return oauth2.NewClient(ctx, ts), nil
+}
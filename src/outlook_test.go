@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newTestGraphServer creates an in-process stand-in for the Microsoft login and Graph endpoints.
+func newTestGraphServer(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test-tenant/oauth2/v2.0/token":
+			r.ParseForm()
+			tok := ""
+			switch {
+			case r.Form.Get("grant_type") == "authorization_code" && r.Form.Get("code") == "good-code":
+				tok = "access-1"
+			case r.Form.Get("grant_type") == "refresh_token" && r.Form.Get("refresh_token") == "refresh-1":
+				tok = "access-2"
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":"%s","refresh_token":"refresh-%s","token_type":"Bearer","expires_in":3600}`, tok, tok[len(tok)-1:])
+		case "/v1.0/me/calendarView":
+			if a := r.Header.Get("Authorization"); a != "Bearer access-1" && a != "Bearer access-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error":{"code":"InvalidAuthenticationToken","message":"Access token is empty."}}`)
+				return
+			}
+			if r.URL.Query().Get("startDateTime") != "2018-03-01T00:00:00Z" {
+				t.Errorf("Wrong start time requested. Got '%s'", r.URL.Query().Get("startDateTime"))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("page") == "" {
+				fmt.Fprintf(w, `{"value":[
+					{"id":"1","iCalUId":"meeting@example.com","subject":"Meeting","bodyPreview":"Agenda","location":{"displayName":"Room 1"},
+					 "start":{"dateTime":"2018-03-01T07:00:00.0000000","timeZone":"UTC"},"end":{"dateTime":"2018-03-01T08:30:00.0000000","timeZone":"UTC"},
+					 "isAllDay":false,"isCancelled":false,"type":"singleInstance"},
+					{"id":"2","iCalUId":"cancelled@example.com","subject":"Cancelled",
+					 "start":{"dateTime":"2018-03-01T09:00:00.0000000","timeZone":"UTC"},"end":{"dateTime":"2018-03-01T10:00:00.0000000","timeZone":"UTC"},
+					 "isCancelled":true}
+				],"@odata.nextLink":"%s/v1.0/me/calendarView?page=2&startDateTime=2018-03-01T00:00:00Z"}`, srv.URL)
+				return
+			}
+			fmt.Fprint(w, `{"value":[
+				{"id":"3","iCalUId":"holiday@example.com","subject":"Holiday",
+				 "start":{"dateTime":"2018-03-02T00:00:00.0000000","timeZone":"UTC"},"end":{"dateTime":"2018-03-03T00:00:00.0000000","timeZone":"UTC"},
+				 "isAllDay":true,"type":"occurrence","originalStart":"2018-03-02T00:00:00Z"}
+			]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv
+}
+
+// setTestOutlookCredentials points the Outlook provider at the test server.
+func setTestOutlookCredentials(t *testing.T, srv *httptest.Server) func() {
+	dir, err := ioutil.TempDir("", "outlook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(OutlookCredentials{
+		ClientID:     "client",
+		Tenant:       "test-tenant",
+		AuthorityURL: srv.URL,
+		GraphURL:     srv.URL + "/v1.0",
+	})
+	fn := filepath.Join(dir, "outlook.json")
+	ioutil.WriteFile(fn, b, 0600)
+	old := outlookCredentialsFile
+	outlookCredentialsFile = fn
+	return func() {
+		outlookCredentialsFile = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCanAddOutlookCalendar(t *testing.T) {
+	srv := newTestGraphServer(t)
+	defer srv.Close()
+	defer setTestOutlookCredentials(t, srv)()
+
+	o := new(Outlook)
+	u, err := o.GetAuthenticateURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, srv.URL+"/test-tenant/oauth2/v2.0/authorize?") || !strings.Contains(u, "Calendars.Read") {
+		t.Errorf("Wrong authentication URL. Got '%s'", u)
+	}
+
+	if _, err := o.ValidateNewConfig(NewCalConfig{Name: "Test", Colour: "Red", AuthCode: "bad-code"}); err == nil {
+		t.Error("No error returned for an invalid authentication code")
+	}
+
+	// The whole redirect URL can be pasted
+	cc, err := o.ValidateNewConfig(NewCalConfig{Name: "Test", Colour: "Red", AuthCode: "https://login.microsoftonline.com/common/oauth2/nativeclient?code=good-code"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.RemovedConfig(cc)
+	if cc.Provider != "Outlook" {
+		t.Errorf("Wrong provider. Got '%s'", cc.Provider)
+	}
+	tok, err := getTokenFromFile(cc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access-1" {
+		t.Errorf("Wrong token saved. Got '%s'", tok.AccessToken)
+	}
+}
+
+func TestCanGetOutlookEvents(t *testing.T) {
+	srv := newTestGraphServer(t)
+	defer srv.Close()
+	defer setTestOutlookCredentials(t, srv)()
+
+	cc := CalConfig{ID: "testoutlook", Name: "Test", Provider: "Outlook", Colour: "Blue", TimeZone: "Africa/Johannesburg"}
+	// Use an expired token to check that the refreshed token is saved
+	if err := saveTokenToFile(cc.ID, &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh-1", TokenType: "Bearer", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	o := new(Outlook)
+	defer o.RemovedConfig(cc)
+
+	o.SetConfig(cc)
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	l, err := o.GetEvents(context.Background(), start, start.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Events) != 2 {
+		t.Fatalf("Wrong number of events returned. Expected %d, got %d", 2, len(l.Events))
+	}
+	if l.EventCount != 2 {
+		t.Errorf("Wrong event count returned. Expected %d, got %d", 2, l.EventCount)
+	}
+	e := l.Events[0]
+	if e.Summary != "Meeting" || e.Location != "Room 1" || e.Time != "09:00" || e.Duration != "1h 30m" {
+		t.Errorf("Wrong event returned. Got %v", e)
+	}
+	e = l.Events[1]
+	if !e.AllDay || e.Duration != "All Day" || e.RecurrenceID != "20180302T000000Z" {
+		t.Errorf("Wrong all-day event returned. Got %v", e)
+	}
+	if _, off := e.Start.Zone(); off != 2*60*60 || e.Start.Hour() != 0 {
+		t.Errorf("All-day event not in the calendar time zone. Got %v", e.Start)
+	}
+
+	tok, err := getTokenFromFile(cc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access-2" || tok.RefreshToken != "refresh-2" {
+		t.Errorf("Refreshed token not saved. Got %v", tok)
+	}
+}
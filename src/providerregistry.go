@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProviderInfo holds the registration details for a calendar provider.
+type ProviderInfo struct {
+	Name         string                  `json:"name"`         // Name of the provider, as stored in CalConfig.Provider
+	Title        string                  `json:"title"`        // Display title of the provider
+	NewFields    []ProviderField         `json:"newFields"`    // Form fields used when adding a new calendar
+	UpdateFields []ProviderField         `json:"updateFields"` // Form fields used when updating an existing calendar
+	Writable     bool                    `json:"writable"`     // Indicates the provider can create, update and delete events
+	New          func() CalendarProvider `json:"-"`            // Factory that creates a new instance of the provider
+}
+
+// ProviderField describes a provider specific field on the configuration forms.
+// Fields without a setter are stored in the Values of the configuration, under the Config name.
+type ProviderField struct {
+	Name        string                          `json:"name"`        // Form field name
+	Label       string                          `json:"label"`       // Display label
+	Type        string                          `json:"type"`        // Input type (text, textarea, password, account, calendars)
+	Placeholder string                          `json:"placeholder"` // Placeholder text
+	Config      string                          `json:"config"`      // Name of the configuration value the field maps to
+	SetNew      func(c *NewCalConfig, v string) `json:"-"`           // Sets the value of a new calendar field
+	SetUpdate   func(c *CalConfig, v string)    `json:"-"`           // Sets the value of an update field
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderInfo{}
+)
+
+// RegisterProvider registers a calendar provider so that it can be resolved by name.
+// It is intended to be called from the init function of the provider.
+func RegisterProvider(p ProviderInfo) {
+	if p.Name == "" {
+		panic("calendar: provider name must be specified")
+	}
+	if p.New == nil {
+		panic(fmt.Sprintf("calendar: provider %s has no factory", p.Name))
+	}
+	if p.Title == "" {
+		p.Title = p.Name
+	}
+	_, p.Writable = p.New().(EventWriter)
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if _, dup := providers[p.Name]; dup {
+		panic(fmt.Sprintf("calendar: provider %s registered twice", p.Name))
+	}
+	providers[p.Name] = p
+}
+
+// GetProviderInfo returns the registration details for the named provider.
+func GetProviderInfo(name string) (ProviderInfo, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return p, fmt.Errorf("Invalid Calendar provider '%s'", name)
+	}
+	return p, nil
+}
+
+// GetProviderInfos returns the registration details for all of the providers, sorted by name.
+func GetProviderInfos() []ProviderInfo {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	l := []ProviderInfo{}
+	for _, p := range providers {
+		l = append(l, p)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name < l[j].Name
+	})
+	return l
+}
+
+// NewCalendarProvider creates a new instance of the named provider.
+func NewCalendarProvider(name string) (CalendarProvider, error) {
+	p, err := GetProviderInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.New(), nil
+}
+
+// NewConfigFromForm reads the provider specific new calendar fields from the submitted form.
+// Fields with more than one value, e.g. check boxes, are joined into separate lines.
+func (p ProviderInfo) NewConfigFromForm(nc *NewCalConfig, f url.Values) {
+	for _, i := range p.NewFields {
+		v := strings.Join(f[i.Name], "\n")
+		if i.SetNew != nil {
+			i.SetNew(nc, v)
+			continue
+		}
+		if nc.Values == nil {
+			nc.Values = map[string]string{}
+		}
+		nc.Values[i.Config] = v
+	}
+}
+
+// UpdateConfigFromForm reads the provider specific update fields from the submitted form.
+func (p ProviderInfo) UpdateConfigFromForm(cc *CalConfig, f url.Values) {
+	for _, i := range p.UpdateFields {
+		v := f.Get(i.Name)
+		if i.SetUpdate != nil {
+			i.SetUpdate(cc, v)
+			continue
+		}
+		vl := map[string]string{}
+		for k, cv := range cc.Values {
+			vl[k] = cv
+		}
+		vl[i.Config] = v
+		cc.Values = vl
+	}
+}
+
+// The setters of the provider fields that map to the common configuration values.
+func setNewAuthCode(c *NewCalConfig, v string)    { c.AuthCode = v }
+func setNewURL(c *NewCalConfig, v string)         { c.URL = v }
+func setNewUsername(c *NewCalConfig, v string)    { c.Username = v }
+func setNewPassword(c *NewCalConfig, v string)    { c.Password = v }
+func setNewAccount(c *NewCalConfig, v string)     { c.Account = v }
+func setNewCalendarIDs(c *NewCalConfig, v string) { c.CalendarIDs = splitLines(v) }
+func setURL(c *CalConfig, v string)               { c.URL = v }
+func setCalendarIDs(c *CalConfig, v string)       { c.CalendarIDs = splitLines(v) }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestProvidersAreRegistered(t *testing.T) {
+	for _, n := range []string{"Google", "iCal"} {
+		p, err := NewCalendarProvider(n)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if p.ProviderName() != n {
+			t.Errorf("Wrong provider returned. Expected %s, got %s", n, p.ProviderName())
+		}
+	}
+	if _, err := NewCalendarProvider("Unknown"); err == nil {
+		t.Error("No error returned for an unknown provider")
+	}
+}
+
+func TestCanReadNewConfigFromForm(t *testing.T) {
+	pi, err := GetProviderInfo("iCal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := url.Values{}
+	f.Set("addiCalUrl", "http://localhost/basic.ics")
+	nc := NewCalConfig{}
+	pi.NewConfigFromForm(&nc, f)
+	if nc.URL != "http://localhost/basic.ics" {
+		t.Errorf("Wrong URL read from form. Got '%s'", nc.URL)
+	}
+}
+
+func TestProviderFieldsWithoutSettersAreStoredInValues(t *testing.T) {
+	pi := ProviderInfo{
+		NewFields:    []ProviderField{{Name: "addSite", Config: "site"}, {Name: "addURL", Config: "url", SetNew: setNewURL}},
+		UpdateFields: []ProviderField{{Name: "updSite", Config: "site"}},
+	}
+	f := url.Values{}
+	f.Set("addSite", "north")
+	f.Set("addURL", "http://localhost/basic.ics")
+	nc := NewCalConfig{}
+	pi.NewConfigFromForm(&nc, f)
+	if nc.Values["site"] != "north" || nc.URL != "http://localhost/basic.ics" {
+		t.Errorf("Wrong values read from form. Got %v '%s'", nc.Values, nc.URL)
+	}
+
+	cc := CalConfig{Values: map[string]string{"site": "north", "zone": "2"}}
+	old := cc.Values
+	f.Set("updSite", "south")
+	pi.UpdateConfigFromForm(&cc, f)
+	if cc.Values["site"] != "south" || cc.Values["zone"] != "2" {
+		t.Errorf("Wrong values read from update form. Got %v", cc.Values)
+	}
+	if old["site"] != "north" {
+		t.Error("Values of the original configuration changed")
+	}
+}
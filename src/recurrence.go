@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brumawen/ical"
+	"github.com/teambition/rrule-go"
+)
+
+// icalOccurrence holds a single occurrence of an iCal event.
+type icalOccurrence struct {
+	Event        *ical.Event // Event the occurrence belongs to
+	Start        time.Time   // Start time of the occurrence
+	End          time.Time   // End time of the occurrence
+	RecurrenceID string      // Identifies the occurrence within a recurring event, blank if not recurring
+	AllDay       bool        // Indicates the occurrence has dates without times
+}
+
+// expandICalEvents expands the events of a feed into their individual occurrences that start
+// before the end time and finish after the start time.  Recurring events are expanded using
+// their RRULE, RDATE and EXDATE properties, and modified occurrences, identified by their
+// RECURRENCE-ID, replace the occurrence they override.
+func expandICalEvents(events []*ical.Event, start time.Time, end time.Time, z *icalTimeZones) []icalOccurrence {
+	l := []icalOccurrence{}
+
+	// Find the modified occurrences of the recurring events
+	overrides := map[string]bool{}
+	for _, e := range events {
+		if p := getICalProperty(e, "RECURRENCE-ID"); p != nil {
+			if tl := parseICalTimes(p, z); len(tl) != 0 {
+				rid := formatRecurrenceID(tl[0])
+				overrides[e.UID+"|"+rid] = true
+				l = append(l, icalOccurrence{
+					Event:        e,
+					Start:        getICalStartDate(e, z),
+					End:          getICalEndDate(e, z),
+					RecurrenceID: rid,
+					AllDay:       isICalAllDay(e),
+				})
+			}
+		}
+	}
+
+	for _, e := range events {
+		if getICalProperty(e, "RECURRENCE-ID") != nil {
+			continue
+		}
+		sd := getICalStartDate(e, z)
+		ed := getICalEndDate(e, z)
+		set, err := getRecurrenceSet(e, sd, z)
+		if err != nil || set == nil {
+			// Not a recurring event
+			l = append(l, icalOccurrence{Event: e, Start: sd, End: ed, AllDay: isICalAllDay(e)})
+			continue
+		}
+		d := ed.Sub(sd)
+		allDay := isICalAllDay(e)
+		for _, st := range set.Between(start.Add(-d), end, true) {
+			rid := formatRecurrenceID(st)
+			if overrides[e.UID+"|"+rid] {
+				continue
+			}
+			et := st.Add(d)
+			if allDay {
+				// Keep all-day occurrences on whole days across daylight saving changes
+				et = st.AddDate(0, 0, daysBetween(sd, ed))
+			}
+			l = append(l, icalOccurrence{
+				Event:        e,
+				Start:        st,
+				End:          et,
+				RecurrenceID: rid,
+				AllDay:       allDay,
+			})
+		}
+	}
+	return l
+}
+
+// getRecurrenceSet returns the recurrence set for the event starting at the specified time,
+// or nil if the event does not recur.
+func getRecurrenceSet(e *ical.Event, sd time.Time, z *icalTimeZones) (*rrule.Set, error) {
+	rp := getICalProperty(e, "RRULE")
+	rdates := []time.Time{}
+	exdates := []time.Time{}
+	for _, p := range e.Properties {
+		switch p.Name {
+		case "RDATE":
+			rdates = append(rdates, parseICalTimes(p, z)...)
+		case "EXDATE":
+			exdates = append(exdates, parseICalTimes(p, z)...)
+		}
+	}
+	if rp == nil && len(rdates) == 0 {
+		return nil, nil
+	}
+
+	set := &rrule.Set{}
+	if rp != nil {
+		o, err := rrule.StrToROptionInLocation(rp.Value, sd.Location())
+		if err != nil {
+			return nil, err
+		}
+		o.Dtstart = sd
+		r, err := rrule.NewRRule(*o)
+		if err != nil {
+			return nil, err
+		}
+		set.RRule(r)
+	}
+	set.DTStart(sd)
+	// The start of the event is always the first occurrence
+	set.RDate(sd)
+	for _, t := range rdates {
+		set.RDate(t)
+	}
+	for _, t := range exdates {
+		set.ExDate(t)
+	}
+	return set, nil
+}
+
+// getICalProperty returns the first property of the event with the specified name.
+func getICalProperty(e *ical.Event, name string) *ical.Property {
+	for _, p := range e.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// getICalText returns the unescaped text value of the first property of the event with the specified name.
+func getICalText(e *ical.Event, name string) string {
+	p := getICalProperty(e, name)
+	if p == nil {
+		return ""
+	}
+	return icalTextReplacer.Replace(p.Value)
+}
+
+var icalTextReplacer = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+// getICalParam returns the first value of the named parameter of the property.
+func getICalParam(p *ical.Property, name string) string {
+	if v, ok := p.Params[name]; ok && v != nil && len(v.Values) != 0 {
+		return v.Values[0]
+	}
+	return ""
+}
+
+// isICalAllDay returns true if the event starts on a date, without a time.
+func isICalAllDay(e *ical.Event) bool {
+	p := getICalProperty(e, "DTSTART")
+	if p == nil {
+		return false
+	}
+	return getICalParam(p, "VALUE") == "DATE" || len(p.Value) == 8
+}
+
+// getICalStartDate returns the start date of the event in the time zone specified by its TZID.
+func getICalStartDate(e *ical.Event, z *icalTimeZones) time.Time {
+	if p := getICalProperty(e, "DTSTART"); p != nil {
+		if tl := parseICalTimes(p, z); len(tl) != 0 {
+			return tl[0]
+		}
+	}
+	return e.StartDate
+}
+
+// getICalEndDate returns the end date of the event in the time zone specified by its TZID.
+// If the event has no DTEND, the end is calculated from its DURATION, or the event lasts
+// for the whole day if it has a date-only start.
+func getICalEndDate(e *ical.Event, z *icalTimeZones) time.Time {
+	if p := getICalProperty(e, "DTEND"); p != nil {
+		if tl := parseICalTimes(p, z); len(tl) != 0 {
+			return tl[0]
+		}
+	}
+	if !e.EndDate.IsZero() {
+		return e.EndDate
+	}
+	sd := getICalStartDate(e, z)
+	if p := getICalProperty(e, "DURATION"); p != nil {
+		if d, err := parseICalDuration(p.Value); err == nil {
+			return sd.Add(d)
+		}
+	}
+	if p := getICalProperty(e, "DTSTART"); p != nil && len(p.Value) == 8 {
+		return sd.AddDate(0, 0, 1)
+	}
+	return sd
+}
+
+// parseICalTimes parses the comma separated list of dates and date-times of a property.
+// Date-times without a UTC designator are in the zone specified by the TZID parameter,
+// and floating date-times and dates are in the default location of the feed.
+func parseICalTimes(p *ical.Property, z *icalTimeZones) []time.Time {
+	loc := z.Location(getICalParam(p, "TZID"))
+	l := []time.Time{}
+	for _, v := range strings.Split(p.Value, ",") {
+		// Periods are specified as start/end or start/duration
+		v = strings.TrimSpace(strings.SplitN(v, "/", 2)[0])
+		var t time.Time
+		var err error
+		switch {
+		case len(v) == 8:
+			t, err = time.ParseInLocation("20060102", v, loc)
+		case strings.HasSuffix(v, "Z"):
+			t, err = time.Parse("20060102T150405Z", v)
+		default:
+			t, err = time.ParseInLocation("20060102T150405", v, loc)
+		}
+		if err == nil {
+			l = append(l, t)
+		}
+	}
+	return l
+}
+
+var icalDurationRegex = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseICalDuration parses an iCal duration (e.g. P1D, PT1H30M).
+func parseICalDuration(v string) (time.Duration, error) {
+	m := icalDurationRegex.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return 0, errors.New("Invalid duration '" + v + "'")
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	d := time.Duration(0)
+	for i, u := range units {
+		if m[i+2] != "" {
+			n, _ := strconv.Atoi(m[i+2])
+			d += time.Duration(n) * u
+		}
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// formatRecurrenceID formats the original start time of an occurrence of a recurring event.
+func formatRecurrenceID(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
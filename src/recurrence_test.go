@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brumawen/ical"
+)
+
+func newTestICalEvent(uid string, start time.Time, d time.Duration, props ...*ical.Property) *ical.Event {
+	return &ical.Event{
+		UID:        uid,
+		StartDate:  start,
+		EndDate:    start.Add(d),
+		Summary:    uid,
+		Properties: props,
+	}
+}
+
+func TestCanExpandRecurringEvents(t *testing.T) {
+	loc := time.UTC
+	st := time.Date(2018, 1, 1, 9, 0, 0, 0, loc)
+	l := []*ical.Event{
+		// Weekly meeting that started in the past, with one occurrence cancelled and one moved
+		newTestICalEvent("weekly", st, time.Hour,
+			&ical.Property{Name: "RRULE", Value: "FREQ=WEEKLY;BYDAY=MO"},
+			&ical.Property{Name: "EXDATE", Value: "20180312T090000Z"},
+			&ical.Property{Name: "RDATE", Value: "20180321T090000Z"},
+		),
+		newTestICalEvent("weekly", time.Date(2018, 3, 20, 14, 0, 0, 0, loc), time.Hour,
+			&ical.Property{Name: "RECURRENCE-ID", Value: "20180319T090000Z"},
+		),
+		// Single event
+		newTestICalEvent("single", time.Date(2018, 3, 14, 12, 0, 0, 0, loc), time.Hour),
+	}
+
+	ts := time.Date(2018, 3, 5, 0, 0, 0, 0, loc)
+	te := time.Date(2018, 3, 31, 0, 0, 0, 0, loc)
+	starts := map[string]string{}
+	for _, o := range expandICalEvents(l, ts, te, &icalTimeZones{Default: loc}) {
+		if o.Start.Before(ts) || !o.Start.Before(te) {
+			continue
+		}
+		starts[o.Start.Format("0102T1504")] = o.Event.UID + "|" + o.RecurrenceID
+	}
+
+	exp := map[string]string{
+		"0305T0900": "weekly|20180305T090000Z",
+		"0314T1200": "single|",
+		"0320T1400": "weekly|20180319T090000Z",
+		"0321T0900": "weekly|20180321T090000Z",
+		"0326T0900": "weekly|20180326T090000Z",
+	}
+	if len(starts) != len(exp) {
+		t.Errorf("Wrong number of occurrences returned. Expected %d, got %d. %v", len(exp), len(starts), starts)
+	}
+	for k, v := range exp {
+		if starts[k] != v {
+			t.Errorf("Wrong occurrence at %s. Expected '%s', got '%s'", k, v, starts[k])
+		}
+	}
+}
+
+func TestCanParseICalDuration(t *testing.T) {
+	for v, exp := range map[string]time.Duration{
+		"P1D":      24 * time.Hour,
+		"PT1H30M":  90 * time.Minute,
+		"P1W":      7 * 24 * time.Hour,
+		"-PT15M":   -15 * time.Minute,
+		"P1DT12H":  36 * time.Hour,
+		"PT45S":    45 * time.Second,
+		"P0D":      0,
+		"PT10M0S":  10 * time.Minute,
+		"P2DT0H0M": 48 * time.Hour,
+	} {
+		d, err := parseICalDuration(v)
+		if err != nil {
+			t.Error(err)
+		} else if d != exp {
+			t.Errorf("Wrong duration for %s. Expected %v, got %v", v, exp, d)
+		}
+	}
+	if _, err := parseICalDuration("1 hour"); err == nil {
+		t.Error("No error returned for an invalid duration")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReminderEngine sends reminders for the upcoming events of the calendars that have reminder rules.
+// The events are read from the event cache, which the scheduler keeps up to date.  Sent reminders are
+// recorded in the event store, so that they are not sent again after a restart.  Reminders that could
+// not be sent are tried again at each check, until the event starts.
+type ReminderEngine struct {
+	Srv      *Server       // Server the engine belongs to
+	Interval time.Duration // Time between checks for reminders that are due
+	mu       sync.Mutex
+	sent     map[string]time.Time // Reminders sent, with their expiry times, if there is no event store
+	invalid  map[string]string    // Errors already logged for invalid sink configurations
+}
+
+// NewReminderEngine creates a new reminder engine for the server, which checks for reminders every 30 seconds.
+func NewReminderEngine(s *Server) *ReminderEngine {
+	return &ReminderEngine{
+		Srv:      s,
+		Interval: 30 * time.Second,
+		sent:     map[string]time.Time{},
+		invalid:  map[string]string{},
+	}
+}
+
+// Run sends the reminders as they become due, until the exit channel is closed.
+func (e *ReminderEngine) Run(exit <-chan struct{}) {
+	t := time.NewTicker(e.Interval)
+	defer t.Stop()
+	for {
+		e.Check(time.Now())
+		select {
+		case <-exit:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Check sends the reminders that are due at the specified time, and returns the number sent.
+func (e *ReminderEngine) Check(now time.Time) int {
+	cfg := e.Srv.Config.Snapshot()
+	sinks := e.getSinks(cfg.Sinks)
+	if len(sinks) == 0 {
+		return 0
+	}
+	e.removeExpired(now)
+	loc := cfg.Location()
+	grace := e.Interval
+	if grace < time.Minute {
+		grace = time.Minute
+	}
+	n := 0
+	for _, cc := range cfg.Calendars {
+		if len(cc.Reminders) == 0 {
+			continue
+		}
+		evts, ok := e.Srv.Cache.Get(cc.ID)
+		if !ok {
+			continue
+		}
+		for _, ev := range evts.Events {
+			ev.SetLocation(loc)
+			for _, rule := range cc.Reminders {
+				due, ok := getReminderDue(ev, rule, loc)
+				if !ok || due.After(now) || isReminderLate(ev, now, grace) {
+					continue
+				}
+				r := Reminder{
+					Calendar: cc.ID,
+					Event:    ev,
+					Due:      due,
+					Title:    ev.Summary,
+					Message:  getReminderMessage(ev, now),
+				}
+				for _, name := range e.getRuleSinks(cfg.Sinks, rule, sinks) {
+					key := getReminderKey(name, cc.ID, ev, rule)
+					if e.isSent(key) {
+						continue
+					}
+					if err := e.send(sinks[name], r); err != nil {
+						e.logError(fmt.Sprintf("Error sending reminder for %s to %s. %s", ev.Summary, name, err.Error()))
+						continue
+					}
+					e.setSent(key, ev.End)
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// send sends the reminder to the sink, allowing it 10 seconds to respond.
+func (e *ReminderEngine) send(s ReminderSink, r Reminder) error {
+	ctx := e.Srv.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return s.Send(ctx, r)
+}
+
+// getSinks creates the sinks from their configurations, by name.  Sinks with invalid configurations are logged once, and left out.
+func (e *ReminderEngine) getSinks(scl []SinkConfig) map[string]ReminderSink {
+	sl := map[string]ReminderSink{}
+	for _, sc := range scl {
+		s, err := NewReminderSink(sc)
+		if err != nil {
+			e.mu.Lock()
+			if e.invalid[sc.Name] != err.Error() {
+				e.invalid[sc.Name] = err.Error()
+				e.logError(fmt.Sprintf("Invalid configuration for sink %s. %s", sc.Name, err.Error()))
+			}
+			e.mu.Unlock()
+			continue
+		}
+		sl[sc.Name] = s
+	}
+	return sl
+}
+
+// getRuleSinks returns the names of the sinks that the reminders of the rule are sent to.
+func (e *ReminderEngine) getRuleSinks(scl []SinkConfig, rule ReminderRule, sinks map[string]ReminderSink) []string {
+	l := []string{}
+	if len(rule.Sinks) == 0 {
+		for _, sc := range scl {
+			if _, ok := sinks[sc.Name]; ok {
+				l = append(l, sc.Name)
+			}
+		}
+		return l
+	}
+	for _, n := range rule.Sinks {
+		for name := range sinks {
+			if strings.EqualFold(n, name) {
+				l = append(l, name)
+			}
+		}
+	}
+	return l
+}
+
+// isSent returns true if the reminder with the key has been sent.
+func (e *ReminderEngine) isSent(key string) bool {
+	if st := e.Srv.Store; st != nil {
+		sent, err := st.IsReminderSent(key)
+		if err != nil {
+			e.logError("Error reading sent reminders.", err.Error())
+			// Rather miss a reminder than send it repeatedly
+			return true
+		}
+		return sent
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.sent[key]
+	return ok
+}
+
+// setSent records that the reminder with the key has been sent.
+func (e *ReminderEngine) setSent(key string, expires time.Time) {
+	if st := e.Srv.Store; st != nil {
+		if err := st.SetReminderSent(key, expires); err != nil {
+			e.logError("Error recording sent reminder.", err.Error())
+		}
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sent[key] = expires
+}
+
+// removeExpired removes the records of the sent reminders that expired before the specified time.
+func (e *ReminderEngine) removeExpired(now time.Time) {
+	if st := e.Srv.Store; st != nil {
+		if err := st.RemoveExpiredReminders(now); err != nil {
+			e.logError("Error removing expired reminders.", err.Error())
+		}
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for k, t := range e.sent {
+		if t.Before(now) {
+			delete(e.sent, k)
+		}
+	}
+}
+
+// getReminderDue returns the time that the reminder for the event is due.  False is returned if the
+// rule does not apply to the event.
+func getReminderDue(ev CalEvent, rule ReminderRule, loc *time.Location) (time.Time, bool) {
+	if !ev.AllDay {
+		if rule.At != "" {
+			return time.Time{}, false
+		}
+		return ev.Start.Add(-time.Duration(rule.Before) * time.Minute), true
+	}
+	if rule.At == "" {
+		return time.Time{}, false
+	}
+	at, err := time.Parse("15:04", rule.At)
+	if err != nil {
+		return time.Time{}, false
+	}
+	y, m, d := ev.Start.Date()
+	return time.Date(y, m, d-rule.Days, at.Hour(), at.Minute(), 0, 0, loc), true
+}
+
+// isReminderLate returns true if it is too late to send a reminder for the event, because the event
+// started longer than the grace period ago, or for all-day events, has finished.  The grace period
+// allows the reminders that are due when events start to be sent.
+func isReminderLate(ev CalEvent, now time.Time, grace time.Duration) bool {
+	if ev.AllDay {
+		return !now.Before(ev.End)
+	}
+	return !now.Before(ev.Start.Add(grace))
+}
+
+// getReminderKey returns the key that identifies the reminder sent to a sink for an occurrence of the
+// event.  Events that are moved are reminded again.
+func getReminderKey(sink string, id string, ev CalEvent, rule ReminderRule) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d/%s/%d", sink, id, getStoreKey(ev), ev.Start.UTC().Format(time.RFC3339), rule.Before, rule.At, rule.Days)
+}
+
+// getReminderMessage returns the message of the reminder for the event, with its day, time and location.
+func getReminderMessage(ev CalEvent, now time.Time) string {
+	day := getEventDateName(ev)
+	ny, nm, nd := now.In(ev.Start.Location()).Date()
+	switch y, m, d := ev.Start.Date(); {
+	case y == ny && m == nm && d == nd:
+		day = "Today"
+	case time.Date(ny, nm, nd+1, 0, 0, 0, 0, time.UTC).Equal(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)):
+		day = "Tomorrow"
+	}
+	s := day + " " + getEventTimeText(ev)
+	if ev.Location != "" {
+		s += " @ " + ev.Location
+	}
+	return s
+}
+
+// logError logs an error message to the logger
+func (e *ReminderEngine) logError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("ReminderEngine: [Err] ", a[1:len(a)-1])
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSinkReceiver records the requests sent to it by the HTTP sinks.
+type testSinkReceiver struct {
+	mu     sync.Mutex
+	bodies []string
+	titles []string
+}
+
+func (rc *testSinkReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies = append(rc.bodies, string(b))
+	rc.titles = append(rc.titles, r.Header.Get("Title"))
+}
+
+func TestReminderDueTimes(t *testing.T) {
+	loc, _ := time.LoadLocation("Africa/Johannesburg")
+	st := time.Date(2018, 3, 2, 10, 0, 0, 0, loc)
+	timed := CalEvent{Start: st, End: st.Add(time.Hour)}
+	allDay := CalEvent{Start: time.Date(2018, 3, 2, 0, 0, 0, 0, loc), End: time.Date(2018, 3, 3, 0, 0, 0, 0, loc), AllDay: true}
+	for _, i := range []struct {
+		Event CalEvent
+		Rule  ReminderRule
+		Exp   time.Time
+		OK    bool
+	}{
+		{timed, ReminderRule{Before: 15}, time.Date(2018, 3, 2, 9, 45, 0, 0, loc), true},
+		{timed, ReminderRule{Before: 0}, st, true},
+		{timed, ReminderRule{At: "08:00"}, time.Time{}, false},
+		{allDay, ReminderRule{At: "08:00"}, time.Date(2018, 3, 2, 8, 0, 0, 0, loc), true},
+		{allDay, ReminderRule{At: "18:30", Days: 1}, time.Date(2018, 3, 1, 18, 30, 0, 0, loc), true},
+		{allDay, ReminderRule{Before: 15}, time.Time{}, false},
+		{allDay, ReminderRule{At: "8am"}, time.Time{}, false},
+	} {
+		due, ok := getReminderDue(i.Event, i.Rule, loc)
+		if ok != i.OK || !due.Equal(i.Exp) {
+			t.Errorf("Wrong due time for rule %+v. Expected %v %t, got %v %t", i.Rule, i.Exp, i.OK, due, ok)
+		}
+	}
+}
+
+func TestRemindersAreSentOnce(t *testing.T) {
+	rc := &testSinkReceiver{}
+	rs := httptest.NewServer(rc)
+	defer rs.Close()
+
+	rules := []ReminderRule{{Before: 15, Sinks: []string{"phone"}}, {At: "08:00"}}
+	s, _ := newTestServer(CalConfig{ID: "testremind", Name: "Home", Provider: "Test", Reminders: rules})
+	s.Config.TimeZone = "UTC"
+	s.Config.Sinks = []SinkConfig{
+		{Name: "phone", Type: "ntfy", URL: rs.URL + "/calendar"},
+		{Name: "hook", Type: "webhook", URL: rs.URL + "/hook"},
+		{Name: "broken", Type: "pigeon"},
+	}
+	st, done := newTestEventStore(t)
+	defer done()
+	s.Store = st
+
+	day := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	evts := NewCalEvents(day, day.AddDate(0, 0, 7))
+	evts.Events = []CalEvent{
+		{ID: "testremind", UID: "dentist", Summary: "Dentist", Location: "Town", Start: day.Add(10 * time.Hour), End: day.Add(11 * time.Hour)},
+		{ID: "testremind", UID: "holiday", Summary: "Holiday", Start: day.AddDate(0, 0, 1), End: day.AddDate(0, 0, 2), AllDay: true},
+	}
+	s.Cache.Set("testremind", evts)
+
+	e := NewReminderEngine(s)
+	for _, i := range []struct {
+		Time time.Time
+		Exp  int
+	}{
+		{day.Add(9 * time.Hour), 0},
+		{day.Add(9*time.Hour + 50*time.Minute), 1},
+		{day.Add(9*time.Hour + 55*time.Minute), 0},
+		{day.Add(10*time.Hour + 5*time.Minute), 0},
+		{day.Add(32 * time.Hour), 2},
+	} {
+		if n := e.Check(i.Time); n != i.Exp {
+			t.Errorf("Wrong number of reminders sent at %v. Expected %d, got %d", i.Time, i.Exp, n)
+		}
+	}
+	if len(rc.bodies) != 3 || rc.titles[0] != "Dentist" || rc.bodies[0] != "Today 10:00 (1h) @ Town" {
+		t.Errorf("Wrong reminders sent. %q %q", rc.titles, rc.bodies)
+	}
+
+	// Sent reminders are not sent again after a restart
+	if n := NewReminderEngine(s).Check(day.Add(32 * time.Hour)); n != 0 {
+		t.Errorf("Reminders sent again after a restart. Got %d", n)
+	}
+}
+
+func TestRemindersAreSentWhenEventsStart(t *testing.T) {
+	rc := &testSinkReceiver{}
+	rs := httptest.NewServer(rc)
+	defer rs.Close()
+
+	s, _ := newTestServer(CalConfig{ID: "teststart", Name: "Home", Provider: "Test", Reminders: []ReminderRule{{Before: 0}}})
+	s.Config.TimeZone = "UTC"
+	s.Config.Sinks = []SinkConfig{{Name: "phone", Type: "ntfy", URL: rs.URL + "/calendar"}}
+	st := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	evts := NewCalEvents(st, st.AddDate(0, 0, 1))
+	evts.Events = []CalEvent{{ID: "teststart", UID: "dentist", Summary: "Dentist", Start: st, End: st.Add(time.Hour)}}
+	s.Cache.Set("teststart", evts)
+
+	// The reminder is sent at the first check after the event starts, within the grace period
+	e := NewReminderEngine(s)
+	if n := e.Check(st.Add(20 * time.Second)); n != 1 {
+		t.Errorf("Reminder not sent when the event started. Got %d", n)
+	}
+	if n := NewReminderEngine(s).Check(st.Add(2 * time.Minute)); n != 0 {
+		t.Errorf("Reminder sent after the grace period. Got %d", n)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reminder holds the details of a reminder for an upcoming event.
+type Reminder struct {
+	Calendar string    `json:"calendar"` // Identifier of the calendar
+	Event    CalEvent  `json:"event"`    // Event the reminder is for
+	Due      time.Time `json:"due"`      // Time the reminder was due to be sent
+	Title    string    `json:"title"`    // Title of the reminder, which is the summary of the event
+	Message  string    `json:"message"`  // Message of the reminder, with the day, time and location of the event
+}
+
+// ReminderSink defines an interface for the sinks that reminders are sent to.
+type ReminderSink interface {
+	Send(ctx context.Context, r Reminder) error
+}
+
+var (
+	sinkTypesMu sync.RWMutex
+	sinkTypes   = map[string]func(SinkConfig) (ReminderSink, error){}
+)
+
+// RegisterSinkType registers a type of reminder sink.  The function creates a sink from its configuration,
+// and returns an error if the configuration is not valid.
+func RegisterSinkType(name string, f func(SinkConfig) (ReminderSink, error)) {
+	sinkTypesMu.Lock()
+	defer sinkTypesMu.Unlock()
+	sinkTypes[name] = f
+}
+
+// NewReminderSink creates the reminder sink for the configuration.
+func NewReminderSink(c SinkConfig) (ReminderSink, error) {
+	sinkTypesMu.RLock()
+	f, ok := sinkTypes[strings.ToLower(c.Type)]
+	sinkTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Invalid sink type '%s'.  Use %s", c.Type, strings.Join(GetSinkTypes(), ", "))
+	}
+	if c.Name == "" {
+		return nil, errors.New("Name must be specified")
+	}
+	return f(c)
+}
+
+// GetSinkTypes returns the names of the registered sink types.
+func GetSinkTypes() []string {
+	sinkTypesMu.RLock()
+	defer sinkTypesMu.RUnlock()
+	l := []string{}
+	for n := range sinkTypes {
+		l = append(l, n)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// maskSinks returns a copy of the sinks without their passwords, tokens and secrets.
+func maskSinks(l []SinkConfig) []SinkConfig {
+	if l == nil {
+		return nil
+	}
+	ml := make([]SinkConfig, len(l))
+	for i, sc := range l {
+		sc.Password = ""
+		sc.Token = ""
+		sc.Secret = ""
+		ml[i] = sc
+	}
+	return ml
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// newTestMQTTBroker starts an embedded MQTT broker listening on the address, and returns it with its URL.
+func newTestMQTTBroker(t *testing.T, addr string) (*mochi.Server, string) {
+	b := mochi.New(&mochi.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(ioutil.Discard, nil))})
+	if err := b.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	l := listeners.NewTCP(listeners.Config{ID: "test", Address: addr})
+	if err := b.AddListener(l); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	return b, "tcp://" + l.Address()
+}
+
+// newTestSMTPServer starts a minimal SMTP server that accepts one email, and returns its address
+// and a channel that receives the email.
+func newTestSMTPServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		msg := ""
+		for {
+			ln, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(ln)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				conn.Write([]byte("250-localhost\r\n250 8BITMIME\r\n"))
+			case cmd == "DATA":
+				conn.Write([]byte("354 Go ahead\r\n"))
+				for {
+					ln, err := r.ReadString('\n')
+					if err != nil || ln == ".\r\n" {
+						break
+					}
+					msg += ln
+				}
+				conn.Write([]byte("250 OK\r\n"))
+			case cmd == "QUIT":
+				conn.Write([]byte("221 Bye\r\n"))
+				ch <- msg
+				return
+			default:
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func TestCanSendReminderByEmail(t *testing.T) {
+	addr, ch := newTestSMTPServer(t)
+	s, err := NewReminderSink(SinkConfig{Name: "email", Type: "smtp", URL: "smtp://" + addr, From: "calendar@example.com", To: []string{"me@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, Reminder{Title: "Dentist", Message: "Today 10:00 (1h)"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-ch
+	if !strings.Contains(msg, "Subject: Reminder: Dentist\r\n") || !strings.Contains(msg, "\r\n\r\nToday 10:00 (1h)\r\n") {
+		t.Errorf("Wrong email sent. %q", msg)
+	}
+
+	if _, err := NewReminderSink(SinkConfig{Name: "email", Type: "smtp", URL: "smtp://" + addr}); err == nil {
+		t.Error("No error returned for a sink without addresses")
+	}
+}
+
+func TestCanSendReminderToMQTT(t *testing.T) {
+	b, u := newTestMQTTBroker(t, "127.0.0.1:0")
+	defer b.Close()
+	ch := make(chan []byte, 1)
+	b.Subscribe("calendar/#", 1, func(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+		ch <- pk.Payload
+	})
+
+	s, err := NewReminderSink(SinkConfig{Name: "mqtt", Type: "mqtt", URL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, Reminder{Calendar: "testmqtt", Title: "Dentist"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-ch:
+		r := Reminder{}
+		if err := json.Unmarshal(p, &r); err != nil || r.Title != "Dentist" {
+			t.Errorf("Wrong reminder published. %s", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Reminder not published")
+	}
+}
+
+// testSinkRequest holds the details of a request received from an HTTP sink.
+type testSinkRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// newTestSinkServer starts a server that returns the status, and sends the requests received to the channel.
+func newTestSinkServer(status int) (*httptest.Server, chan testSinkRequest) {
+	ch := make(chan testSinkRequest, 1)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		ch <- testSinkRequest{Header: r.Header, Body: b}
+		w.WriteHeader(status)
+	})), ch
+}
+
+func TestCanSendReminderToWebhook(t *testing.T) {
+	rs, ch := newTestSinkServer(http.StatusOK)
+	defer rs.Close()
+
+	s, err := NewReminderSink(SinkConfig{Name: "hook", Type: "webhook", URL: rs.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, Reminder{Calendar: "testhook", Title: "Dentist", Message: "Today 10:00 (1h)"}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-ch
+	r := Reminder{}
+	if err := json.Unmarshal(req.Body, &r); err != nil || r.Calendar != "testhook" || r.Title != "Dentist" || r.Message != "Today 10:00 (1h)" {
+		t.Errorf("Wrong reminder posted. %s", req.Body)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Wrong content type. Got '%s'", ct)
+	}
+	if ev := req.Header.Get("X-Calendar-Event"); ev != "reminder" {
+		t.Errorf("Wrong event header. Got '%s'", ev)
+	}
+	if sig := req.Header.Get("X-Calendar-Signature"); sig == "" || sig != signWebhookPayload("s3cret", req.Body) {
+		t.Errorf("Wrong signature sent. Got '%s'", sig)
+	}
+
+	// Reminders are not signed without a secret
+	s, _ = NewReminderSink(SinkConfig{Name: "hook", Type: "webhook", URL: rs.URL})
+	if err := s.Send(ctx, Reminder{Title: "Dentist"}); err != nil {
+		t.Fatal(err)
+	}
+	if req = <-ch; req.Header.Get("X-Calendar-Signature") != "" {
+		t.Error("Reminder signed without a secret")
+	}
+}
+
+func TestCanSendReminderToNtfy(t *testing.T) {
+	rs, ch := newTestSinkServer(http.StatusOK)
+	defer rs.Close()
+
+	s, err := NewReminderSink(SinkConfig{Name: "phone", Type: "ntfy", URL: rs.URL + "/calendar", Token: "tk_123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, Reminder{Title: "Dentist", Message: "Today 10:00 (1h)"}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-ch
+	if string(req.Body) != "Today 10:00 (1h)" {
+		t.Errorf("Wrong message published. Got '%s'", req.Body)
+	}
+	if ct := req.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Wrong content type. Got '%s'", ct)
+	}
+	if title := req.Header.Get("Title"); title != "Dentist" {
+		t.Errorf("Wrong title. Got '%s'", title)
+	}
+	if a := req.Header.Get("Authorization"); a != "Bearer tk_123" {
+		t.Errorf("Wrong authorization. Got '%s'", a)
+	}
+
+	// The user name and password are used if there is no token
+	s, _ = NewReminderSink(SinkConfig{Name: "phone", Type: "ntfy", URL: rs.URL + "/calendar", Username: "me", Password: "pw"})
+	if err := s.Send(ctx, Reminder{Title: "Dentist"}); err != nil {
+		t.Fatal(err)
+	}
+	req = <-ch
+	if u, p, ok := (&http.Request{Header: req.Header}).BasicAuth(); !ok || u != "me" || p != "pw" {
+		t.Errorf("Wrong authorization. Got '%s'", req.Header.Get("Authorization"))
+	}
+}
+
+func TestHTTPSinkErrorsAreReturned(t *testing.T) {
+	rs, ch := newTestSinkServer(http.StatusForbidden)
+	defer rs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, typ := range []string{"webhook", "ntfy"} {
+		s, err := NewReminderSink(SinkConfig{Name: typ, Type: typ, URL: rs.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Send(ctx, Reminder{Title: "Dentist"}); err == nil {
+			t.Errorf("No error returned by the %s sink for a rejected reminder", typ)
+		}
+		<-ch
+		if _, err := NewReminderSink(SinkConfig{Name: typ, Type: typ, URL: "ftp://example.com"}); err == nil {
+			t.Errorf("No error returned by the %s sink for an invalid URL", typ)
+		}
+	}
+}
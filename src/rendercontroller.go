@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RenderController handles the Web Methods that draw the agenda as an image, for e-paper displays.
+type RenderController struct {
+	Srv *Server
+}
+
+// renderAccents lists the accent colours of 3 colour panels.
+var renderAccents = map[string]color.RGBA{
+	"red":    {R: 255, A: 255},
+	"yellow": {R: 255, G: 255, A: 255},
+}
+
+// AddController adds the controller routes to the router
+func (c *RenderController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/calendar/render.png").Name("RenderPNG").
+		Handler(Logger(c, http.HandlerFunc(c.handleRenderPNG)))
+	router.Methods("GET").Path("/calendar/render.svg").Name("RenderSVG").
+		Handler(Logger(c, http.HandlerFunc(c.handleRenderSVG)))
+}
+
+func (c *RenderController) handleRenderPNG(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, "image/png", drawAgendaPNG)
+}
+
+func (c *RenderController) handleRenderSVG(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, "image/svg+xml", drawAgendaSVG)
+}
+
+// render lays out the agenda for the image options in the query string, and writes it to the http response
+// with the draw function.
+func (c *RenderController) render(w http.ResponseWriter, r *http.Request, contentType string, draw func(imageOptions, []imageItem) ([]byte, error)) {
+	o, err := c.getImageOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	lw, lh, err := o.validate()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	noDays := 7
+	if d := r.URL.Query().Get("days"); d != "" {
+		if noDays, err = strconv.Atoi(d); err != nil || noDays < 1 || noDays > 31 {
+			http.Error(w, fmt.Sprintf("Invalid number of days '%s'", d), 500)
+			return
+		}
+	}
+
+	cal := &CalendarController{Srv: c.Srv}
+	q, err := cal.getEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	now := time.Now().In(q.Location)
+	q.Start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, q.Location)
+	q.End = q.Start.AddDate(0, 0, noDays)
+	q.SplitDays = true
+	el, _ := cal.getEvents(r.Context(), q)
+
+	items, err := layoutAgendaImage(o, lw, lh, getAgendaDays(q.Start, noDays, el), now)
+	if err != nil {
+		m := fmt.Sprintf("Error laying out agenda image. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	b, err := draw(o, items)
+	if err != nil {
+		m := fmt.Sprintf("Error drawing agenda image. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	w.Header().Set("content-type", contentType)
+	w.Write(b)
+}
+
+// getImageOptions reads the image options from the query string.  The default is an 800x480 black and white image.
+func (c *RenderController) getImageOptions(r *http.Request) (imageOptions, error) {
+	o := imageOptions{Width: 800, Height: 480, Depth: 1, Accent: renderAccents["red"]}
+	qs := r.URL.Query()
+	for _, i := range []struct {
+		Name  string
+		Value *int
+	}{
+		{"width", &o.Width},
+		{"height", &o.Height},
+		{"depth", &o.Depth},
+		{"rotate", &o.Rotate},
+	} {
+		if v := qs.Get(i.Name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return o, fmt.Errorf("Invalid %s '%s'", i.Name, v)
+			}
+			*i.Value = n
+		}
+	}
+	if v := qs.Get("accent"); v != "" {
+		a, ok := renderAccents[strings.ToLower(v)]
+		if !ok {
+			return o, fmt.Errorf("Invalid accent colour '%s'.  Use red or yellow", v)
+		}
+		o.Accent = a
+	}
+	return o, nil
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *RenderController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("RenderController: [Inf] ", a[1:len(a)-1])
+}
+
+// LogError is used to log error messages for this controller.
+func (c *RenderController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("RenderController: [Err] ", a[1:len(a)-1])
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Scheduler refreshes the events of each configured calendar in the background,
+// on the calendar's own refresh interval, and keeps them in the event cache.
+type Scheduler struct {
+	Srv     *Server       // Server the scheduler belongs to
+	Cache   *EventCache   // Cache holding the retrieved events
+	Timeout time.Duration // Time each provider is given to respond to a background refresh
+	mu      sync.Mutex
+	next    map[string]time.Time // Time of the next refresh for each calendar
+	refresh chan struct{}        // Signals that the schedule must be re-evaluated
+}
+
+// NewScheduler creates a new scheduler that stores its events in the specified cache.
+// Providers are given 2 minutes to respond to the background refreshes.
+func NewScheduler(s *Server, c *EventCache) *Scheduler {
+	return &Scheduler{
+		Srv:     s,
+		Cache:   c,
+		Timeout: 2 * time.Minute,
+		next:    map[string]time.Time{},
+		refresh: make(chan struct{}, 1),
+	}
+}
+
+// Run refreshes the calendars until the exit channel is closed.
+func (s *Scheduler) Run(exit <-chan struct{}) {
+	s.logInfo("Scheduler started")
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-exit:
+			s.logInfo("Scheduler stopped")
+			return
+		case <-t.C:
+		case <-s.refresh:
+			if !t.Stop() {
+				select {
+				case <-t.C:
+				default:
+				}
+			}
+		}
+		s.refreshDue()
+		t.Reset(s.untilNext())
+	}
+}
+
+// Invalidate forces the specified calendar to be refreshed as soon as possible.
+func (s *Scheduler) Invalidate(id string) {
+	s.mu.Lock()
+	delete(s.next, id)
+	s.mu.Unlock()
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Remove removes the specified calendar from the schedule, the cache and the event store, and removes
+// the last events file written by older versions.
+func (s *Scheduler) Remove(id string) {
+	s.mu.Lock()
+	delete(s.next, id)
+	s.mu.Unlock()
+	s.Cache.Remove(id)
+	if st := s.Srv.Store; st != nil {
+		if err := st.Remove(id); err != nil {
+			s.logError(fmt.Sprintf("Error removing calendar %s from the event store. %s", id, err.Error()))
+		}
+	}
+	os.Remove(getLastEventsFileName(id))
+}
+
+// FetchResult holds the result of retrieving the events for a calendar.
+type FetchResult struct {
+	CalConfig CalConfig     // Calendar the events were retrieved for
+	Events    CalEvents     // Events retrieved
+	Fallback  bool          // Indicates that the events were read from the last saved events
+	Changes   []EventChange // Changes found from the events stored before, if the events were stored
+	Err       error         // Error returned by the provider, if any
+	TimedOut  bool          // Indicates that the provider did not respond in time
+}
+
+// FetchCalendars retrieves the events between the start and end times for the calendars concurrently,
+// for a request.  Each provider is cancelled if it does not respond within the server timeout, or when
+// the context is cancelled.  Calendars that fail fall back to their last saved events.  If store is set,
+// the events are stored in the cache, and the calendars that did not respond in time are left to the
+// background refresh, which gives them longer.
+func (s *Scheduler) FetchCalendars(ctx context.Context, cals []CalConfig, start time.Time, end time.Time, store bool) []FetchResult {
+	res := s.fetchCalendars(ctx, cals, start, end, store, time.Duration(s.Srv.Timeout)*time.Second)
+	if store {
+		for _, r := range res {
+			if r.TimedOut {
+				s.Invalidate(r.CalConfig.ID)
+			}
+		}
+	}
+	return res
+}
+
+// fetchCalendars retrieves the events for the calendars concurrently, giving each provider the timeout to respond.
+func (s *Scheduler) fetchCalendars(ctx context.Context, cals []CalConfig, start time.Time, end time.Time, store bool, timeout time.Duration) []FetchResult {
+	res := make([]FetchResult, len(cals))
+	var wg sync.WaitGroup
+	for n, cc := range cals {
+		if store {
+			s.mu.Lock()
+			s.next[cc.ID] = time.Now().Add(s.getInterval(cc))
+			s.mu.Unlock()
+		}
+		wg.Add(1)
+		go func(n int, cc CalConfig) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			res[n] = s.fetch(pctx, cc, start, end, store)
+			res[n].TimedOut = res[n].Err != nil && pctx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		}(n, cc)
+	}
+	wg.Wait()
+	return res
+}
+
+// fetch retrieves the events for the calendar from its provider.
+func (s *Scheduler) fetch(ctx context.Context, cc CalConfig, start time.Time, end time.Time, store bool) FetchResult {
+	r := FetchResult{CalConfig: cc}
+	p, err := NewCalendarProvider(cc.Provider)
+	if err == nil {
+		if cc.TimeZone == "" {
+			cc.TimeZone = s.Srv.Config.TimeZone
+		}
+		p.SetConfig(cc)
+		r.Events, err = p.GetEvents(ctx, start, end)
+		if a, ok := p.(RefreshAdvisor); ok && err == nil && store {
+			if d := s.getAdvisedInterval(cc, a.GetRefreshInterval()); d > 0 {
+				s.mu.Lock()
+				s.next[cc.ID] = time.Now().Add(d)
+				s.mu.Unlock()
+			}
+		}
+	}
+	if err != nil {
+		r.Err = err
+		r.Fallback = true
+		r.Events, _ = readLastEvents(s.Srv.Store, cc.ID)
+		return r
+	}
+	if store {
+		s.Cache.Set(cc.ID, r.Events)
+		if st := s.Srv.Store; st != nil {
+			if r.Changes, err = st.Update(cc.ID, r.Events, s.Srv.Config.ChangeDays); err != nil {
+				s.logError(fmt.Sprintf("Error storing the events of calendar %s. %s", cc.Name, err.Error()))
+			} else if len(r.Changes) != 0 {
+				s.logInfo(fmt.Sprintf("Found %d changes to calendar %s", len(r.Changes), cc.Name))
+				if s.Srv.Webhooks != nil {
+					s.Srv.Webhooks.Send(r.Changes)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// refreshDue refreshes all the calendars that are due for a refresh.
+func (s *Scheduler) refreshDue() {
+	now := time.Now()
+	due := []CalConfig{}
+	for _, cc := range s.Srv.Config.Snapshot().Calendars {
+		s.mu.Lock()
+		n, ok := s.next[cc.ID]
+		s.mu.Unlock()
+		if !ok || !n.After(now) {
+			s.logDebug("Refreshing calendar", cc.Name)
+			due = append(due, cc)
+		}
+	}
+	start, end := s.cacheWindow()
+	for _, r := range s.fetchCalendars(s.Srv.ctx, due, start, end, true, s.Timeout) {
+		if r.Err != nil {
+			s.logError(fmt.Sprintf("Error refreshing calendar %s. %s", r.CalConfig.Name, r.Err.Error()))
+		}
+	}
+}
+
+// cacheWindow returns the time window of the events held in the cache.  The window starts at midnight in
+// the default time zone, rather than at the time of the refresh, and covers the weeks shown for the current
+// month as well as the cached days, so that the views that start at midnight are served from the cache.
+func (s *Scheduler) cacheWindow() (time.Time, time.Time) {
+	now := time.Now().In(s.Srv.Config.Location())
+	y, m, d := now.Date()
+	first := time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	start := first.AddDate(0, 0, -6)
+	end := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).AddDate(0, 0, s.Srv.Config.CacheDays)
+	if me := first.AddDate(0, 1, 6); me.After(end) {
+		end = me
+	}
+	return start, end
+}
+
+// untilNext returns the duration until the next calendar is due for a refresh.
+func (s *Scheduler) untilNext() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := time.Duration(s.Srv.Config.RefreshInterval) * time.Minute
+	for _, n := range s.next {
+		if u := time.Until(n); u < d {
+			d = u
+		}
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// getInterval returns the refresh interval for the specified calendar.
+func (s *Scheduler) getInterval(cc CalConfig) time.Duration {
+	if cc.RefreshInterval > 0 {
+		return time.Duration(cc.RefreshInterval) * time.Minute
+	}
+	return time.Duration(s.Srv.Config.RefreshInterval) * time.Minute
+}
+
+// getAdvisedInterval returns the refresh interval to use for the calendar when its source specifies
+// an interval.  The source interval is only used if the calendar does not have its own refresh interval,
+// and never causes the calendar to be refreshed more often than the configured refresh interval.
+// Zero is returned if the source interval does not apply.
+func (s *Scheduler) getAdvisedInterval(cc CalConfig, d time.Duration) time.Duration {
+	if d <= 0 || cc.RefreshInterval > 0 {
+		return 0
+	}
+	if min := time.Duration(s.Srv.Config.RefreshInterval) * time.Minute; d < min {
+		return min
+	}
+	return d
+}
+
+// logDebug logs a debug message to the logger
+func (s *Scheduler) logDebug(v ...interface{}) {
+	if s.Srv.VerboseLogging {
+		a := fmt.Sprint(v)
+		logger.Info("Scheduler: [Dbg] ", a[1:len(a)-1])
+	}
+}
+
+// logInfo logs an information message to the logger
+func (s *Scheduler) logInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("Scheduler: [Inf] ", a[1:len(a)-1])
+}
+
+// logError logs an error message to the logger
+func (s *Scheduler) logError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("Scheduler: [Err] ", a[1:len(a)-1])
+}
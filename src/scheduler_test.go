@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// testProvider is a calendar provider that returns a single event after a delay.  The event starts an hour
+// after the start of the window, or an hour from now if the window includes the current time.
+type testProvider struct {
+	CalConfig CalConfig
+}
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name: "Test",
+		New:  func() CalendarProvider { return new(testProvider) },
+	})
+}
+
+func (p *testProvider) GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error) {
+	evts := NewCalEvents(start, end)
+	if p.CalConfig.URL == "slow" {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return evts, ctx.Err()
+		}
+	}
+	st := evts.Start.Add(time.Hour)
+	if now := time.Now(); now.After(start) && now.Before(end) {
+		st = now.Truncate(time.Minute).Add(time.Hour)
+	}
+	evts.Events = []CalEvent{{ID: p.CalConfig.ID, Summary: "Live", Start: st, End: st.Add(time.Hour)}}
+	return evts, nil
+}
+
+func (p *testProvider) ProviderName() string                                { return "Test" }
+func (p *testProvider) SetConfig(c CalConfig)                               { p.CalConfig = c }
+func (p *testProvider) RemovedConfig(c CalConfig) error                     { return nil }
+func (p *testProvider) ValidateConfig(c CalConfig) (CalConfig, error)       { return c, nil }
+func (p *testProvider) ValidateNewConfig(c NewCalConfig) (CalConfig, error) { return CalConfig{}, nil }
+
+func TestSlowProvidersFallBack(t *testing.T) {
+	snap := CalEvents{
+		Created: time.Now(),
+		Events:  []CalEvent{{ID: "testslow", Summary: "Snapshot"}},
+	}
+	fn := getLastEventsFileName("testslow")
+	if err := snap.WriteToFile(fn); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fn)
+
+	s := &Server{Timeout: 1, Config: &Config{}}
+	s.Config.SetDefaults()
+	sc := NewScheduler(s, NewEventCache())
+	rl := sc.FetchCalendars(context.Background(), []CalConfig{
+		{ID: "testfast", Provider: "Test"},
+		{ID: "testslow", Provider: "Test", URL: "slow"},
+	}, time.Now(), time.Now().AddDate(0, 0, 1), true)
+
+	if len(rl) != 2 {
+		t.Fatalf("Wrong number of results returned. Expected %d, got %d", 2, len(rl))
+	}
+	if rl[0].Fallback || rl[0].Events.Events[0].Summary != "Live" {
+		t.Error("Fast provider was not served live")
+	}
+	if !rl[1].Fallback || rl[1].Events.Events[0].Summary != "Snapshot" {
+		t.Error("Slow provider did not fall back to the last saved events")
+	}
+	if _, ok := sc.Cache.Get("testfast"); !ok {
+		t.Error("Live events were not cached")
+	}
+	if _, ok := sc.next["testslow"]; !rl[1].TimedOut || ok {
+		t.Error("Slow provider was not left to the background refresh")
+	}
+}
+
+func TestProvidersFallBackToTheEventStore(t *testing.T) {
+	st, done := newTestEventStore(t)
+	defer done()
+	snap := CalEvents{Created: time.Now(), Events: []CalEvent{{ID: "testslowstore", UID: "snapshot", Summary: "Stored"}}}
+	if _, err := st.Update("testslowstore", snap, 30); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Timeout: 1, Config: &Config{}, Store: st}
+	s.Config.SetDefaults()
+	sc := NewScheduler(s, NewEventCache())
+	rl := sc.FetchCalendars(context.Background(), []CalConfig{{ID: "testslowstore", Provider: "Test", URL: "slow"}}, time.Now(), time.Now().AddDate(0, 0, 1), false)
+	if !rl[0].Fallback || len(rl[0].Events.Events) != 1 || rl[0].Events.Events[0].Summary != "Stored" {
+		t.Errorf("Slow provider did not fall back to the stored events. %+v", rl[0].Events.Events)
+	}
+}
+
+func TestBackgroundRefreshesAllowSlowProviders(t *testing.T) {
+	s := &Server{Timeout: 1, Config: &Config{Calendars: []CalConfig{{ID: "testslowrefresh", Provider: "Test", URL: "slow"}}}, ctx: context.Background()}
+	s.Config.SetDefaults()
+	sc := NewScheduler(s, NewEventCache())
+	s.Scheduler = sc
+	sc.refreshDue()
+	if evts, ok := sc.Cache.Get("testslowrefresh"); !ok || len(evts.Events) != 1 || evts.Events[0].Summary != "Live" {
+		t.Error("Slow provider was not refreshed in the background")
+	}
+}
+
+func TestFetchesAreCancelled(t *testing.T) {
+	s := &Server{Timeout: 10, Config: &Config{}}
+	s.Config.SetDefaults()
+	sc := NewScheduler(s, NewEventCache())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	st := time.Now()
+	rl := sc.FetchCalendars(ctx, []CalConfig{{ID: "testcancel", Provider: "Test", URL: "slow"}}, time.Now(), time.Now().AddDate(0, 0, 1), false)
+	if time.Since(st) > time.Second {
+		t.Error("Fetch was not cancelled")
+	}
+	if rl[0].Err != context.Canceled {
+		t.Errorf("Wrong error returned. Expected %v, got %v", context.Canceled, rl[0].Err)
+	}
+}
+
+func TestAdvisedRefreshIntervalIsLimited(t *testing.T) {
+	s := &Server{Config: &Config{}}
+	s.Config.SetDefaults()
+	sc := NewScheduler(s, NewEventCache())
+	week := 7 * 24 * time.Hour
+	for _, i := range []struct {
+		CalConfig CalConfig
+		Advised   time.Duration
+		Exp       time.Duration
+	}{
+		{CalConfig{}, 0, 0},
+		{CalConfig{}, week, week},
+		{CalConfig{}, time.Minute, 15 * time.Minute},
+		{CalConfig{RefreshInterval: 60}, week, 0},
+	} {
+		if d := sc.getAdvisedInterval(i.CalConfig, i.Advised); d != i.Exp {
+			t.Errorf("Wrong interval returned for %v. Expected %v, got %v", i.Advised, i.Exp, d)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	gopifinder "github.com/brumawen/gopi-finder/src"
@@ -15,17 +18,24 @@ import (
 
 // Server defines the Calendar Web Service.
 type Server struct {
-	PortNo         int               // Port No the server will listen on
-	VerboseLogging bool              // Verbose logging on/ off
-	Timeout        int               // Timeout waiting for a response from an IP probe.  Defaults to 2 seconds.
-	Config         *Config           // Configuration settings
-	NoReg          bool              // Do not register with the finder server
-	Finder         gopifinder.Finder // Finder client - used to find other devices
-	exit           chan struct{}     // Exit flag
-	shutdown       chan struct{}     // Shutdown complete flag
-	http           *http.Server      // HTTP server
-	router         *mux.Router       // HTTP router
-	isregistering  bool              // Indicates that a registration is currently ongoing
+	PortNo         int                // Port No the server will listen on
+	VerboseLogging bool               // Verbose logging on/ off
+	Timeout        int                // Timeout waiting for a response from an IP probe or calendar provider.  Defaults to 2 seconds.
+	Config         *Config            // Configuration settings
+	NoReg          bool               // Do not register with the finder server
+	Finder         gopifinder.Finder  // Finder client - used to find other devices
+	Cache          *EventCache        // Cache of the retrieved calendar events
+	Store          *EventStore        // Store of the retrieved calendar events and their changes
+	Webhooks       *WebhookDispatcher // Posts the changes to the calendar events to the webhooks
+	Scheduler      *Scheduler         // Scheduler that refreshes the calendar events in the background
+	ctx            context.Context    // Context that is cancelled when the service stops
+	cancel         context.CancelFunc // Cancels outstanding calendar fetches
+	exit           chan struct{}      // Exit flag
+	shutdown       chan struct{}      // Shutdown complete flag
+	workers        sync.WaitGroup     // Background workers, which are stopped before the event store is closed
+	http           *http.Server       // HTTP server
+	router         *mux.Router        // HTTP router
+	isregistering  bool               // Indicates that a registration is currently ongoing
 }
 
 // Start is called when the service is starting
@@ -51,6 +61,9 @@ func (s *Server) Start(v service.Service) error {
 		}
 	}
 
+	// Create a context that will be used to cancel outstanding calendar fetches
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
 	// Create a channel that will be used to block until the Stop signal is received
 	s.exit = make(chan struct{})
 	go s.run()
@@ -60,6 +73,8 @@ func (s *Server) Start(v service.Service) error {
 // Stop is called when the service is stopping
 func (s *Server) Stop(v service.Service) error {
 	s.logInfo("Service stopping")
+	// Cancel any outstanding calendar fetches
+	s.cancel()
 	// Close the channel, this will automatically release the block
 	s.shutdown = make(chan struct{})
 	close(s.exit)
@@ -73,6 +88,9 @@ func (s *Server) run() {
 	if s.PortNo < 0 {
 		s.PortNo = 20513
 	}
+	if s.Timeout <= 0 {
+		s.Timeout = 2
+	}
 	s.Finder.Logger = logger
 	s.Finder.VerboseLogging = service.Interactive()
 
@@ -83,6 +101,24 @@ func (s *Server) run() {
 	s.Config.ReadFromFile("config.json")
 	s.Config.SetDefaults()
 
+	// Open the event store
+	st, err := OpenEventStore("events.db")
+	if err != nil {
+		s.logError("Error opening the event store.", err.Error())
+	} else {
+		s.Store = st
+	}
+
+	// Start refreshing the calendars in the background
+	s.Webhooks = NewWebhookDispatcher(s)
+	s.Cache = NewEventCache()
+	s.Cache.Load(s.Config.Calendars, s.Store)
+	s.Scheduler = NewScheduler(s, s.Cache)
+	s.startWorker(s.Scheduler.Run)
+	s.startWorker(NewChangeWatcher(s).Run)
+	s.startWorker(NewReminderEngine(s).Run)
+	s.startWorker(NewMQTTPublisher(s).Run)
+
 	// Create a router
 	s.router = mux.NewRouter().StrictSlash(true)
 	s.router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("./html/assets"))))
@@ -91,11 +127,19 @@ func (s *Server) run() {
 	s.addController(new(LogController))
 	s.addController(new(ConfigController))
 	s.addController(new(CalendarController))
+	s.addController(new(EventController))
+	s.addController(new(AgendaController))
+	s.addController(new(GridController))
+	s.addController(new(RenderController))
+	s.addController(new(ChangeController))
+	s.addController(new(WebhookController))
 
-	// Create an HTTP server
+	// Create an HTTP server.  The request contexts derive from the service context, so that the calendar
+	// fetches of outstanding requests are cancelled when the service stops.
 	s.http = &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.PortNo),
-		Handler: s.router,
+		Addr:        fmt.Sprintf(":%d", s.PortNo),
+		Handler:     s.router,
+		BaseContext: func(net.Listener) context.Context { return s.ctx },
 	}
 
 	if s.NoReg {
@@ -123,12 +167,32 @@ func (s *Server) run() {
 	_ = <-s.exit
 
 	// Shutdown the HTTP server
-	s.http.Shutdown(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.http.Shutdown(ctx)
+
+	// Wait for the background workers and webhook deliveries to stop, before closing the event store
+	s.workers.Wait()
+	s.Webhooks.Wait()
+	if s.Store != nil {
+		if err := s.Store.Close(); err != nil {
+			s.logError("Error closing the event store.", err.Error())
+		}
+	}
 
 	s.logDebug("Shutdown complete")
 	close(s.shutdown)
 }
 
+// startWorker runs the background worker until the service stops.
+func (s *Server) startWorker(run func(exit <-chan struct{})) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		run(s.exit)
+	}()
+}
+
 // AddController adds the specified web service controller to the Router
 func (s *Server) addController(c Controller) {
 	c.AddController(s.router, s)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// testBlockingProvider is a calendar provider that does not respond until its fetch is cancelled.
+type testBlockingProvider struct {
+	testProvider
+}
+
+// testBlockingFetches receives the start of each fetch of the blocking provider for a window in 2018.
+var testBlockingFetches = make(chan struct{}, 1)
+
+func init() {
+	RegisterProvider(ProviderInfo{
+		Name: "TestBlocking",
+		New:  func() CalendarProvider { return new(testBlockingProvider) },
+	})
+}
+
+func (p *testBlockingProvider) ProviderName() string { return "TestBlocking" }
+
+func (p *testBlockingProvider) GetEvents(ctx context.Context, start time.Time, end time.Time) (CalEvents, error) {
+	if start.Year() == 2018 {
+		select {
+		case testBlockingFetches <- struct{}{}:
+		default:
+		}
+	}
+	<-ctx.Done()
+	return NewCalEvents(start, end), ctx.Err()
+}
+
+func TestStopCancelsRequestFetches(t *testing.T) {
+	logger = service.ConsoleLogger
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	// The request timeout is longer than the time allowed for the HTTP server to shut down
+	s := &Server{PortNo: port, Timeout: 30, NoReg: true, Config: &Config{Calendars: []CalConfig{{ID: "testblocking", Provider: "TestBlocking"}}}}
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		u := fmt.Sprintf("http://127.0.0.1:%d/calendar/events?from=2018-03-01T00:00:00Z&to=2018-03-08T00:00:00Z", port)
+		for i := 0; ; i++ {
+			resp, err := http.Get(u)
+			if err == nil {
+				resp.Body.Close()
+				done <- nil
+				return
+			}
+			if i == 100 {
+				done <- err
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-testBlockingFetches:
+	case err := <-done:
+		t.Fatalf("Request finished before the service stopped. %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request fetch not started")
+	}
+
+	st := time.Now()
+	s.Stop(nil)
+	if d := time.Since(st); d > 3*time.Second {
+		t.Errorf("Stop waited %v for the request fetch", d)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Request failed. %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Error("Request fetch not cancelled when the service stopped")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookSink posts reminders as JSON to a URL, signed in the same way as the change webhooks.
+type webhookSink struct {
+	Config SinkConfig
+	Client *http.Client
+}
+
+// ntfySink publishes reminders to an ntfy topic, or to any server with an ntfy compatible API.
+type ntfySink struct {
+	Config SinkConfig
+	Client *http.Client
+}
+
+func init() {
+	RegisterSinkType("webhook", func(c SinkConfig) (ReminderSink, error) {
+		if err := validateSinkURL(c.URL); err != nil {
+			return nil, err
+		}
+		return &webhookSink{Config: c, Client: &http.Client{Timeout: 10 * time.Second}}, nil
+	})
+	RegisterSinkType("ntfy", func(c SinkConfig) (ReminderSink, error) {
+		if err := validateSinkURL(c.URL); err != nil {
+			return nil, err
+		}
+		return &ntfySink{Config: c, Client: &http.Client{Timeout: 10 * time.Second}}, nil
+	})
+}
+
+// Send posts the reminder to the URL.
+func (s *webhookSink) Send(ctx context.Context, r Reminder) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.Config.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("X-Calendar-Event", "reminder")
+	if s.Config.Secret != "" {
+		req.Header.Set("X-Calendar-Signature", signWebhookPayload(s.Config.Secret, b))
+	}
+	return sendSinkRequest(ctx, s.Client, req)
+}
+
+// Send publishes the reminder message to the topic, with the summary of the event as the title.
+func (s *ntfySink) Send(ctx context.Context, r Reminder) error {
+	req, err := http.NewRequest("POST", s.Config.URL, bytes.NewReader([]byte(r.Message)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "text/plain; charset=utf-8")
+	req.Header.Set("Title", r.Title)
+	req.Header.Set("Tags", "calendar")
+	switch {
+	case s.Config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+s.Config.Token)
+	case s.Config.Username != "":
+		req.SetBasicAuth(s.Config.Username, s.Config.Password)
+	}
+	return sendSinkRequest(ctx, s.Client, req)
+}
+
+// sendSinkRequest sends the request, and returns an error if the server does not return a success status.
+func sendSinkRequest(ctx context.Context, c *http.Client, req *http.Request) error {
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// validateSinkURL returns an error if the URL is not a valid http or https URL.
+func validateSinkURL(v string) error {
+	if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("A valid http or https URL must be specified")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttSink publishes reminders as JSON to an MQTT topic.
+type mqttSink struct {
+	Config SinkConfig
+}
+
+// defaultReminderTopic is the MQTT topic reminders are published to if the sink does not specify one.
+const defaultReminderTopic = "calendar/reminder"
+
+func init() {
+	RegisterSinkType("mqtt", func(c SinkConfig) (ReminderSink, error) {
+		if u, err := url.Parse(c.URL); err != nil || u.Host == "" {
+			return nil, errors.New("A valid MQTT server URL must be specified, e.g. tcp://localhost:1883")
+		}
+		if c.Topic == "" {
+			c.Topic = defaultReminderTopic
+		}
+		return &mqttSink{Config: c}, nil
+	})
+}
+
+// Send connects to the server and publishes the reminder.
+func (s *mqttSink) Send(ctx context.Context, r Reminder) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	timeout := 10 * time.Second
+	if dl, ok := ctx.Deadline(); ok {
+		timeout = time.Until(dl)
+	}
+
+	o := mqtt.NewClientOptions().
+		AddBroker(s.Config.URL).
+		SetClientID(fmt.Sprintf("calendar-%d", time.Now().UnixNano())).
+		SetUsername(s.Config.Username).
+		SetPassword(s.Config.Password).
+		SetConnectTimeout(timeout).
+		SetAutoReconnect(false)
+	c := mqtt.NewClient(o)
+	if err := waitMQTTToken(c.Connect(), timeout); err != nil {
+		return fmt.Errorf("Error connecting to MQTT server. %s", err.Error())
+	}
+	defer c.Disconnect(250)
+	if err := waitMQTTToken(c.Publish(s.Config.Topic, 1, false, b), timeout); err != nil {
+		return fmt.Errorf("Error publishing reminder. %s", err.Error())
+	}
+	return nil
+}
+
+// waitMQTTToken waits for the MQTT operation to complete, and returns its error.
+func waitMQTTToken(t mqtt.Token, timeout time.Duration) error {
+	if !t.WaitTimeout(timeout) {
+		return errors.New("Timed out waiting for the MQTT server")
+	}
+	return t.Error()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// smtpSink sends reminders by email.  Servers with smtp URLs are sent the email over STARTTLS if
+// they support it, and servers with smtps URLs are connected to over TLS.
+type smtpSink struct {
+	Config SinkConfig
+	Host   string // Host name of the server
+	Addr   string // Address of the server, including the port
+	TLS    bool   // Connect to the server over TLS
+}
+
+func init() {
+	RegisterSinkType("smtp", func(c SinkConfig) (ReminderSink, error) {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "smtp" && u.Scheme != "smtps") || u.Hostname() == "" {
+			return nil, errors.New("A valid smtp or smtps URL must be specified, e.g. smtp://mail.example.com:587")
+		}
+		if c.From == "" || len(c.To) == 0 {
+			return nil, errors.New("The from and to addresses must be specified")
+		}
+		s := &smtpSink{Config: c, Host: u.Hostname(), Addr: u.Host, TLS: u.Scheme == "smtps"}
+		if u.Port() == "" {
+			s.Addr = net.JoinHostPort(s.Host, "25")
+			if s.TLS {
+				s.Addr = net.JoinHostPort(s.Host, "465")
+			}
+		}
+		return s, nil
+	})
+}
+
+// Send emails the reminder to the to addresses.
+func (s *smtpSink) Send(ctx context.Context, r Reminder) error {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	if s.TLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: s.Host})
+	}
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !s.TLS {
+		if err := c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Config.Username, s.Config.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.Config.From); err != nil {
+		return err
+	}
+	for _, to := range s.Config.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.getMessage(r)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// getMessage returns the email message for the reminder.
+func (s *smtpSink) getMessage(r Reminder) []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "From: %s\r\n", s.Config.From)
+	fmt.Fprintf(b, "To: %s\r\n", strings.Join(s.Config.To, ", "))
+	fmt.Fprintf(b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Reminder: "+r.Title))
+	fmt.Fprintf(b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(r.Message, "\n", "\r\n", -1))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// WebhookDispatcher posts the changes found when refreshing the calendars to the configured webhooks.
+// Failed deliveries are retried in the background, and the most recent deliveries are kept in a log.
+type WebhookDispatcher struct {
+	Srv     *Server         // Server the dispatcher belongs to
+	Client  *http.Client    // Client used to post the changes
+	Retries []time.Duration // Delay before each retry of a failed delivery
+	mu      sync.Mutex
+	log     []*WebhookDelivery // Most recent deliveries, oldest first
+	wg      sync.WaitGroup     // Deliveries in the background
+}
+
+// WebhookDelivery records the delivery of a change to a webhook.
+type WebhookDelivery struct {
+	ID         string    `json:"id"`                   // Identifier of the delivery, sent in the X-Calendar-Delivery header
+	Webhook    string    `json:"webhook"`              // Identifier of the webhook
+	URL        string    `json:"url"`                  // URL the change was posted to
+	Type       string    `json:"type"`                 // Type of change, or test for test deliveries
+	Calendar   string    `json:"calendar,omitempty"`   // Identifier of the calendar that changed
+	Summary    string    `json:"summary,omitempty"`    // Summary of the event that changed
+	Status     string    `json:"status"`               // Status of the delivery, pending, delivered or failed
+	Attempts   int       `json:"attempts"`             // Number of attempts made
+	StatusCode int       `json:"statusCode,omitempty"` // HTTP status returned by the last attempt
+	Error      string    `json:"error,omitempty"`      // Error returned by the last attempt
+	Created    time.Time `json:"created"`              // Time the delivery was created
+	Updated    time.Time `json:"updated"`              // Time of the last attempt
+}
+
+// WebhookPayload is the JSON posted to a webhook for each change.
+type WebhookPayload struct {
+	Delivery string       `json:"delivery"`         // Identifier of the delivery
+	Webhook  string       `json:"webhook"`          // Identifier of the webhook
+	Time     time.Time    `json:"time"`             // Time the payload was created
+	Type     string       `json:"type"`             // Type of change, or test for test deliveries
+	Change   *EventChange `json:"change,omitempty"` // Change found, which is not included in test deliveries
+}
+
+// The statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// webhookLogSize is the number of deliveries kept in the delivery log.
+const webhookLogSize = 200
+
+// NewWebhookDispatcher creates a new webhook dispatcher for the server.  Failed deliveries are retried
+// 4 times, after 1 minute, 5 minutes, 30 minutes and 2 hours.
+func NewWebhookDispatcher(s *Server) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Srv:     s,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Retries: []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour},
+	}
+}
+
+// Send posts each change to the webhooks that it applies to.  The changes are delivered in the background.
+func (d *WebhookDispatcher) Send(cl []EventChange) {
+	cfg := d.Srv.Config.Snapshot()
+	loc := cfg.Location()
+	for _, wh := range cfg.Webhooks {
+		for _, ch := range cl {
+			if !d.applies(cfg.Calendars, wh, ch) {
+				continue
+			}
+			ch.Event.SetLocation(loc)
+			if ch.Previous != nil {
+				p := *ch.Previous
+				p.SetLocation(loc)
+				ch.Previous = &p
+			}
+			c := ch
+			del, b, err := d.newDelivery(wh, ch.Type, &c)
+			if err != nil {
+				d.logError(fmt.Sprintf("Error creating delivery for webhook %s. %s", wh.URL, err.Error()))
+				continue
+			}
+			d.wg.Add(1)
+			go func(wh Webhook) {
+				defer d.wg.Done()
+				d.deliver(wh, del, b)
+			}(wh)
+		}
+	}
+}
+
+// Wait waits for the deliveries in the background to finish.  Deliveries stop being retried when the
+// server stops.
+func (d *WebhookDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Test posts a test payload to the webhook, without retrying, and returns the delivery.
+func (d *WebhookDispatcher) Test(ctx context.Context, wh Webhook) (WebhookDelivery, error) {
+	del, b, err := d.newDelivery(wh, "test", nil)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	d.attempt(ctx, wh, del, b, true)
+	return d.getDelivery(del), nil
+}
+
+// Deliveries returns the deliveries in the log for the webhook, or for all the webhooks if the
+// identifier is blank, with the most recent first.
+func (d *WebhookDispatcher) Deliveries(id string) []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l := []WebhookDelivery{}
+	for i := len(d.log) - 1; i >= 0; i-- {
+		if id == "" || d.log[i].Webhook == id {
+			l = append(l, *d.log[i])
+		}
+	}
+	return l
+}
+
+// applies returns true if the change is of a type, and for a calendar, that the webhook is sent.
+func (d *WebhookDispatcher) applies(cals []CalConfig, wh Webhook, ch EventChange) bool {
+	if len(wh.Types) != 0 && !containsString(wh.Types, ch.Type) {
+		return false
+	}
+	if len(wh.Calendars) == 0 {
+		return true
+	}
+	name := ch.Event.Name
+	for _, cc := range cals {
+		if cc.ID == ch.Calendar {
+			name = cc.Name
+		}
+	}
+	for _, c := range wh.Calendars {
+		if c == ch.Calendar || strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// newDelivery creates the delivery and payload for the change, and adds the delivery to the log.
+func (d *WebhookDispatcher) newDelivery(wh Webhook, t string, ch *EventChange) (*WebhookDelivery, []byte, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating GUID. %s", err.Error())
+	}
+	now := time.Now()
+	b, err := json.Marshal(WebhookPayload{Delivery: id.String(), Webhook: wh.ID, Time: now, Type: t, Change: ch})
+	if err != nil {
+		return nil, nil, err
+	}
+	del := &WebhookDelivery{
+		ID:      id.String(),
+		Webhook: wh.ID,
+		URL:     wh.URL,
+		Type:    t,
+		Status:  DeliveryPending,
+		Created: now,
+		Updated: now,
+	}
+	if ch != nil {
+		del.Calendar = ch.Calendar
+		del.Summary = ch.Event.Summary
+	}
+
+	d.mu.Lock()
+	d.log = append(d.log, del)
+	if len(d.log) > webhookLogSize {
+		d.log = d.log[len(d.log)-webhookLogSize:]
+	}
+	d.mu.Unlock()
+	return del, b, nil
+}
+
+// deliver posts the payload to the webhook, and retries failed attempts after each retry delay.
+// Retrying stops when the server stops.
+func (d *WebhookDispatcher) deliver(wh Webhook, del *WebhookDelivery, b []byte) {
+	ctx := d.Srv.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for n := 0; ; n++ {
+		last := n >= len(d.Retries)
+		if d.attempt(ctx, wh, del, b, last) {
+			return
+		}
+		select {
+		case <-time.After(d.Retries[n]):
+		case <-ctx.Done():
+			d.mu.Lock()
+			del.Status = DeliveryFailed
+			d.mu.Unlock()
+			return
+		}
+	}
+}
+
+// attempt makes an attempt to post the payload to the webhook.  True is returned if the payload was
+// delivered, or if the delivery failed and must not be retried.  If last is set, a failed delivery
+// is not retried.
+func (d *WebhookDispatcher) attempt(ctx context.Context, wh Webhook, del *WebhookDelivery, b []byte, last bool) bool {
+	code, err := d.post(ctx, wh, del, b)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	del.Attempts++
+	del.Updated = time.Now()
+	del.StatusCode = code
+	del.Error = ""
+	switch {
+	case err == nil:
+		del.Status = DeliveryDelivered
+		return true
+	case code >= 400 && code < 500 && code != http.StatusTooManyRequests:
+		// The webhook rejected the payload, so retrying will not help
+		last = true
+	}
+	del.Error = err.Error()
+	if last {
+		del.Status = DeliveryFailed
+		d.logError(fmt.Sprintf("Error delivering %s change to webhook %s. %s", del.Type, wh.URL, err.Error()))
+	}
+	return last
+}
+
+// post posts the payload to the webhook URL, and returns the HTTP status returned.  An error is returned
+// if the payload could not be posted, or if the webhook did not return a success status.
+func (d *WebhookDispatcher) post(ctx context.Context, wh Webhook, del *WebhookDelivery, b []byte) (int, error) {
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("X-Calendar-Delivery", del.ID)
+	req.Header.Set("X-Calendar-Event", del.Type)
+	if wh.Secret != "" {
+		req.Header.Set("X-Calendar-Signature", signWebhookPayload(wh.Secret, b))
+	}
+	resp, err := d.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("Webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// getDelivery returns a copy of the delivery.
+func (d *WebhookDispatcher) getDelivery(del *WebhookDelivery) WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return *del
+}
+
+// signWebhookPayload returns the signature of the payload, sent in the X-Calendar-Signature header.
+// The signature is the hex encoded HMAC-SHA256 of the payload using the secret of the webhook, prefixed
+// with sha256=.
+func signWebhookPayload(secret string, b []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(b)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// maskWebhooks returns a copy of the webhooks without their secrets.
+func maskWebhooks(l []Webhook) []Webhook {
+	if l == nil {
+		return nil
+	}
+	ml := make([]Webhook, len(l))
+	for i, wh := range l {
+		wh.Secret = ""
+		ml[i] = wh
+	}
+	return ml
+}
+
+// containsWebhook returns true if the list holds the webhook with the identifier.
+func containsWebhook(l []Webhook, id string) bool {
+	for _, wh := range l {
+		if wh.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// logError logs an error message to the logger
+func (d *WebhookDispatcher) logError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("WebhookDispatcher: [Err] ", a[1:len(a)-1])
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWebhookReceiver records the payloads posted to it, and fails the first requests.
+type testWebhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	payloads []WebhookPayload
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func (rc *testWebhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(rc.status)
+		return
+	}
+	b, _ := ioutil.ReadAll(r.Body)
+	p := WebhookPayload{}
+	json.Unmarshal(b, &p)
+	rc.payloads = append(rc.payloads, p)
+	rc.headers = append(rc.headers, r.Header)
+	rc.bodies = append(rc.bodies, b)
+}
+
+func (rc *testWebhookReceiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.payloads)
+}
+
+func TestWebhooksAreSignedAndRetried(t *testing.T) {
+	rc := &testWebhookReceiver{failures: 1, status: http.StatusServiceUnavailable}
+	rs := httptest.NewServer(rc)
+	defer rs.Close()
+
+	s, _ := newTestServer(CalConfig{ID: "testhook1", Name: "Home", Provider: "Test"}, CalConfig{ID: "testhook2", Name: "Work", Provider: "Test"})
+	s.Config.Webhooks = []Webhook{
+		{ID: "all", URL: rs.URL, Secret: "s3cret", Calendars: []string{"home"}},
+		{ID: "cancelled", URL: rs.URL, Types: []string{ChangeCancelled}},
+	}
+	s.Webhooks.Retries = []time.Duration{10 * time.Millisecond}
+
+	st := time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)
+	s.Webhooks.Send([]EventChange{
+		{Seq: 1, Calendar: "testhook1", Type: ChangeAdded, Event: CalEvent{UID: "dentist", Summary: "Dentist", Start: st, End: st.Add(time.Hour)}},
+		{Seq: 2, Calendar: "testhook2", Type: ChangeAdded, Event: CalEvent{UID: "meeting", Summary: "Meeting", Start: st, End: st.Add(time.Hour)}},
+	})
+
+	for i := 0; i < 100 && rc.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := rc.count(); n != 1 {
+		t.Fatalf("Wrong number of changes delivered. Expected %d, got %d", 1, n)
+	}
+	p := rc.payloads[0]
+	if p.Webhook != "all" || p.Type != ChangeAdded || p.Change == nil || p.Change.Event.Summary != "Dentist" {
+		t.Errorf("Wrong payload delivered. %+v", p)
+	}
+	if p.Change != nil && p.Change.Event.Time != "09:00" {
+		t.Errorf("Event display values not set. Got time '%s'", p.Change.Event.Time)
+	}
+	if sig := rc.headers[0].Get("X-Calendar-Signature"); sig != signWebhookPayload("s3cret", rc.bodies[0]) {
+		t.Errorf("Wrong signature sent. Got '%s'", sig)
+	}
+	if rc.headers[0].Get("X-Calendar-Delivery") != p.Delivery {
+		t.Error("Delivery identifier not sent")
+	}
+
+	for i := 0; i < 100 && s.Webhooks.Deliveries("all")[0].Status != DeliveryDelivered; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	dl := s.Webhooks.Deliveries("all")
+	if len(dl) != 1 || dl[0].Attempts != 2 {
+		t.Fatalf("Retried delivery not logged. %+v", dl)
+	}
+	if dl[0].Status != DeliveryDelivered || dl[0].StatusCode != http.StatusOK {
+		t.Errorf("Wrong delivery status logged. Got %s %d", dl[0].Status, dl[0].StatusCode)
+	}
+	if dl := s.Webhooks.Deliveries("cancelled"); len(dl) != 0 {
+		t.Errorf("Change delivered to a webhook for other types of change. %+v", dl)
+	}
+}
+
+func TestCanTestWebhook(t *testing.T) {
+	rc := &testWebhookReceiver{}
+	rs := httptest.NewServer(rc)
+	defer rs.Close()
+	bad := httptest.NewServer(&testWebhookReceiver{failures: 10, status: http.StatusBadRequest})
+	defer bad.Close()
+
+	s, router := newTestServer()
+	s.Config.Webhooks = []Webhook{{ID: "good", URL: rs.URL, Secret: "s3cret"}, {ID: "bad", URL: bad.URL}}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhook/test/good", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	d := WebhookDelivery{}
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != DeliveryDelivered || rc.count() != 1 || rc.payloads[0].Type != "test" {
+		t.Errorf("Test payload not delivered. %+v", d)
+	}
+
+	// Rejected payloads are not retried
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhook/test/bad", nil))
+	json.Unmarshal(w.Body.Bytes(), &d)
+	if d.Status != DeliveryFailed || d.Attempts != 1 || d.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong delivery returned for a failed test. %+v", d)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/webhook/deliveries", nil))
+	dl := []WebhookDelivery{}
+	if err := json.Unmarshal(w.Body.Bytes(), &dl); err != nil {
+		t.Fatal(err)
+	}
+	if len(dl) != 2 || dl[0].Webhook != "bad" {
+		t.Errorf("Wrong deliveries logged. %+v", dl)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/config/webhooks", nil))
+	if strings.Contains(w.Body.String(), "s3cret") {
+		t.Error("Webhook secret returned")
+	}
+}
+
+func TestWebhooksCanBeChangedWhileSending(t *testing.T) {
+	if _, err := os.Stat("config.json"); err == nil {
+		t.Skip("config.json already exists")
+	}
+	defer os.Remove("config.json")
+	rs := httptest.NewServer(&testWebhookReceiver{})
+	defer rs.Close()
+
+	s, router := newTestServer(CalConfig{ID: "testrace", Name: "Race"})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", "/config/webhook", strings.NewReader(`{"url":"`+rs.URL+`","types":["added"]}`)))
+			if w.Code != http.StatusOK {
+				t.Errorf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+				return
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			s.Webhooks.Send([]EventChange{{Calendar: "testrace", Type: ChangeModified}})
+		}
+	}
+	if l := s.Config.Snapshot().Webhooks; len(l) != 20 {
+		t.Errorf("Wrong number of webhooks saved. Expected 20, got %d", len(l))
+	}
+}
+
+func TestWebhooksFollowRenamedCalendars(t *testing.T) {
+	if _, err := os.Stat("config.json"); err == nil {
+		t.Skip("config.json already exists")
+	}
+	defer os.Remove("config.json")
+	rc := &testWebhookReceiver{}
+	rs := httptest.NewServer(rc)
+	defer rs.Close()
+
+	s, router := newTestServer(CalConfig{ID: "testrename1", Name: "Home", Provider: "Test"}, CalConfig{ID: "testrename2", Name: "Work", Provider: "Test"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/config/webhook", strings.NewReader(`{"url":"`+rs.URL+`","calendars":["Home"]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if l := s.Config.Snapshot().Webhooks; len(l) != 1 || strings.Join(l[0].Calendars, ",") != "testrename1" {
+		t.Fatalf("Webhook calendars not saved by identifier. %+v", l)
+	}
+	// Webhooks saved by older versions refer to calendars by name
+	s.Config.Webhooks = append(s.Config.Webhooks, Webhook{ID: "old", URL: rs.URL, Calendars: []string{"Work"}})
+
+	for _, id := range []string{"testrename1", "testrename2"} {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/config/update", strings.NewReader("updID="+id+"&updName=Renamed+"+id+"&updColour=Red"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status returned. Expected %d, got %d. %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	st := time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)
+	s.Webhooks.Send([]EventChange{
+		{Seq: 1, Calendar: "testrename1", Type: ChangeAdded, Event: CalEvent{UID: "dentist", Summary: "Dentist", Start: st, End: st.Add(time.Hour)}},
+		{Seq: 2, Calendar: "testrename2", Type: ChangeAdded, Event: CalEvent{UID: "meeting", Summary: "Meeting", Start: st, End: st.Add(time.Hour)}},
+	})
+	for i := 0; i < 100 && rc.count() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := rc.count(); n != 2 {
+		t.Errorf("Changes to renamed calendars not delivered. Expected 2, got %d", n)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+)
+
+// WebhookController handles the Web Methods for configuring and testing the webhooks.
+type WebhookController struct {
+	Srv *Server
+}
+
+// AddController adds the controller routes to the router
+func (c *WebhookController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/config/webhooks").Name("GetWebhooks").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetWebhooks)))
+	router.Methods("POST").Path("/config/webhook").Name("SaveWebhook").
+		Handler(Logger(c, http.HandlerFunc(c.handleSaveWebhook)))
+	router.Methods("POST").Path("/config/webhook/remove/{id}").Name("RemoveWebhook").
+		Handler(Logger(c, http.HandlerFunc(c.handleRemoveWebhook)))
+	router.Methods("POST").Path("/webhook/test/{id}").Name("TestWebhook").
+		Handler(Logger(c, http.HandlerFunc(c.handleTestWebhook)))
+	router.Methods("GET").Path("/webhook/deliveries").Name("GetDeliveries").
+		Handler(Logger(c, http.HandlerFunc(c.handleGetDeliveries)))
+}
+
+func (c *WebhookController) handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	l := maskWebhooks(c.Srv.Config.Snapshot().Webhooks)
+	if l == nil {
+		l = []Webhook{}
+	}
+	c.writeJSON(w, l, "webhooks")
+}
+
+func (c *WebhookController) handleSaveWebhook(w http.ResponseWriter, r *http.Request) {
+	wh := Webhook{}
+	if err := json.NewDecoder(r.Body).Decode(&wh); err != nil {
+		http.Error(w, "Invalid webhook. "+err.Error(), 500)
+		return
+	}
+	if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "A valid http or https URL must be specified", 500)
+		return
+	}
+	for i, t := range wh.Types {
+		wh.Types[i] = strings.ToLower(t)
+		if t := wh.Types[i]; t != ChangeAdded && t != ChangeModified && t != ChangeCancelled {
+			http.Error(w, fmt.Sprintf("Invalid change type '%s'.  Use added, modified or cancelled", t), 500)
+			return
+		}
+	}
+	// The calendars are saved by identifier, so that the webhook is still sent when they are renamed
+	ids, err := (&CalendarController{Srv: c.Srv}).resolveCalendars(wh.Calendars)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(ids) == 0 {
+		ids = nil
+	}
+	wh.Calendars = ids
+
+	// Replace the webhook with the same identifier, keeping its secret if a new one is not specified,
+	// or add it with a new identifier
+	if wh.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			http.Error(w, "Error creating GUID. "+err.Error(), 500)
+			return
+		}
+		wh.ID = id.String()
+	} else if !containsWebhook(c.Srv.Config.Snapshot().Webhooks, wh.ID) {
+		http.Error(w, "Invalid webhook identifier", 500)
+		return
+	}
+	err = c.Srv.Config.Update("config.json", func(cfg *Config) {
+		whl := []Webhook{}
+		found := false
+		for _, i := range cfg.Webhooks {
+			if i.ID == wh.ID {
+				if wh.Secret == "" {
+					wh.Secret = i.Secret
+				}
+				i = wh
+				found = true
+			}
+			whl = append(whl, i)
+		}
+		if !found {
+			whl = append(whl, wh)
+		}
+		cfg.Webhooks = whl
+	})
+	if err != nil {
+		m := fmt.Sprintf("Error writing config.json file. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	c.LogInfo(fmt.Sprintf("Webhook %s saved.", wh.URL))
+	c.writeJSON(w, maskWebhooks([]Webhook{wh})[0], "webhook")
+}
+
+func (c *WebhookController) handleRemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !containsWebhook(c.Srv.Config.Snapshot().Webhooks, id) {
+		http.Error(w, "Invalid webhook identifier", 500)
+		return
+	}
+	err := c.Srv.Config.Update("config.json", func(cfg *Config) {
+		whl := []Webhook{}
+		for _, i := range cfg.Webhooks {
+			if i.ID != id {
+				whl = append(whl, i)
+			}
+		}
+		cfg.Webhooks = whl
+	})
+	if err != nil {
+		m := fmt.Sprintf("Error writing config.json file. %s", err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+		return
+	}
+	c.LogInfo(fmt.Sprintf("Webhook %s removed.", id))
+}
+
+func (c *WebhookController) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	for _, wh := range c.Srv.Config.Snapshot().Webhooks {
+		if wh.ID == id {
+			del, err := c.Srv.Webhooks.Test(r.Context(), wh)
+			if err != nil {
+				m := fmt.Sprintf("Error testing webhook. %s", err.Error())
+				c.LogError(m)
+				http.Error(w, m, 500)
+				return
+			}
+			c.writeJSON(w, del, "delivery")
+			return
+		}
+	}
+	http.Error(w, "Invalid webhook identifier", 500)
+}
+
+func (c *WebhookController) handleGetDeliveries(w http.ResponseWriter, r *http.Request) {
+	c.writeJSON(w, c.Srv.Webhooks.Deliveries(r.URL.Query().Get("webhook")), "deliveries")
+}
+
+// writeJSON serializes the value and writes it to the http response.
+func (c *WebhookController) writeJSON(w http.ResponseWriter, v interface{}, name string) {
+	if b, err := json.Marshal(v); err != nil {
+		m := fmt.Sprintf("Error serializing %s. %s", name, err.Error())
+		c.LogError(m)
+		http.Error(w, m, 500)
+	} else {
+		w.Header().Set("content-type", "application/json")
+		w.Write(b)
+	}
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *WebhookController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Info("WebhookController: [Inf] ", a[1:len(a)-1])
+}
+
+// LogError is used to log error messages for this controller.
+func (c *WebhookController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v)
+	logger.Error("WebhookController: [Err] ", a[1:len(a)-1])
+}